@@ -1,45 +1,141 @@
+package extension
+
 // package main
 
 // import (
 // 	"context"
 // 	"fmt"
 // 	"os"
-
-// 	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
-// 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+// 	"time"
 // )
 
 // func main() {
+// 	// Every failure exits with a stable code from ExitCode: 2 for configuration, 3 for preflight,
+// 	// 10-18 for classified API and input errors (see errors.go)
+// 	if err := run(); err != nil {
+// 		fmt.Fprintf(os.Stderr, "%v\n", err)
+// 		os.Exit(ExitCode(err))
+// 	}
+// }
+
+// // run returns instead of exiting, so the deferred manifest save runs on every path
+// func run() (err error) {
+// 	// "config" prints every resolved setting with its source (flag, env, profile or default),
+// 	// secrets redacted, and exits without calling Datadog; invalid settings are reported after them
+// 	if len(os.Args) > 1 && os.Args[1] == "config" {
+// 		if err := PrintResolvedConfig(context.Background(), os.Stdout, LoadOptions{}); err != nil {
+// 			return fmt.Errorf("configuration error: %w", err)
+// 		}
+// 		return nil
+// 	}
+
 // 	// Load configuration from environment variables
 // 	config, err := LoadConfig()
 // 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
-// 		os.Exit(1)
+// 		return fmt.Errorf("configuration error: %w", err)
+// 	}
+// 	// Route library output through the configured log handler
+// 	logger, err := NewLogger(config.Logging, os.Stdout)
+// 	if err != nil {
+// 		return fmt.Errorf("logging error: %w", err)
+// 	}
+// 	SetLogger(logger)
+
+// 	// Retry rate limits and server errors on every listing and tagging call of this config's
+// 	// clients (each org of ORGS_FILE gets a copy; NewSecurityMonitoringClient attaches it)
+// 	config.Middlewares = append(DefaultMiddlewares(), RetryMiddleware(NewRetryPolicy(3, time.Second)))
+
+// 	// With ORGS_FILE set, run list, match and tag for every org of the inventory instead
+// 	// (RunOrg saves a manifest per org, also when the org fails)
+// 	if config.OrgsFile != "" {
+// 		inventory, err := LoadOrgsInventory(config.OrgsFile)
+// 		if err != nil {
+// 			return fmt.Errorf("orgs error: %w", err)
+// 		}
+//
+// 		// With COMPARE_SOURCE_ORG and COMPARE_TARGET_ORG set, compare two orgs and optionally
+// 		// write SYNC_INPUT_FILE, an input.json that gives the target the source's tags
+// 		if config.Compare.SourceOrg != "" {
+// 			if _, err := CompareInventoryOrgs(context.Background(), config, inventory); err != nil {
+// 				return fmt.Errorf("compare error: %w", err)
+// 			}
+// 			return nil
+// 		}
+// 		if _, err := RunOrgs(context.Background(), config, inventory); err != nil {
+// 			return fmt.Errorf("multi-org error: %w", err)
+// 		}
+// 		return nil
+// 	}
+
+// 	// With SNAPSHOT_FILE set to a saved *_ListRulesResult.json (or its directory), match and plan
+// 	// offline: no credentials, no network, and the plan is saved like a dry-run tagging result
+// 	// (DiffListingSnapshots turns two such snapshots into a JSON and Markdown rule changelog)
+// 	if config.SnapshotFile != "" {
+// 		plan, err := RunOffline(context.Background(), config)
+// 		if err != nil {
+// 			return fmt.Errorf("offline error: %w", err)
+// 		}
+// 		fmt.Printf("Planned tag changes for %d rules\n", plan.SuccessfulTags)
+// 		return nil
+// 	}
+
+// 	// Record every artifact and stage of this run in a manifest. It is saved on every path,
+// 	// failed runs included, with the error that ended the run.
+// 	manifest := NewRunManifest(config)
+// 	defer func() {
+// 		manifest.Finish(err)
+// 		if manifestFile, saveErr := manifest.Save(); saveErr != nil {
+// 			fmt.Fprintf(os.Stderr, "Manifest error: %v\n", saveErr)
+// 		} else if manifestFile != "" {
+// 			fmt.Printf("Run %s manifest: %s\n", manifest.RunID, manifestFile)
+// 		}
+// 	}()
+
+// 	// Build the API context and client from config without touching os.Environ.
+// 	// DD_CASSETTE_MODE=record saves every request and response to DD_CASSETTE_FILE;
+// 	// DD_CASSETTE_MODE=replay serves them back, so the whole run works offline.
+// 	ctx, api, err := NewSecurityMonitoringClient(context.Background(), config)
+// 	if err != nil {
+// 		return fmt.Errorf("client error: %w", err)
 // 	}
-// 	// Set Datadog environment variables for the API client
-// 	config.SetDatadogEnvironment()
+// 	// Save the recording once on every path, so a failed run can be replayed too
+// 	defer func() {
+// 		if err := config.CloseCassette(); err != nil {
+// 			fmt.Fprintf(os.Stderr, "Cassette error: %v\n", err)
+// 		}
+// 	}()
 
-// 	ctx := datadog.NewDefaultContext(context.Background())
-// 	configuration := datadog.NewConfiguration()
-// 	apiClient := datadog.NewAPIClient(configuration)
-// 	api := datadogV2.NewSecurityMonitoringApi(apiClient)
+// 	// Fail fast on a bad key or a missing scope before anything is listed
+// 	if !config.SkipPreflight {
+// 		if _, err := RunPreflight(ctx, config, api); err != nil {
+// 			return err
+// 		}
+// 	}
 
+// 	// Listing and tagging accept any RuleStore: api here, or NewMemoryRuleStore and the
+// 	// read-only LoadSnapshotRuleStore to run without a Datadog org
 // 	fmt.Println("Processing paginated lists of security monitoring rules...")
 
-// 	listResult, err := ProcessRuleListing(ctx, api, config.Pagination)
+// 	listResult, err := ProcessRuleListing(ctx, api, config.Pagination, config.Output)
 // 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Listing error: %v\n", err)
-// 		os.Exit(1)
+// 		return fmt.Errorf("listing error: %w", err)
+// 	}
+// 	// With RULE_CACHE_FILE set, only changed rules are stored again, and within RULE_CACHE_TTL
+// 	// listing is skipped altogether (RULE_CACHE_REFRESH=true forces a refresh); live tagging
+// 	// invalidates the rules it writes, so the next run lists them again
+// 	if listResult.Cache != nil {
+// 		fmt.Printf("Rule cache: %d hits, %d misses\n", listResult.Cache.Hits, listResult.Cache.Misses)
 // 	}
 
 // 	// Process rule matching with input.json
 // 	matchResult, err := ProcessRuleMatching(
+// 		ctx,
 // 		"input.json", // input file
 // 		listResult,   // result from ProcessRuleListing
+// 		config.Output,
 // 	)
 // 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Rule matching error: %v\n", err)
-// 		os.Exit(1)
+// 		return fmt.Errorf("rule matching error: %w", err)
 // 	}
 
 // 	// Process rule tagging
@@ -49,11 +145,12 @@
 // 		api,
 // 		matchResult,
 // 		config.Tagging,
+// 		config.Output,
 // 	)
 // 	if err != nil {
-// 		fmt.Fprintf(os.Stderr, "Rule tagging error: %v\n", err)
-// 		os.Exit(1)
+// 		return fmt.Errorf("rule tagging error: %w", err)
 // 	}
 
 // 	fmt.Printf("Tagging process for %d rules completed! Check for details.\n", taggingResult.SuccessfulTags)
-// }
\ No newline at end of file
+// 	return nil
+// }
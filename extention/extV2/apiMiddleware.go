@@ -0,0 +1,234 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_io "io"
+	_nethttp "net/http"
+	_sort "sort"
+	_strconv "strconv"
+	_sync "sync"
+	_time "time"
+)
+
+// LoggingMiddleware logs failed calls at error level with their diagnostics: kind, status, request ID,
+// the Datadog error messages or a trimmed body. The redacted response summary is logged at debug level.
+func LoggingMiddleware() Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+			started := _time.Now()
+			resp, r, err := next(ctx, call)
+			if err != nil {
+				LoggerFrom(ctx).Error(_fmt.Sprintf("Error when calling `%s.%s`", call.APIName, call.MethodName), "diagnostics", classifyAPIError(call, r, err))
+				LoggerFrom(ctx).Debug("HTTP response", "response", responseLogValue(r))
+				return resp, r, err
+			}
+			LoggerFrom(ctx).Debug(_fmt.Sprintf("Called `%s.%s`", call.APIName, call.MethodName), "durationMs", _time.Since(started).Milliseconds())
+			return resp, r, err
+		}
+	}
+}
+
+// RetryPolicy decides after a failed attempt (attempt starts at 1) whether to try again and after what delay
+type RetryPolicy func(call APICall, attempt int, r *_nethttp.Response, err error) (_time.Duration, bool)
+
+// NewRetryPolicy retries network errors, 429 and 5xx responses up to maxAttempts attempts in total,
+// doubling baseDelay each time and honouring X-RateLimit-Reset on 429
+func NewRetryPolicy(maxAttempts int, baseDelay _time.Duration) RetryPolicy {
+	return func(_ APICall, attempt int, r *_nethttp.Response, _ error) (_time.Duration, bool) {
+		if attempt >= maxAttempts {
+			return 0, false
+		}
+		if r != nil && r.StatusCode != _nethttp.StatusTooManyRequests && r.StatusCode < 500 {
+			return 0, false
+		}
+
+		delay := baseDelay << (attempt - 1)
+		if r != nil && r.StatusCode == _nethttp.StatusTooManyRequests {
+			if reset, err := _strconv.Atoi(r.Header.Get("X-RateLimit-Reset")); err == nil && reset > 0 {
+				delay = _time.Duration(reset) * _time.Second
+			}
+		}
+		return delay, true
+	}
+}
+
+// RetryMiddleware repeats failed calls as long as policy allows, stopping early when ctx is cancelled
+func RetryMiddleware(policy RetryPolicy) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+			for attempt := 1; ; attempt++ {
+				resp, r, err := next(ctx, call)
+				if err == nil {
+					return resp, r, nil
+				}
+				delay, retry := policy(call, attempt, r, err)
+				if !retry {
+					return resp, r, err
+				}
+
+				LoggerFrom(ctx).Warn(_fmt.Sprintf("Retrying `%s.%s`", call.APIName, call.MethodName), "attempt", attempt, "delay", delay, "error", err)
+				select {
+				case <-ctx.Done():
+					return resp, r, ctx.Err()
+				case <-_time.After(delay):
+				}
+			}
+		}
+	}
+}
+
+// CallMetric describes one API call for metrics
+type CallMetric struct {
+	APIName    string
+	MethodName string
+	StatusCode int // 0 when no response was received
+	Duration   _time.Duration
+	Err        error
+}
+
+// MetricsMiddleware reports every call, including each retried attempt when placed inside RetryMiddleware
+func MetricsMiddleware(observe func(metric CallMetric)) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+			started := _time.Now()
+			resp, r, err := next(ctx, call)
+			metric := CallMetric{
+				APIName:    call.APIName,
+				MethodName: call.MethodName,
+				Duration:   _time.Since(started),
+				Err:        err,
+			}
+			if r != nil {
+				metric.StatusCode = r.StatusCode
+			}
+			observe(metric)
+			return resp, r, err
+		}
+	}
+}
+
+// MethodStats aggregates the calls of one API method
+type MethodStats struct {
+	Method        string         `json:"method"`
+	Calls         int            `json:"calls"`
+	Errors        int            `json:"errors"`
+	StatusCodes   map[int]int    `json:"statusCodes"`
+	TotalDuration _time.Duration `json:"totalDuration"`
+}
+
+// CallStats is an in-memory metrics sink for MetricsMiddleware
+type CallStats struct {
+	mu      _sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// NewCallStats creates an empty CallStats
+func NewCallStats() *CallStats {
+	return &CallStats{methods: make(map[string]*MethodStats)}
+}
+
+// Observe records one call; pass it to MetricsMiddleware
+func (s *CallStats) Observe(metric CallMetric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method := metric.APIName + "." + metric.MethodName
+	stats, ok := s.methods[method]
+	if !ok {
+		stats = &MethodStats{Method: method, StatusCodes: make(map[int]int)}
+		s.methods[method] = stats
+	}
+	stats.Calls++
+	if metric.Err != nil {
+		stats.Errors++
+	}
+	stats.StatusCodes[metric.StatusCode]++
+	stats.TotalDuration += metric.Duration
+}
+
+// Snapshot returns the stats of every method, sorted by method name
+func (s *CallStats) Snapshot() []MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]MethodStats, 0, len(s.methods))
+	for _, stats := range s.methods {
+		copied := *stats
+		copied.StatusCodes = make(map[int]int, len(stats.StatusCodes))
+		for code, count := range stats.StatusCodes {
+			copied.StatusCodes[code] = count
+		}
+		snapshot = append(snapshot, copied)
+	}
+	_sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Method < snapshot[j].Method })
+	return snapshot
+}
+
+// Tracer starts a span for a call and returns the context to run it with and a function that ends the span
+type Tracer func(ctx _context.Context, call APICall) (_context.Context, func(r *_nethttp.Response, err error))
+
+// TracingMiddleware runs every call inside a span started by tracer, for example an OpenTelemetry adapter
+func TracingMiddleware(tracer Tracer) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+			spanCtx, end := tracer(ctx, call)
+			resp, r, err := next(spanCtx, call)
+			end(r, err)
+			return resp, r, err
+		}
+	}
+}
+
+// CallRecord is one API call written by RecordingMiddleware
+type CallRecord struct {
+	Time       _time.Time `json:"time"`
+	APIName    string     `json:"apiName"`
+	MethodName string     `json:"methodName"`
+	Method     string     `json:"method,omitempty"`
+	URL        string     `json:"url,omitempty"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	DurationMs int64      `json:"durationMs"`
+	Response   any        `json:"response,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// RecordingMiddleware writes every call as one JSON line to w, including the decoded response when
+// includeResponse is set. Request headers are never recorded, so credentials stay out of recordings.
+func RecordingMiddleware(w _io.Writer, includeResponse bool) Middleware {
+	var mu _sync.Mutex
+	encoder := _encodingjson.NewEncoder(w)
+	return func(next Invoker) Invoker {
+		return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+			started := _time.Now()
+			resp, r, err := next(ctx, call)
+
+			record := CallRecord{
+				Time:       started.UTC(),
+				APIName:    call.APIName,
+				MethodName: call.MethodName,
+				DurationMs: _time.Since(started).Milliseconds(),
+			}
+			if r != nil {
+				record.StatusCode = r.StatusCode
+				if r.Request != nil {
+					record.Method = r.Request.Method
+					record.URL = r.Request.URL.String()
+				}
+			}
+			if err != nil {
+				record.Error = err.Error()
+			} else if includeResponse {
+				record.Response = resp
+			}
+
+			mu.Lock()
+			if encodeErr := encoder.Encode(record); encodeErr != nil {
+				LoggerFrom(ctx).Debug("failed to record API call", "method", call.MethodName, "error", encodeErr)
+			}
+			mu.Unlock()
+			return resp, r, err
+		}
+	}
+}
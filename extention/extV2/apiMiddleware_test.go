@@ -0,0 +1,218 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_logslog "log/slog"
+	_nethttp "net/http"
+	_neturl "net/url"
+	_strconv "strconv"
+	_strings "strings"
+	_testing "testing"
+	_time "time"
+)
+
+// statusResponse returns a response with status and headers, as sent for a request with a DD-API-KEY header
+func statusResponse(status int, header _nethttp.Header) *_nethttp.Response {
+	if header == nil {
+		header = _nethttp.Header{}
+	}
+	return &_nethttp.Response{
+		StatusCode: status,
+		Status:     _strconv.Itoa(status) + " " + _nethttp.StatusText(status),
+		Header:     header,
+		Request: &_nethttp.Request{
+			Method: _nethttp.MethodGet,
+			URL:    &_neturl.URL{Scheme: "https", Host: "api.datadoghq.com", Path: "/api/v2/security_monitoring/rules"},
+			Header: _nethttp.Header{"Dd-Api-Key": {"api-secret"}},
+		},
+	}
+}
+
+func TestNewRetryPolicy(t *_testing.T) {
+	policy := NewRetryPolicy(3, _time.Second)
+
+	tests := []struct {
+		name      string
+		attempt   int
+		r         *_nethttp.Response
+		wantDelay _time.Duration
+		wantRetry bool
+	}{
+		{name: "network error", attempt: 1, wantDelay: _time.Second, wantRetry: true},
+		{name: "server error backs off", attempt: 2, r: statusResponse(503, nil), wantDelay: 2 * _time.Second, wantRetry: true},
+		{name: "rate limit reset", attempt: 1, r: statusResponse(429, _nethttp.Header{"X-Ratelimit-Reset": {"7"}}), wantDelay: 7 * _time.Second, wantRetry: true},
+		{name: "rate limit without reset", attempt: 1, r: statusResponse(429, nil), wantDelay: _time.Second, wantRetry: true},
+		{name: "client error", attempt: 1, r: statusResponse(404, nil)},
+		{name: "last attempt", attempt: 3, r: statusResponse(503, nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			delay, retry := policy(APICall{}, tt.attempt, tt.r, _errors.New("failed"))
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("policy() = %v, %v; want %v, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestRetryMiddleware(t *_testing.T) {
+	call := &APICall{APIName: "FakeApi", MethodName: "GetThing"}
+	unavailable := func(_context.Context) (string, *_nethttp.Response, error) {
+		return "", statusResponse(503, nil), _errors.New("unavailable")
+	}
+
+	tests := []struct {
+		name      string
+		failures  int // Attempts failing before the call succeeds
+		cancel    bool
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "succeeds after retries", failures: 2, wantCalls: 3},
+		{name: "gives up", failures: 5, wantCalls: 3, wantErr: ErrServer},
+		{name: "cancelled while waiting", failures: 5, cancel: true, wantCalls: 1, wantErr: _context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			ctx, cancel := _context.WithCancel(_context.Background())
+			defer cancel()
+			delay := _time.Millisecond
+			if tt.cancel {
+				delay = _time.Hour
+			}
+			ctx = ContextWithMiddlewares(ctx, RetryMiddleware(NewRetryPolicy(3, delay)))
+
+			calls := 0
+			_, _, err := CallAPI(ctx, call, func(ctx _context.Context) (string, *_nethttp.Response, error) {
+				calls++
+				if tt.cancel {
+					cancel()
+				}
+				if calls <= tt.failures {
+					return unavailable(ctx)
+				}
+				return "thing", statusResponse(200, nil), nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !_errors.Is(err, tt.wantErr)) {
+				t.Errorf("CallAPI() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricsMiddleware(t *_testing.T) {
+	stats := NewCallStats()
+	ctx := ContextWithMiddlewares(_context.Background(), MetricsMiddleware(stats.Observe))
+	get := &APICall{APIName: "FakeApi", MethodName: "GetThing"}
+	list := &APICall{APIName: "FakeApi", MethodName: "ListThings"}
+
+	for _, status := range []int{200, 404, 200} {
+		CallAPI(ctx, get, func(_context.Context) (string, *_nethttp.Response, error) {
+			if status != 200 {
+				return "", statusResponse(status, nil), _errors.New("failed")
+			}
+			return "thing", statusResponse(status, nil), nil
+		})
+	}
+	CallAPI(ctx, list, func(_context.Context) (string, *_nethttp.Response, error) {
+		return "", nil, _errors.New("no route")
+	})
+
+	snapshot := stats.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Method != "FakeApi.GetThing" || snapshot[1].Method != "FakeApi.ListThings" {
+		t.Fatalf("Snapshot() = %+v, want one entry per method sorted by name", snapshot)
+	}
+	if got := snapshot[0]; got.Calls != 3 || got.Errors != 1 || got.StatusCodes[200] != 2 || got.StatusCodes[404] != 1 {
+		t.Errorf("GetThing stats = %+v", got)
+	}
+	if got := snapshot[1]; got.Calls != 1 || got.Errors != 1 || got.StatusCodes[0] != 1 {
+		t.Errorf("ListThings stats = %+v, want a call without a status", got)
+	}
+
+	// The snapshot is a copy
+	snapshot[0].StatusCodes[200] = 99
+	if stats.Snapshot()[0].StatusCodes[200] != 2 {
+		t.Error("Snapshot() shares its status counts with the stats")
+	}
+}
+
+func TestTracingMiddleware(t *_testing.T) {
+	type spanKey struct{}
+	var ended []string
+	tracer := func(ctx _context.Context, call APICall) (_context.Context, func(*_nethttp.Response, error)) {
+		return _context.WithValue(ctx, spanKey{}, call.MethodName), func(r *_nethttp.Response, err error) {
+			ended = append(ended, call.MethodName)
+		}
+	}
+
+	ctx := ContextWithMiddlewares(_context.Background(), TracingMiddleware(tracer))
+	span, _, err := CallAPI(ctx, &APICall{APIName: "FakeApi", MethodName: "GetThing"}, func(ctx _context.Context) (any, *_nethttp.Response, error) {
+		return ctx.Value(spanKey{}), nil, nil
+	})
+	if err != nil || span != "GetThing" {
+		t.Errorf("call ran with span %v (error %v), want GetThing", span, err)
+	}
+	if len(ended) != 1 {
+		t.Errorf("ended %d spans, want 1", len(ended))
+	}
+}
+
+func TestRecordingMiddleware(t *_testing.T) {
+	var buf _bytes.Buffer
+	ctx := ContextWithMiddlewares(_context.Background(), RecordingMiddleware(&buf, true))
+	call := &APICall{APIName: "FakeApi", MethodName: "GetThing"}
+
+	CallAPI(ctx, call, func(_context.Context) (string, *_nethttp.Response, error) {
+		return "thing", statusResponse(200, nil), nil
+	})
+	CallAPI(ctx, call, func(_context.Context) (string, *_nethttp.Response, error) {
+		return "", statusResponse(403, nil), _errors.New("forbidden")
+	})
+
+	if _strings.Contains(buf.String(), "api-secret") {
+		t.Errorf("recording holds the API key:\n%s", buf.String())
+	}
+	lines := _strings.Split(_strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("recorded %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var records [2]CallRecord
+	for i, line := range lines {
+		if err := _encodingjson.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+	}
+	if got := records[0]; got.MethodName != "GetThing" || got.Method != "GET" || got.StatusCode != 200 || got.Response != "thing" || got.Error != "" {
+		t.Errorf("successful call recorded as %+v", got)
+	}
+	if got := records[1]; got.StatusCode != 403 || got.Response != nil || got.Error != "forbidden" {
+		t.Errorf("failed call recorded as %+v", got)
+	}
+}
+
+func TestLoggingMiddleware(t *_testing.T) {
+	var buf _bytes.Buffer
+	logger, _ := NewLogger(LogConfig{Format: LogFormatText, Level: _logslog.LevelDebug}, &buf)
+	ctx := ContextWithLogger(ContextWithMiddlewares(_context.Background(), LoggingMiddleware()), logger)
+
+	CallAPI(ctx, &APICall{APIName: "FakeApi", MethodName: "GetThing"}, func(_context.Context) (string, *_nethttp.Response, error) {
+		return "", statusResponse(404, _nethttp.Header{"X-Datadog-Request-Id": {"req-1"}}), _errors.New("missing")
+	})
+
+	for _, want := range []string{"Error when calling `FakeApi.GetThing`", "kind=not-found", "requestId=req-1", "HTTP response"} {
+		if !_strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, buf.String())
+		}
+	}
+	if _strings.Contains(buf.String(), "api-secret") {
+		t.Errorf("log holds the API key:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,251 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_io "io"
+	_nethttp "net/http"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_sync "sync"
+	_time "time"
+)
+
+// CassetteMode selects whether Datadog HTTP traffic is recorded to or replayed from a cassette file
+type CassetteMode string
+
+const (
+	CassetteOff    CassetteMode = ""       // Talk to Datadog normally
+	CassetteRecord CassetteMode = "record" // Talk to Datadog and save every request and response
+	CassetteReplay CassetteMode = "replay" // Serve saved responses; no request leaves the process
+)
+
+// cassetteVersion is written to every cassette so the format can change later
+const cassetteVersion = 1
+
+// CassetteRequest is the redacted request of a recorded interaction
+type CassetteRequest struct {
+	Method  string          `json:"method"`
+	URL     string          `json:"url"`
+	Headers _nethttp.Header `json:"headers,omitempty"`
+	Body    string          `json:"body,omitempty"`
+}
+
+// CassetteResponse is the response of a recorded interaction
+type CassetteResponse struct {
+	StatusCode int             `json:"statusCode"`
+	Status     string          `json:"status"`
+	Headers    _nethttp.Header `json:"headers,omitempty"`
+	Body       string          `json:"body,omitempty"`
+}
+
+// CassetteInteraction is one request and its response
+type CassetteInteraction struct {
+	Request    CassetteRequest  `json:"request"`
+	Response   CassetteResponse `json:"response"`
+	RecordedAt _time.Time       `json:"recordedAt"`
+	DurationMs int64            `json:"durationMs"`
+}
+
+// Cassette holds the recorded HTTP interactions of a run. Credential headers are redacted before saving.
+type Cassette struct {
+	Version      int                   `json:"version"`
+	Interactions []CassetteInteraction `json:"interactions"`
+
+	mu       _sync.Mutex
+	mode     CassetteMode
+	filename string
+	fileMode _os.FileMode
+	used     []bool // Interactions already served in replay mode
+}
+
+// OpenCassette returns a new cassette of a file for the mode: empty when recording, the loaded
+// interactions when replaying. Every call returns its own cassette, so a run opens it once and
+// shares it between its clients, as Config.OpenCassette does.
+func OpenCassette(filename string, mode CassetteMode) (*Cassette, error) {
+	cassette := &Cassette{Version: cassetteVersion, Interactions: []CassetteInteraction{}, filename: filename, fileMode: 0600}
+	switch mode {
+	case CassetteRecord:
+	case CassetteReplay:
+		var err error
+		if cassette, err = LoadCassette(filename); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, _fmt.Errorf("unknown cassette mode %q (expected record or replay)", mode)
+	}
+	cassette.mode = mode
+	return cassette, nil
+}
+
+// LoadCassette reads a cassette file written in record mode
+func LoadCassette(filename string) (*Cassette, error) {
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read cassette %s: %w", filename, err)
+	}
+	cassette := &Cassette{}
+	if err := _encodingjson.Unmarshal(data, cassette); err != nil {
+		return nil, NewInputFormatError("LoadCassette", _fmt.Errorf("failed to parse cassette %s: %w", filename, err))
+	}
+	if cassette.Version != cassetteVersion {
+		return nil, NewInputFormatError("LoadCassette", _fmt.Errorf("cassette %s has version %d, expected %d", filename, cassette.Version, cassetteVersion))
+	}
+	cassette.mode = CassetteReplay
+	cassette.filename = filename
+	cassette.fileMode = 0600
+	cassette.used = make([]bool, len(cassette.Interactions))
+	return cassette, nil
+}
+
+// Save writes the cassette to its file
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+// Close saves a recording once at the end of the run; a replayed cassette is left as it was
+func (c *Cassette) Close() error {
+	if c.mode != CassetteRecord {
+		return nil
+	}
+	return c.Save()
+}
+
+func (c *Cassette) save() error {
+	data, err := _encodingjson.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return _fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if dir := _pathfilepath.Dir(c.filename); dir != "." {
+		if err := _os.MkdirAll(dir, 0755); err != nil {
+			return _fmt.Errorf("failed to create cassette directory: %w", err)
+		}
+	}
+	if err := _os.WriteFile(c.filename, data, c.fileMode); err != nil {
+		return _fmt.Errorf("failed to write cassette %s: %w", c.filename, err)
+	}
+	return nil
+}
+
+// Transport returns the HTTP transport of the mode: recording wraps next, replaying never calls it
+func (c *Cassette) Transport(mode CassetteMode, next _nethttp.RoundTripper) _nethttp.RoundTripper {
+	if mode == CassetteReplay {
+		return &replayTransport{cassette: c}
+	}
+	return &recordTransport{cassette: c, next: next}
+}
+
+// recordTransport sends requests with next and appends every exchange to the cassette; Close saves them
+type recordTransport struct {
+	cassette *Cassette
+	next     _nethttp.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *_nethttp.Request) (*_nethttp.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	started := _time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// Network failures are not recorded; replay reports the missing interaction instead
+		return nil, err
+	}
+	responseBody, err := _io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read response body for the cassette: %w", err)
+	}
+	resp.Body = _io.NopCloser(_bytes.NewReader(responseBody))
+
+	interaction := CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     req.URL.RequestURI(),
+			Headers: RedactHeaders(req.Header),
+			Body:    string(requestBody),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Headers:    RedactHeaders(resp.Header),
+			Body:       string(responseBody),
+		},
+		RecordedAt: started.UTC(),
+		DurationMs: _time.Since(started).Milliseconds(),
+	}
+
+	t.cassette.mu.Lock()
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction)
+	t.cassette.mu.Unlock()
+	return resp, nil
+}
+
+// replayTransport serves the first unused interaction with the same method, path, query and body.
+// Repeated identical requests get their responses in recording order, independent of goroutine timing.
+type replayTransport struct {
+	cassette *Cassette
+}
+
+func (t *replayTransport) RoundTrip(req *_nethttp.Request) (*_nethttp.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	uri := req.URL.RequestURI()
+
+	t.cassette.mu.Lock()
+	defer t.cassette.mu.Unlock()
+	for i, interaction := range t.cassette.Interactions {
+		if t.cassette.used[i] || interaction.Request.Method != req.Method || interaction.Request.URL != uri ||
+			!sameRequestBody(interaction.Request.Body, requestBody) {
+			continue
+		}
+		t.cassette.used[i] = true
+		return &_nethttp.Response{
+			Status:        interaction.Response.Status,
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Headers.Clone(),
+			Body:          _io.NopCloser(_bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, _fmt.Errorf("cassette %s has no unused interaction for %s %s", t.cassette.filename, req.Method, uri)
+}
+
+// readRequestBody reads the body of a request and puts an identical reader back
+func readRequestBody(req *_nethttp.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := _io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read request body for the cassette: %w", err)
+	}
+	req.Body = _io.NopCloser(_bytes.NewReader(body))
+	return body, nil
+}
+
+// sameRequestBody compares JSON bodies by value, so key order and whitespace do not matter
+func sameRequestBody(recorded string, body []byte) bool {
+	if recorded == string(body) {
+		return true
+	}
+	var recordedValue, value any
+	if _encodingjson.Unmarshal([]byte(recorded), &recordedValue) != nil || _encodingjson.Unmarshal(body, &value) != nil {
+		return false
+	}
+	recordedJSON, _ := _encodingjson.Marshal(recordedValue)
+	valueJSON, _ := _encodingjson.Marshal(value)
+	return _bytes.Equal(recordedJSON, valueJSON)
+}
@@ -0,0 +1,231 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_sort "sort"
+	_sync "sync"
+)
+
+// StageCompare names the cross-org comparison in output files and the run manifest
+const StageCompare = "CompareResult"
+
+// RuleTagDifference is a rule present in both orgs whose tags differ
+type RuleTagDifference struct {
+	Name            string   `json:"name"`
+	IsDefault       bool     `json:"isDefault"`
+	SourceID        string   `json:"sourceId"`
+	TargetID        string   `json:"targetId"`
+	SourceTags      []string `json:"sourceTags"`
+	TargetTags      []string `json:"targetTags"`
+	MissingInTarget []string `json:"missingInTarget,omitempty"` // Source tags the target rule lacks
+	ExtraInTarget   []string `json:"extraInTarget,omitempty"`   // Target tags the source rule lacks
+}
+
+// CompareResult joins the rules of two orgs on name and isDefault
+type CompareResult struct {
+	SourceOrg      string              `json:"sourceOrg"`
+	TargetOrg      string              `json:"targetOrg"`
+	SourceRules    int                 `json:"sourceRules"`
+	TargetRules    int                 `json:"targetRules"`
+	CommonRules    int                 `json:"commonRules"`
+	OnlyInSource   []SimplifiedRule    `json:"onlyInSource"`
+	OnlyInTarget   []SimplifiedRule    `json:"onlyInTarget"`
+	TagDifferences []RuleTagDifference `json:"tagDifferences"`
+}
+
+// CompareConfig selects the two orgs of a comparison from the orgs inventory
+type CompareConfig struct {
+	SourceOrg     string // Org whose tags are the reference
+	TargetOrg     string // Org compared against the source
+	SyncInputFile string // Where to write an input.json that syncs the target's tags to the source's
+}
+
+// CompareRules joins two listing results with RuleMatchKey and reports one-sided rules and tag differences.
+// Tags are compared as sets; results are sorted by rule name.
+func CompareRules(source *PaginatedResult, target *PaginatedResult, sourceOrg string, targetOrg string) *CompareResult {
+	result := &CompareResult{
+		SourceOrg:      sourceOrg,
+		TargetOrg:      targetOrg,
+		SourceRules:    len(source.Rules),
+		TargetRules:    len(target.Rules),
+		OnlyInSource:   []SimplifiedRule{},
+		OnlyInTarget:   []SimplifiedRule{},
+		TagDifferences: []RuleTagDifference{},
+	}
+
+	targetRuleMap := make(map[string]SimplifiedRule, len(target.Rules))
+	for _, rule := range target.Rules {
+		targetRuleMap[RuleMatchKey(rule.Name, rule.IsDefault)] = rule
+	}
+
+	matchedTargetKeys := make(map[string]bool)
+	for _, sourceRule := range source.Rules {
+		key := RuleMatchKey(sourceRule.Name, sourceRule.IsDefault)
+		targetRule, exists := targetRuleMap[key]
+		if !exists {
+			result.OnlyInSource = append(result.OnlyInSource, sourceRule)
+			continue
+		}
+		matchedTargetKeys[key] = true
+		result.CommonRules++
+
+		diff := diffTags(targetRule.Tags, sourceRule.Tags)
+		if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+			continue
+		}
+		result.TagDifferences = append(result.TagDifferences, RuleTagDifference{
+			Name:            sourceRule.Name,
+			IsDefault:       sourceRule.IsDefault,
+			SourceID:        sourceRule.ID,
+			TargetID:        targetRule.ID,
+			SourceTags:      sourceRule.Tags,
+			TargetTags:      targetRule.Tags,
+			MissingInTarget: diff.Added,
+			ExtraInTarget:   diff.Removed,
+		})
+	}
+
+	for _, rule := range target.Rules {
+		if !matchedTargetKeys[RuleMatchKey(rule.Name, rule.IsDefault)] {
+			result.OnlyInTarget = append(result.OnlyInTarget, rule)
+		}
+	}
+
+	sortRules := func(rules []SimplifiedRule) {
+		_sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	}
+	sortRules(result.OnlyInSource)
+	sortRules(result.OnlyInTarget)
+	_sort.Slice(result.TagDifferences, func(i, j int) bool {
+		return result.TagDifferences[i].Name < result.TagDifferences[j].Name
+	})
+
+	return result
+}
+
+// SyncInput returns an input.json that gives every common rule with differing tags the source org's tags.
+// Tagging appends by default, so tags only the target has are removed only with OVERWRITE_TAGS=true.
+func (r *CompareResult) SyncInput() *InputData {
+	input := &InputData{FailedRules: []string{}, Rules: []InputRule{}}
+	for _, difference := range r.TagDifferences {
+		input.Rules = append(input.Rules, InputRule{
+			IsDefault: difference.IsDefault,
+			Name:      difference.Name,
+			Tags:      difference.SourceTags,
+		})
+	}
+	input.TotalRules = len(input.Rules)
+	input.ProcessedRules = len(input.Rules)
+	return input
+}
+
+// FormatCompareSummary formats the comparison counts for display
+func FormatCompareSummary(result *CompareResult) string {
+	return _fmt.Sprintf("\n=== Org Comparison: %s -> %s ===\n"+
+		"Source Rules: %d\n"+
+		"Target Rules: %d\n"+
+		"Common Rules: %d\n"+
+		"Only in %s: %d\n"+
+		"Only in %s: %d\n"+
+		"Tag Differences: %d\n",
+		result.SourceOrg, result.TargetOrg,
+		result.SourceRules,
+		result.TargetRules,
+		result.CommonRules,
+		result.SourceOrg, len(result.OnlyInSource),
+		result.TargetOrg, len(result.OnlyInTarget),
+		len(result.TagDifferences))
+}
+
+// SaveInputJSON writes input data in the input.json format read by LoadInputJSON
+func SaveInputJSON(input *InputData, filename string, output OutputConfig) error {
+	output = output.withDefaults()
+	jsonBytes, err := _encodingjson.MarshalIndent(input, "", "  ")
+	if err != nil {
+		return _fmt.Errorf("failed to marshal input data: %w", err)
+	}
+	return writeOutputFile(string(jsonBytes), filename, output.FileMode, output.DirMode)
+}
+
+// CompareOrgs lists the rules of two orgs concurrently, each into its own output, and compares them.
+// The comparison is saved with output; when config.SyncInputFile is set the sync input.json is written there too.
+func CompareOrgs(ctx _context.Context, source *Config, target *Config, config CompareConfig, output OutputConfig) (_ *CompareResult, err error) {
+	finishStage := output.startStage(StageCompare)
+	defer func() { finishStage(err) }()
+
+	var sourceRules, targetRules *PaginatedResult
+	var sourceErr, targetErr error
+	var wg _sync.WaitGroup
+	list := func(orgConfig *Config, rules **PaginatedResult, listErr *error) {
+		defer wg.Done()
+		orgCtx, api, err := NewSecurityMonitoringClient(ctx, orgConfig)
+		if err != nil {
+			*listErr = err
+			return
+		}
+		*rules, *listErr = ProcessRuleListing(orgCtx, api, orgConfig.Pagination, orgConfig.Output)
+		if err := orgConfig.CloseCassette(); err != nil {
+			LoggerFrom(orgCtx).Warn("Failed to save cassette", "error", err)
+		}
+	}
+	wg.Add(2)
+	go list(source, &sourceRules, &sourceErr)
+	go list(target, &targetRules, &targetErr)
+	wg.Wait()
+
+	if sourceErr != nil {
+		return nil, _fmt.Errorf("failed to list rules of source org %s: %w", source.Output.Org, sourceErr)
+	}
+	if targetErr != nil {
+		return nil, _fmt.Errorf("failed to list rules of target org %s: %w", target.Output.Org, targetErr)
+	}
+
+	result := CompareRules(sourceRules, targetRules, source.Output.Org, target.Output.Org)
+
+	if _, err := output.SaveResult(result, StageCompare); err != nil {
+		LoggerFrom(ctx).Warn("failed to save comparison result", "error", err)
+	}
+
+	LoggerFrom(ctx).Info("Org comparison summary",
+		"sourceOrg", result.SourceOrg,
+		"targetOrg", result.TargetOrg,
+		"commonRules", result.CommonRules,
+		"onlyInSource", len(result.OnlyInSource),
+		"onlyInTarget", len(result.OnlyInTarget),
+		"tagDifferences", len(result.TagDifferences),
+		humanKey, FormatCompareSummary(result))
+
+	if config.SyncInputFile != "" {
+		syncInput := result.SyncInput()
+		if err := SaveInputJSON(syncInput, config.SyncInputFile, output); err != nil {
+			return result, _fmt.Errorf("failed to write sync input: %w", err)
+		}
+		LoggerFrom(ctx).Info("Wrote sync input", "file", config.SyncInputFile, "rules", syncInput.TotalRules)
+		for _, difference := range result.TagDifferences {
+			if len(difference.ExtraInTarget) > 0 {
+				LoggerFrom(ctx).Warn("Target has tags the source lacks; run tagging with OVERWRITE_TAGS=true to remove them",
+					"targetOrg", result.TargetOrg)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// CompareInventoryOrgs compares the orgs named by config.Compare, taking their sites and credentials from the inventory
+func CompareInventoryOrgs(ctx _context.Context, config *Config, inventory *OrgsInventory) (*CompareResult, error) {
+	orgConfigs := make([]*Config, 2)
+	for i, name := range []string{config.Compare.SourceOrg, config.Compare.TargetOrg} {
+		org, err := inventory.Org(name)
+		if err != nil {
+			return nil, err
+		}
+		if orgConfigs[i], err = config.ForOrg(org); err != nil {
+			return nil, err
+		}
+	}
+	return CompareOrgs(ctx, orgConfigs[0], orgConfigs[1], config.Compare, config.Output)
+}
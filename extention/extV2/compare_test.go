@@ -0,0 +1,78 @@
+package extV2
+
+import (
+	_reflect "reflect"
+	_testing "testing"
+)
+
+func TestCompareRules(t *_testing.T) {
+	source := &PaginatedResult{Rules: []SimplifiedRule{
+		{ID: "s-1", Name: "Rule B", IsDefault: true, Tags: []string{"team:a", "source:okta"}},
+		{ID: "s-2", Name: "Rule A", IsDefault: true, Tags: []string{"team:a"}},
+		{ID: "s-3", Name: "Custom", Tags: []string{"team:b"}},
+		{ID: "s-4", Name: "Same", IsDefault: true, Tags: []string{"x:1", "y:2"}},
+	}}
+	target := &PaginatedResult{Rules: []SimplifiedRule{
+		{ID: "t-1", Name: "Rule A", IsDefault: true, Tags: []string{"team:z"}},
+		{ID: "t-2", Name: "Rule B", IsDefault: true, Tags: []string{"source:okta"}},
+		{ID: "t-3", Name: "Custom", IsDefault: true, Tags: []string{"team:b"}}, // Same name, but a default rule
+		{ID: "t-4", Name: "Same", IsDefault: true, Tags: []string{"y:2", "x:1"}},
+	}}
+
+	result := CompareRules(source, target, "prod", "staging")
+
+	if result.SourceRules != 4 || result.TargetRules != 4 || result.CommonRules != 3 {
+		t.Errorf("counts = %d source, %d target, %d common; want 4, 4, 3", result.SourceRules, result.TargetRules, result.CommonRules)
+	}
+	if len(result.OnlyInSource) != 1 || result.OnlyInSource[0].ID != "s-3" {
+		t.Errorf("OnlyInSource = %+v, want the custom rule s-3", result.OnlyInSource)
+	}
+	if len(result.OnlyInTarget) != 1 || result.OnlyInTarget[0].ID != "t-3" {
+		t.Errorf("OnlyInTarget = %+v, want the default rule t-3", result.OnlyInTarget)
+	}
+
+	// Tag order does not matter, and differences are sorted by name
+	tests := []struct {
+		name        string
+		sourceID    string
+		targetID    string
+		wantMissing []string
+		wantExtra   []string
+	}{
+		{name: "Rule A", sourceID: "s-2", targetID: "t-1", wantMissing: []string{"team:a"}, wantExtra: []string{"team:z"}},
+		{name: "Rule B", sourceID: "s-1", targetID: "t-2", wantMissing: []string{"team:a"}},
+	}
+	if len(result.TagDifferences) != len(tests) {
+		t.Fatalf("TagDifferences = %+v, want %d", result.TagDifferences, len(tests))
+	}
+	for i, tt := range tests {
+		got := result.TagDifferences[i]
+		if got.Name != tt.name || got.SourceID != tt.sourceID || got.TargetID != tt.targetID {
+			t.Errorf("difference %d = %s (%s -> %s), want %s (%s -> %s)", i, got.Name, got.SourceID, got.TargetID, tt.name, tt.sourceID, tt.targetID)
+		}
+		if !_reflect.DeepEqual(got.MissingInTarget, tt.wantMissing) || !_reflect.DeepEqual(got.ExtraInTarget, tt.wantExtra) {
+			t.Errorf("%s: missing = %v, extra = %v; want %v, %v", tt.name, got.MissingInTarget, got.ExtraInTarget, tt.wantMissing, tt.wantExtra)
+		}
+	}
+
+	// The sync input gives the differing rules the source tags, keyed like the target's rules
+	input := result.SyncInput()
+	want := []InputRule{
+		{Name: "Rule A", IsDefault: true, Tags: []string{"team:a"}},
+		{Name: "Rule B", IsDefault: true, Tags: []string{"team:a", "source:okta"}},
+	}
+	if !_reflect.DeepEqual(input.Rules, want) || input.TotalRules != 2 || input.ProcessedRules != 2 {
+		t.Errorf("SyncInput() = %+v, want rules %+v", input, want)
+	}
+}
+
+func TestCompareRulesIdentical(t *_testing.T) {
+	rules := &PaginatedResult{Rules: []SimplifiedRule{{ID: "a", Name: "Rule A", IsDefault: true, Tags: []string{"team:a"}}}}
+	result := CompareRules(rules, rules, "prod", "prod")
+	if result.CommonRules != 1 || len(result.OnlyInSource) != 0 || len(result.OnlyInTarget) != 0 || len(result.TagDifferences) != 0 {
+		t.Errorf("CompareRules() of identical listings = %+v", result)
+	}
+	if input := result.SyncInput(); len(input.Rules) != 0 || input.Rules == nil {
+		t.Errorf("SyncInput() = %+v, want an empty rules list", input)
+	}
+}
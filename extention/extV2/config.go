@@ -1,8 +1,9 @@
 package extV2
 
 import (
-	_bufio "bufio"
+	_context "context"
 	_fmt "fmt"
+	_logslog "log/slog"
 	_os "os"
 	_strconv "strconv"
 	_strings "strings"
@@ -10,9 +11,14 @@ import (
 
 // SimplifiedRule represents a simplified security monitoring rule with only essential fields
 type SimplifiedRule struct {
-	ID        string `json:"id"`
-	IsDefault bool   `json:"isDefault"`
-	Name      string `json:"name"`
+	ID          string   `json:"id"`
+	IsDefault   bool     `json:"isDefault"`
+	Name        string   `json:"name"`
+	Tags        []string `json:"tags,omitempty"`
+	IsEnabled   *bool    `json:"isEnabled,omitempty"`   // Unset in listings written before it was recorded
+	Version     int64    `json:"version,omitempty"`     // Rule version, bumped by Datadog on every update
+	QueriesHash string   `json:"queriesHash,omitempty"` // Digest of the rule's queries, to detect changes
+	CasesHash   string   `json:"casesHash,omitempty"`   // Digest of the rule's cases, to detect changes
 }
 
 // PaginatedResult holds the results from all pages
@@ -20,6 +26,7 @@ type PaginatedResult struct {
 	TotalRules int              `json:"totalRules"`
 	TotalPages int              `json:"totalPages"`
 	Rules      []SimplifiedRule `json:"rules"`
+	Cache      *RuleCacheStats  `json:"cache,omitempty"` // Set when the listing used the rule cache
 }
 
 // PaginationConfig holds pagination settings
@@ -27,6 +34,7 @@ type PaginationConfig struct {
 	PageSize   int64
 	MaxPages   int64    // 0 means no limit
 	TagFilters []string // Optional tag filters (case-insenitive)
+	Cache      RuleCacheConfig
 }
 
 // TaggingConfig holds configuration for rule tagging
@@ -34,10 +42,27 @@ type TaggingConfig struct {
 	DryRun         bool     // If true, only simulate tagging without actual API calls
 	OverwriteTags  bool     // If true, replace existing tags; if false, append to existing tags
 	IncludedTags   []string // Tags to exclude from tagging (e.g., system tags)
-	MaxConcurrency int      // Maximum number of concurrent API calls
+	MaxConcurrency int      // Maximum number of rules planned or written at once, from MinMaxConcurrency to MaxMaxConcurrency
+	RuleCacheFile  string   // Rule cache of the listing; live writes invalidate the cached rules they changed
+	Safety         SafetyConfig
 }
 
-// Config holds application configuration from environment variables
+// OutputConfig holds settings for result files written by each stage
+type OutputConfig struct {
+	Formats          []OutputFormat // Formats written for every result (defaults to JSON)
+	Dir              string         // Output directory (defaults to "output")
+	FilenameTemplate string         // Basename template with {run_id}, {org}, {stage}, {time} and {date} placeholders
+	UTC              bool           // If true, {time} and {date} use UTC instead of local time
+	FileMode         _os.FileMode   // Permissions for result files (defaults to 0644)
+	DirMode          _os.FileMode   // Permissions for created directories (defaults to 0755)
+	NoFiles          bool           // If true, results are only returned and never written to disk
+	RunID            string         // Value for the {run_id} placeholder
+	Org              string         // Value for the {org} placeholder
+	Manifest         *RunManifest   `json:"-"` // Optional manifest that records every written file
+	Events           EventHandler   `json:"-"` // Optional receiver for progress events
+}
+
+// Config holds application configuration from flags, environment variables and profiles
 type Config struct {
 	DDSite            string
 	DDAPIKey          string
@@ -45,153 +70,303 @@ type Config struct {
 	InputRuleFilename string
 	Pagination        PaginationConfig
 	Tagging           TaggingConfig
+	Output            OutputConfig
+	Logging           LogConfig
+	HTTP              HTTPConfig
+	SkipPreflight     bool                     // Skip RunPreflight before listing
+	OrgsFile          string                   // Orgs inventory for a multi-org run; shared keys are then optional
+	MaxParallelOrgs   int                      // Orgs run at once by RunOrgs
+	Compare           CompareConfig            // Orgs of a cross-org comparison, taken from OrgsFile
+	SnapshotFile      string                   // Listing result (or directory of them) RunOffline plans against; keys are then optional
+	Profile           string                   // Name of the profile the config was loaded with, if any
+	Sources           map[string]ResolvedValue // Raw value and source of every configuration key
+	Middlewares       []Middleware             `json:"-"` // API call chain of this config's clients; nil keeps the chain of the parent context
+	Logger            *_logslog.Logger         `json:"-"` // Logger of this config's clients and stages; nil keeps the logger of the parent context
 }
 
-// LoadEnvFile loads environment variables from a .env file
-func LoadEnvFile(filename string) error {
-	file, err := _os.Open(filename)
+// LoadConfig loads configuration with .env file support, logging to Logger()
+func LoadConfig() (*Config, error) {
+	return LoadConfigWithOptions(_context.Background(), LoadOptions{})
+}
+
+// LoadConfigWithOptions loads configuration with precedence flags > env > profile > defaults.
+// The env files read are logged to the logger of ctx.
+func LoadConfigWithOptions(ctx _context.Context, options LoadOptions) (*Config, error) {
+	config, errs, err := resolveConfig(ctx, options)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// Refuse to start on any parse or validation error so a typo never changes behaviour silently
+	if len(errs) > 0 {
+		return nil, errs
 	}
-	defer file.Close()
-
-	scanner := _bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := _strings.TrimSpace(scanner.Text())
 
-		// Skip empty lines and comments
-		if line == "" || _strings.HasPrefix(line, "#") {
-			continue
+	// Open EVENTS_FD only now, so a config that fails to load leaves no open file behind
+	if resolved := config.Sources["EVENTS_FD"]; resolved.Value != "" {
+		fd, _ := _strconv.ParseUint(resolved.Value, 10, 32) // Validated by resolveConfig
+		if config.Output.Events, err = NewFDEventHandler(uintptr(fd)); err != nil {
+			return nil, ConfigErrors{{Key: "EVENTS_FD", Value: resolved.Value, Source: resolved.Source, Message: err.Error()}}
 		}
+	}
+	return config, nil
+}
 
-		// Split by first '=' character
-		parts := _strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+// resolveConfig builds the configuration and collects its parse and validation errors.
+// The config is returned along with them so they can be shown next to the resolved values;
+// err is set only when no config could be built, such as for a missing config file.
+func resolveConfig(ctx _context.Context, options LoadOptions) (*Config, ConfigErrors, error) {
+	profileName := options.Profile
+	if profileName == "" {
+		profileName = options.Flags["PROFILE"]
+	}
+	if profileName == "" {
+		profileName = _os.Getenv("PROFILE")
+	}
+
+	// Load .env files: explicit files must exist, otherwise .env.<profile> and .env are optional
+	envFiles := options.EnvFiles
+	if len(envFiles) == 0 {
+		for _, filename := range _strings.Split(_os.Getenv("ENV_FILE"), ",") {
+			if filename = _strings.TrimSpace(filename); filename != "" {
+				envFiles = append(envFiles, filename)
+			}
 		}
+	}
+	var optionalEnvFiles []string
+	if len(envFiles) == 0 {
+		if profileName != "" {
+			optionalEnvFiles = append(optionalEnvFiles, DefaultEnvFilename+"."+profileName)
+		}
+		optionalEnvFiles = append(optionalEnvFiles, DefaultEnvFilename)
+	}
+	envFileOrigins, err := LoadEnvFiles(ctx, envFiles, optionalEnvFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	if profileName == "" {
+		// A .env file may select the profile itself; its .env.<profile> then layers over .env
+		profileName = _os.Getenv("PROFILE")
+		if profileName != "" && len(envFiles) == 0 {
+			if err := loadProfileEnvFile(ctx, profileName, envFileOrigins); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
 
-		key := _strings.TrimSpace(parts[0])
-		value := _strings.TrimSpace(parts[1])
+	// Select the config file; it may also come from flags or env
+	configFilename := options.ConfigFile
+	if configFilename == "" {
+		configFilename = options.Flags["CONFIG_FILE"]
+	}
+	if configFilename == "" {
+		configFilename = _os.Getenv("CONFIG_FILE")
+	}
 
-		// Only set if environment variable is not already set
-		if _os.Getenv(key) == "" {
-			_os.Setenv(key, value)
+	var profileSettings map[string]string
+	if configFilename == "" {
+		if _, err := _os.Stat(DefaultConfigFilename); err == nil {
+			configFilename = DefaultConfigFilename
+		}
+	}
+	if configFilename != "" {
+		configFile, err := LoadConfigFile(configFilename)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, profile, err := configFile.Profile(profileName)
+		if err != nil {
+			return nil, nil, _fmt.Errorf("config file %s: %w", configFilename, err)
+		}
+		profileName = name
+		if profile != nil {
+			profileSettings = profile.settings()
 		}
+	} else if profileName != "" {
+		return nil, nil, _fmt.Errorf("profile %q requested but no config file found", profileName)
 	}
 
-	return scanner.Err()
-}
+	resolver := newConfigResolver(options.Flags, profileSettings)
+	resolver.envFileOrigins = envFileOrigins
+	parser := &configParser{resolver: resolver}
 
-// LoadConfig loads configuration with .env file support
-func LoadConfig() (*Config, error) {
-	// Try to load .env file (optional)
-	if err := LoadEnvFile(".env"); err != nil {
-		_fmt.Printf("Note: .env file not found or error loading: %v\n", err)
-	}
-	// Parse PageSize with default value
-	pageSize := int64(100) // Default value
-	if pageSizeStr := _os.Getenv("PAGE_SIZE"); pageSizeStr != "" {
-		if parsed, err := _strconv.ParseInt(pageSizeStr, 10, 64); err == nil {
-			pageSize = parsed
-		}
+	// Parse pagination settings (MaxPages 0 means no limit)
+	pageSize := parser.int64("PAGE_SIZE", 100)
+	maxPages := parser.int64("MAX_PAGES", 0)
+	tagFilters := parser.list("TAG_FILTERS")
+	ruleCache := RuleCacheConfig{
+		File:    resolver.get("RULE_CACHE_FILE"),
+		TTL:     parser.duration("RULE_CACHE_TTL", 0),
+		Refresh: parser.bool("RULE_CACHE_REFRESH", false),
 	}
 
-	// Parse MaxPages with default value (0 means no limit)
-	maxPages := int64(0) // Default value
-	if maxPagesStr := _os.Getenv("MAX_PAGES"); maxPagesStr != "" {
-		if parsed, err := _strconv.ParseInt(maxPagesStr, 10, 64); err == nil {
-			maxPages = parsed
-		}
+	// Parse tagging settings; dry run is the default so live writes are always an explicit choice
+	dryRun := parser.bool("DRYRUN", true)
+	skipPreflight := parser.bool("SKIP_PREFLIGHT", false)
+	orgsFile := resolver.get("ORGS_FILE")
+	snapshotFile := resolver.get("SNAPSHOT_FILE")
+	maxParallelOrgs := parser.int("MAX_PARALLEL_ORGS", DefaultMaxParallelOrgs)
+	compare := CompareConfig{
+		SourceOrg:     resolver.get("COMPARE_SOURCE_ORG"),
+		TargetOrg:     resolver.get("COMPARE_TARGET_ORG"),
+		SyncInputFile: resolver.get("SYNC_INPUT_FILE"),
 	}
+	overwriteTags := parser.bool("OVERWRITE_TAGS", false)
+	includedTags := parser.list("INCLUDED_TAGS")
+	maxConcurrency := parser.int("MAX_CONCURRENCY", 5)
 
-	// Parse TagFilters from environment variable (comma-separated)
-	var tagFilters []string
-	if tagFiltersStr := _os.Getenv("TAG_FILTERS"); tagFiltersStr != "" {
-		filters := _strings.Split(tagFiltersStr, ",")
-		for _, filter := range filters {
-			trimmed := _strings.TrimSpace(filter)
-			if trimmed != "" {
-				tagFilters = append(tagFilters, trimmed)
-			}
-		}
+	// Parse write safety guards (0 means no limit)
+	confirmWrites := parser.bool("CONFIRM_WRITES", false)
+	maxChanges := parser.int("MAX_CHANGES", 0)
+	maxChangesPercent := parser.float64("MAX_CHANGES_PERCENT", 0)
+	var confirm Confirmer
+	if parser.bool("CONFIRM_INTERACTIVE", false) {
+		confirm = NewInteractiveConfirmer(_os.Stdin, _os.Stderr)
 	}
 
-	// Parse DryRun setting from environment variable
-	dryRun := false // default value
-	if dryRunStr := _os.Getenv("DRYRUN"); dryRunStr != "" {
-		if parsed, err := _strconv.ParseBool(dryRunStr); err == nil {
-			dryRun = parsed
+	inputRuleFilename := parser.string("INPUT", "input.json")
+
+	// Parse output settings
+	outputFormats := []OutputFormat{OutputFormatJSON}
+	if outputFormatsStr := resolver.get("OUTPUT_FORMATS"); outputFormatsStr != "" {
+		parsed, err := ParseOutputFormats(outputFormatsStr)
+		if err != nil {
+			parser.fail("OUTPUT_FORMATS", err.Error())
+		} else if len(parsed) > 0 {
+			outputFormats = parsed
 		}
 	}
 
-	// Parse other tagging configurations
-	overwriteTags := false // default value (append mode)
-	if overwriteStr := _os.Getenv("OVERWRITE_TAGS"); overwriteStr != "" {
-		if parsed, err := _strconv.ParseBool(overwriteStr); err == nil {
-			overwriteTags = parsed
-		}
+	outputDir := parser.string("OUTPUT_DIR", DefaultOutputDir)
+	filenameTemplate := parser.string("OUTPUT_FILENAME_TEMPLATE", DefaultFilenameTemplate)
+
+	outputUTC := false // default value (local time)
+	switch timezone := _strings.ToLower(parser.string("OUTPUT_TIMEZONE", "local")); timezone {
+	case "utc":
+		outputUTC = true
+	case "local":
+	default:
+		parser.fail("OUTPUT_TIMEZONE", "must be utc or local")
 	}
 
-	var includedTags []string
-	if includedTagsStr := _os.Getenv("INCLUDED_TAGS"); includedTagsStr != "" {
-		tags := _strings.Split(includedTagsStr, ",")
-		for _, tag := range tags {
-			trimmed := _strings.TrimSpace(tag)
-			if trimmed != "" {
-				includedTags = append(includedTags, trimmed)
-			}
+	fileMode := parser.fileMode("OUTPUT_FILE_MODE", 0644)
+	dirMode := parser.fileMode("OUTPUT_DIR_MODE", 0755)
+	noFiles := parser.bool("NO_OUTPUT_FILES", false)
+
+	// Parse logging settings; QUIET and VERBOSE override LOG_LEVEL
+	logLevel := _logslog.LevelInfo // default value
+	if logLevelStr := resolver.get("LOG_LEVEL"); logLevelStr != "" {
+		parsed, err := ParseLogLevel(logLevelStr)
+		if err != nil {
+			parser.fail("LOG_LEVEL", "must be debug, info, warn or error")
 		}
+		logLevel = parsed
+	}
+	if parser.bool("QUIET", false) {
+		logLevel = _logslog.LevelWarn
+	}
+	if parser.bool("VERBOSE", false) {
+		logLevel = _logslog.LevelDebug
 	}
 
-	maxConcurrency := 5 // default value
-	if concurrencyStr := _os.Getenv("MAX_CONCURRENCY"); concurrencyStr != "" {
-		if parsed, err := _strconv.Atoi(concurrencyStr); err == nil && parsed > 0 {
-			maxConcurrency = parsed
+	logFormat, err := ParseLogFormat(parser.string("LOG_FORMAT", LogFormatHuman))
+	if err != nil {
+		parser.fail("LOG_FORMAT", "must be human, text or json")
+	}
+
+	// Stream NDJSON progress events to an inherited file descriptor if requested (opened by LoadConfigWithOptions)
+	if eventsFD := resolver.get("EVENTS_FD"); eventsFD != "" {
+		if _, err := _strconv.ParseUint(eventsFD, 10, 32); err != nil {
+			parser.fail("EVENTS_FD", "must be a file descriptor number")
 		}
 	}
 
-	inputRuleFilename := "input.json"
-	if inputrulefilenameStr := _os.Getenv("INPUT"); inputrulefilenameStr != "" {
-		inputRuleFilename = inputrulefilenameStr
+	runID := parser.string("RUN_ID", NewRunID())
+	// An unset DD_SITE shows up as a default in FormatResolvedConfig; LoadConfig itself does not log,
+	// since the caller's logger is not set up yet
+	site := parser.string("DD_SITE", DefaultDDSite)
+	org := resolver.get("DD_ORG")
+
+	// Keys set directly win over secret files, which win over the credential helper
+	credentialSources := options.CredentialSources
+	if credentialSources == nil {
+		credentialSources = []CredentialSource{
+			FileCredentialSource{Lookup: resolver.get},
+			HelperCredentialSource{
+				Command: resolver.get("DD_CREDENTIAL_HELPER"),
+				Env:     []string{"DD_SITE=" + site, "DD_ORG=" + org, "PROFILE=" + profileName},
+			},
+		}
 	}
 
 	config := &Config{
-		DDSite:            _os.Getenv("DD_SITE"),
-		DDAPIKey:          _os.Getenv("DD_API_KEY"),
-		DDAppKey:          _os.Getenv("DD_APP_KEY"),
+		DDSite:            site,
+		DDAPIKey:          resolveCredential(parser, "DD_API_KEY", credentialSources),
+		DDAppKey:          resolveCredential(parser, "DD_APP_KEY", credentialSources),
 		InputRuleFilename: inputRuleFilename,
 		Pagination: PaginationConfig{
 			PageSize:   pageSize,
 			MaxPages:   maxPages,
 			TagFilters: tagFilters,
+			Cache:      ruleCache,
 		},
 		Tagging: TaggingConfig{
 			DryRun:         dryRun,
 			OverwriteTags:  overwriteTags,
 			IncludedTags:   includedTags,
 			MaxConcurrency: maxConcurrency,
+			RuleCacheFile:  ruleCache.File,
+			Safety: SafetyConfig{
+				ConfirmWrites:     confirmWrites,
+				Confirm:           confirm,
+				MaxChanges:        maxChanges,
+				MaxChangesPercent: maxChangesPercent,
+			},
 		},
+		Output: OutputConfig{
+			Formats:          outputFormats,
+			Dir:              outputDir,
+			FilenameTemplate: filenameTemplate,
+			UTC:              outputUTC,
+			FileMode:         fileMode,
+			DirMode:          dirMode,
+			NoFiles:          noFiles,
+			Org:              org,
+			RunID:            runID,
+		},
+		Logging: LogConfig{
+			Level:  logLevel,
+			Format: logFormat,
+		},
+		HTTP: HTTPConfig{
+			BaseURL:         resolver.get("DD_BASE_URL"),
+			ProxyURL:        resolver.get("DD_PROXY_URL"),
+			CABundle:        resolver.get("DD_CA_BUNDLE"),
+			ClientCert:      resolver.get("DD_CLIENT_CERT"),
+			ClientKey:       resolver.get("DD_CLIENT_KEY"),
+			Timeout:         parser.duration("DD_REQUEST_TIMEOUT", DefaultRequestTimeout),
+			UserAgentSuffix: resolver.get("DD_USER_AGENT_SUFFIX"),
+			CassetteMode:    CassetteMode(_strings.ToLower(resolver.get("DD_CASSETTE_MODE"))),
+			CassetteFile:    resolver.get("DD_CASSETTE_FILE"),
+		},
+		SkipPreflight:   skipPreflight,
+		OrgsFile:        orgsFile,
+		SnapshotFile:    snapshotFile,
+		MaxParallelOrgs: maxParallelOrgs,
+		Compare:         compare,
+		Profile:         profileName,
+		Sources:         resolver.resolved,
 	}
 
-	// Validate required environment variables
-	if config.DDAPIKey == "" {
-		return nil, _fmt.Errorf("DD_API_KEY environment variable is required")
-	}
-
-	if config.DDAppKey == "" {
-		return nil, _fmt.Errorf("DD_APP_KEY environment variable is required")
-	}
-
-	// Set default DD_SITE if not provided
-	if config.DDSite == "" {
-		config.DDSite = "datadoghq.com"
-		_fmt.Printf("DD_SITE not set, using default: %s\n", config.DDSite)
-	}
+	config.Tagging.Safety.Site = config.DDSite
+	config.Tagging.Safety.Org = config.Output.Org
 
-	return config, nil
+	return config, append(parser.errs, config.Validate()...), nil
 }
 
 // SetDatadogEnvironment sets Datadog environment variables for the API client
+//
+// Deprecated: it mutates the process environment, so only one org can be used per process.
+// Use NewSecurityMonitoringClient or Config.NewDatadogContext instead.
 func (c *Config) SetDatadogEnvironment() {
 	_os.Setenv("DD_SITE", c.DDSite)
 	_os.Setenv("DD_API_KEY", c.DDAPIKey)
@@ -0,0 +1,387 @@
+package extV2
+
+import (
+	_context "context"
+	_fmt "fmt"
+	_io "io"
+	_neturl "net/url"
+	_os "os"
+	_sort "sort"
+	_strconv "strconv"
+	_strings "strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFilename is read from the working directory when no config file is given
+const DefaultConfigFilename = "dd-security-rule.yaml"
+
+// ConfigSource names the layer a configuration value was resolved from
+type ConfigSource string
+
+// Sources in increasing order of precedence
+const (
+	SourceDefault ConfigSource = "default"
+	SourceProfile ConfigSource = "profile"
+	SourceEnvFile ConfigSource = "env-file"
+	SourceEnv     ConfigSource = "env"
+	SourceFlag    ConfigSource = "flag"
+)
+
+// ConfigFile is the YAML configuration file holding named profiles
+type ConfigFile struct {
+	DefaultProfile string                   `yaml:"default_profile"`
+	Profiles       map[string]ProfileConfig `yaml:"profiles"`
+}
+
+// ProfileConfig holds the settings of one named profile (for example prod-us1 or staging-eu)
+type ProfileConfig struct {
+	Site             string `yaml:"site"`
+	Org              string `yaml:"org"`
+	APIKeyEnv        string `yaml:"api_key_env"`       // Name of the environment variable holding the API key
+	AppKeyEnv        string `yaml:"app_key_env"`       // Name of the environment variable holding the application key
+	APIKeyFile       string `yaml:"api_key_file"`      // File holding the API key, such as a mounted secret
+	AppKeyFile       string `yaml:"app_key_file"`      // File holding the application key
+	CredentialHelper string `yaml:"credential_helper"` // Program printing a key when called as "<helper> get DD_API_KEY"
+	Input            string `yaml:"input"`
+
+	Pagination struct {
+		PageSize     *int64   `yaml:"page_size"`
+		MaxPages     *int64   `yaml:"max_pages"`
+		TagFilters   []string `yaml:"tag_filters"`
+		CacheFile    string   `yaml:"cache_file"`
+		CacheTTL     string   `yaml:"cache_ttl"`
+		CacheRefresh *bool    `yaml:"cache_refresh"`
+	} `yaml:"pagination"`
+
+	Tagging struct {
+		DryRun            *bool    `yaml:"dry_run"`
+		OverwriteTags     *bool    `yaml:"overwrite_tags"`
+		IncludedTags      []string `yaml:"included_tags"`
+		MaxConcurrency    *int     `yaml:"max_concurrency"`
+		MaxChanges        *int     `yaml:"max_changes"`
+		MaxChangesPercent *float64 `yaml:"max_changes_percent"`
+	} `yaml:"tagging"`
+
+	Output struct {
+		Formats          []string `yaml:"formats"`
+		Dir              string   `yaml:"dir"`
+		FilenameTemplate string   `yaml:"filename_template"`
+		Timezone         string   `yaml:"timezone"`
+	} `yaml:"output"`
+
+	Logging struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"logging"`
+
+	HTTP struct {
+		BaseURL         string `yaml:"base_url"`
+		ProxyURL        string `yaml:"proxy_url"`
+		CABundle        string `yaml:"ca_bundle"`
+		ClientCert      string `yaml:"client_cert"`
+		ClientKey       string `yaml:"client_key"`
+		Timeout         string `yaml:"timeout"`
+		UserAgentSuffix string `yaml:"user_agent_suffix"`
+		CassetteMode    string `yaml:"cassette_mode"`
+		CassetteFile    string `yaml:"cassette_file"`
+	} `yaml:"http"`
+
+	// Settings sets any other configuration key by its environment variable name
+	Settings map[string]string `yaml:"settings"`
+}
+
+// LoadOptions controls where LoadConfigWithOptions reads configuration from
+type LoadOptions struct {
+	ConfigFile string            // YAML config file; CONFIG_FILE or dd-security-rule.yaml when empty
+	EnvFiles   []string          // .env files that must exist; ENV_FILE or the optional .env.<profile> and .env when empty
+	Profile    string            // Profile name; PROFILE or the file's default_profile when empty
+	Flags      map[string]string // Command-line overrides keyed by environment variable name
+
+	// CredentialSources are asked in order for DD_API_KEY and DD_APP_KEY when they are not set directly;
+	// nil uses DD_API_KEY_FILE/DD_APP_KEY_FILE and then DD_CREDENTIAL_HELPER
+	CredentialSources []CredentialSource
+}
+
+// LoadConfigFile reads and parses a YAML config file
+func LoadConfigFile(filename string) (*ConfigFile, error) {
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read config file %s: %w", filename, err)
+	}
+
+	configFile := &ConfigFile{}
+	if err := yaml.Unmarshal(data, configFile); err != nil {
+		return nil, _fmt.Errorf("failed to parse config file %s: %w", filename, err)
+	}
+	return configFile, nil
+}
+
+// Profile returns the named profile, or the default profile when name is empty
+func (f *ConfigFile) Profile(name string) (string, *ProfileConfig, error) {
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(f.Profiles))
+		for profileName := range f.Profiles {
+			names = append(names, profileName)
+		}
+		_sort.Strings(names)
+		return "", nil, _fmt.Errorf("profile %q not found (available: %s)", name, _strings.Join(names, ", "))
+	}
+	return name, &profile, nil
+}
+
+// settings flattens the profile into configuration keys named like their environment variables
+func (p *ProfileConfig) settings() map[string]string {
+	values := make(map[string]string)
+	set := func(key string, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	set("DD_SITE", p.Site)
+	set("DD_ORG", p.Org)
+	if p.APIKeyEnv != "" {
+		set("DD_API_KEY", _os.Getenv(p.APIKeyEnv))
+	}
+	if p.AppKeyEnv != "" {
+		set("DD_APP_KEY", _os.Getenv(p.AppKeyEnv))
+	}
+	set("DD_API_KEY_FILE", p.APIKeyFile)
+	set("DD_APP_KEY_FILE", p.AppKeyFile)
+	set("DD_CREDENTIAL_HELPER", p.CredentialHelper)
+	set("INPUT", p.Input)
+
+	if p.Pagination.PageSize != nil {
+		set("PAGE_SIZE", _strconv.FormatInt(*p.Pagination.PageSize, 10))
+	}
+	if p.Pagination.MaxPages != nil {
+		set("MAX_PAGES", _strconv.FormatInt(*p.Pagination.MaxPages, 10))
+	}
+	set("TAG_FILTERS", _strings.Join(p.Pagination.TagFilters, ","))
+	set("RULE_CACHE_FILE", p.Pagination.CacheFile)
+	set("RULE_CACHE_TTL", p.Pagination.CacheTTL)
+	if p.Pagination.CacheRefresh != nil {
+		set("RULE_CACHE_REFRESH", _strconv.FormatBool(*p.Pagination.CacheRefresh))
+	}
+
+	if p.Tagging.DryRun != nil {
+		set("DRYRUN", _strconv.FormatBool(*p.Tagging.DryRun))
+	}
+	if p.Tagging.OverwriteTags != nil {
+		set("OVERWRITE_TAGS", _strconv.FormatBool(*p.Tagging.OverwriteTags))
+	}
+	set("INCLUDED_TAGS", _strings.Join(p.Tagging.IncludedTags, ","))
+	if p.Tagging.MaxConcurrency != nil {
+		set("MAX_CONCURRENCY", _strconv.Itoa(*p.Tagging.MaxConcurrency))
+	}
+
+	if p.Tagging.MaxChanges != nil {
+		set("MAX_CHANGES", _strconv.Itoa(*p.Tagging.MaxChanges))
+	}
+	if p.Tagging.MaxChangesPercent != nil {
+		set("MAX_CHANGES_PERCENT", _strconv.FormatFloat(*p.Tagging.MaxChangesPercent, 'f', -1, 64))
+	}
+
+	set("OUTPUT_FORMATS", _strings.Join(p.Output.Formats, ","))
+	set("OUTPUT_DIR", p.Output.Dir)
+	set("OUTPUT_FILENAME_TEMPLATE", p.Output.FilenameTemplate)
+	set("OUTPUT_TIMEZONE", p.Output.Timezone)
+
+	set("LOG_LEVEL", p.Logging.Level)
+	set("LOG_FORMAT", p.Logging.Format)
+
+	set("DD_BASE_URL", p.HTTP.BaseURL)
+	set("DD_PROXY_URL", p.HTTP.ProxyURL)
+	set("DD_CA_BUNDLE", p.HTTP.CABundle)
+	set("DD_CLIENT_CERT", p.HTTP.ClientCert)
+	set("DD_CLIENT_KEY", p.HTTP.ClientKey)
+	set("DD_REQUEST_TIMEOUT", p.HTTP.Timeout)
+	set("DD_USER_AGENT_SUFFIX", p.HTTP.UserAgentSuffix)
+	set("DD_CASSETTE_MODE", p.HTTP.CassetteMode)
+	set("DD_CASSETTE_FILE", p.HTTP.CassetteFile)
+
+	for key, value := range p.Settings {
+		set(key, value)
+	}
+	return values
+}
+
+// ResolvedValue is a raw configuration value together with the layer it came from
+type ResolvedValue struct {
+	Value  string
+	Source ConfigSource
+}
+
+// configResolver looks up configuration keys across flags, environment, profile and defaults
+type configResolver struct {
+	flags          map[string]string
+	profile        map[string]string
+	envFileOrigins map[string]string // Keys set from .env files, mapped to the file they came from
+	resolved       map[string]ResolvedValue
+}
+
+// newConfigResolver creates a resolver for the given flag overrides and profile settings
+func newConfigResolver(flags map[string]string, profile map[string]string) *configResolver {
+	return &configResolver{
+		flags:    flags,
+		profile:  profile,
+		resolved: make(map[string]ResolvedValue),
+	}
+}
+
+// get returns the highest-precedence non-empty value for key, or "" when the default applies
+func (r *configResolver) get(key string) string {
+	value := ResolvedValue{Source: SourceDefault}
+	if flag := r.flags[key]; flag != "" {
+		value = ResolvedValue{Value: flag, Source: SourceFlag}
+	} else if env := _os.Getenv(key); env != "" {
+		value = ResolvedValue{Value: env, Source: SourceEnv}
+		if _, ok := r.envFileOrigins[key]; ok {
+			value.Source = SourceEnvFile
+		}
+	} else if setting := r.profile[key]; setting != "" {
+		value = ResolvedValue{Value: setting, Source: SourceProfile}
+	}
+	r.resolved[key] = value
+	return value.Value
+}
+
+// secretConfigKeys are never printed in clear text
+var secretConfigKeys = map[string]bool{
+	"DD_API_KEY": true,
+	"DD_APP_KEY": true,
+}
+
+// urlConfigKeys hold URLs that may carry credentials as user:password@
+var urlConfigKeys = map[string]bool{
+	"DD_BASE_URL":  true,
+	"DD_PROXY_URL": true,
+}
+
+// RedactValue masks the value of secret configuration keys and strips credentials from URLs
+func RedactValue(key string, value string) string {
+	if secretConfigKeys[key] && value != "" {
+		return redactedValue
+	}
+	if urlConfigKeys[key] {
+		return redactURL(value)
+	}
+	return value
+}
+
+// redactURL removes the user:password@ part of a URL; a value that does not parse but may hold one is masked
+func redactURL(value string) string {
+	parsed, err := _neturl.Parse(value)
+	if err != nil {
+		if _strings.Contains(value, "@") {
+			return redactedValue
+		}
+		return value
+	}
+	if parsed.User == nil {
+		return value
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// effectiveConfigValues renders the final value of keys that map directly onto Config fields
+func effectiveConfigValues(c *Config) map[string]string {
+	formats := make([]string, len(c.Output.Formats))
+	for i, format := range c.Output.Formats {
+		formats[i] = string(format)
+	}
+	timezone := "local"
+	if c.Output.UTC {
+		timezone = "utc"
+	}
+
+	return map[string]string{
+		"DD_SITE":                  c.DDSite,
+		"DD_API_KEY":               c.DDAPIKey,
+		"DD_APP_KEY":               c.DDAppKey,
+		"DD_ORG":                   c.Output.Org,
+		"INPUT":                    c.InputRuleFilename,
+		"PAGE_SIZE":                _strconv.FormatInt(c.Pagination.PageSize, 10),
+		"MAX_PAGES":                _strconv.FormatInt(c.Pagination.MaxPages, 10),
+		"TAG_FILTERS":              _strings.Join(c.Pagination.TagFilters, ","),
+		"DRYRUN":                   _strconv.FormatBool(c.Tagging.DryRun),
+		"OVERWRITE_TAGS":           _strconv.FormatBool(c.Tagging.OverwriteTags),
+		"INCLUDED_TAGS":            _strings.Join(c.Tagging.IncludedTags, ","),
+		"MAX_CONCURRENCY":          _strconv.Itoa(c.Tagging.MaxConcurrency),
+		"CONFIRM_WRITES":           _strconv.FormatBool(c.Tagging.Safety.ConfirmWrites),
+		"MAX_CHANGES":              _strconv.Itoa(c.Tagging.Safety.MaxChanges),
+		"MAX_CHANGES_PERCENT":      _strconv.FormatFloat(c.Tagging.Safety.MaxChangesPercent, 'f', -1, 64),
+		"OUTPUT_FORMATS":           _strings.Join(formats, ","),
+		"OUTPUT_DIR":               c.Output.Dir,
+		"OUTPUT_FILENAME_TEMPLATE": c.Output.FilenameTemplate,
+		"OUTPUT_TIMEZONE":          timezone,
+		"OUTPUT_FILE_MODE":         _fmt.Sprintf("%04o", uint32(c.Output.FileMode)),
+		"OUTPUT_DIR_MODE":          _fmt.Sprintf("%04o", uint32(c.Output.DirMode)),
+		"NO_OUTPUT_FILES":          _strconv.FormatBool(c.Output.NoFiles),
+		"RUN_ID":                   c.Output.RunID,
+		"LOG_LEVEL":                c.Logging.Level.String(),
+		"LOG_FORMAT":               c.Logging.Format,
+		"DD_REQUEST_TIMEOUT":       c.HTTP.Timeout.String(),
+		"SKIP_PREFLIGHT":           _strconv.FormatBool(c.SkipPreflight),
+		"MAX_PARALLEL_ORGS":        _strconv.Itoa(c.MaxParallelOrgs),
+	}
+}
+
+// FormatResolvedConfig lists every configuration key with its effective value and source, secrets redacted
+func FormatResolvedConfig(c *Config) string {
+	effective := effectiveConfigValues(c)
+
+	keys := make([]string, 0, len(c.Sources))
+	for key := range c.Sources {
+		keys = append(keys, key)
+	}
+	_sort.Strings(keys)
+
+	width := 0
+	for _, key := range keys {
+		width = max(width, len(key))
+	}
+
+	var sb _strings.Builder
+	profile := c.Profile
+	if profile == "" {
+		profile = "(none)"
+	}
+	_fmt.Fprintf(&sb, "Profile: %s\n", profile)
+	for _, key := range keys {
+		resolved := c.Sources[key]
+		value, ok := effective[key]
+		if !ok {
+			value = resolved.Value
+		}
+		_fmt.Fprintf(&sb, "%-*s = %-40s [%s]\n", width, key, RedactValue(key, value), resolved.Source)
+	}
+	return sb.String()
+}
+
+// PrintResolvedConfig loads configuration with options and writes FormatResolvedConfig to w.
+// It is the entry point of a "config" command, so the values are printed even when some are invalid;
+// the collected configuration errors are returned afterwards, as from LoadConfigWithOptions.
+func PrintResolvedConfig(ctx _context.Context, w _io.Writer, options LoadOptions) error {
+	config, errs, err := resolveConfig(ctx, options)
+	if err != nil {
+		return err
+	}
+	if _, err := _fmt.Fprint(w, FormatResolvedConfig(config)); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
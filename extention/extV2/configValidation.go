@@ -0,0 +1,309 @@
+package extV2
+
+import (
+	_fmt "fmt"
+	_neturl "net/url"
+	_os "os"
+	_strconv "strconv"
+	_strings "strings"
+	_time "time"
+)
+
+const (
+	MinPageSize       = 1
+	MaxPageSize       = 100 // Largest page[size] accepted by the security monitoring rules API
+	MinMaxConcurrency = 1
+	MaxMaxConcurrency = 50
+)
+
+// DefaultDDSite is the Datadog site used when DD_SITE is not set
+const DefaultDDSite = "datadoghq.com"
+
+// KnownDDSites lists the Datadog sites accepted for DD_SITE
+var KnownDDSites = []string{
+	"datadoghq.com",
+	"us3.datadoghq.com",
+	"us5.datadoghq.com",
+	"ap1.datadoghq.com",
+	"ap2.datadoghq.com",
+	"datadoghq.eu",
+	"ddog-gov.com",
+}
+
+// ConfigError describes one invalid configuration value
+type ConfigError struct {
+	Key     string       // Configuration key, named like its environment variable
+	Value   string       // Offending raw value (redacted for secrets)
+	Source  ConfigSource // Layer the value came from
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Value == "" {
+		return _fmt.Sprintf("%s: %s", e.Key, e.Message)
+	}
+	return _fmt.Sprintf("%s=%q (from %s): %s", e.Key, e.Value, e.Source, e.Message)
+}
+
+// ConfigErrors collects every configuration problem found while loading
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  - " + err.Error()
+	}
+	return _fmt.Sprintf("invalid configuration (%d problems):\n%s", len(e), _strings.Join(lines, "\n"))
+}
+
+// configParser parses resolved values and collects errors instead of falling back silently
+type configParser struct {
+	resolver *configResolver
+	errs     ConfigErrors
+}
+
+// fail records an error for key using its resolved value and source
+func (p *configParser) fail(key string, message string) {
+	resolved := p.resolver.resolved[key]
+	p.errs = append(p.errs, ConfigError{
+		Key:     key,
+		Value:   RedactValue(key, resolved.Value),
+		Source:  resolved.Source,
+		Message: message,
+	})
+}
+
+// string returns the value of key or def when unset
+func (p *configParser) string(key string, def string) string {
+	if value := p.resolver.get(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// int64 parses key as a base-10 integer
+func (p *configParser) int64(key string, def int64) int64 {
+	value := p.resolver.get(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := _strconv.ParseInt(_strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		p.fail(key, "must be an integer")
+		return def
+	}
+	return parsed
+}
+
+// int parses key as a base-10 integer
+func (p *configParser) int(key string, def int) int {
+	return int(p.int64(key, int64(def)))
+}
+
+// float64 parses key as a decimal number
+func (p *configParser) float64(key string, def float64) float64 {
+	value := p.resolver.get(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := _strconv.ParseFloat(_strings.TrimSpace(value), 64)
+	if err != nil {
+		p.fail(key, "must be a number")
+		return def
+	}
+	return parsed
+}
+
+// bool parses key with strconv.ParseBool
+func (p *configParser) bool(key string, def bool) bool {
+	value := p.resolver.get(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := _strconv.ParseBool(_strings.TrimSpace(value))
+	if err != nil {
+		p.fail(key, "must be a boolean (true/false, 1/0)")
+		return def
+	}
+	return parsed
+}
+
+// duration parses key as a Go duration such as 30s or 2m
+func (p *configParser) duration(key string, def _time.Duration) _time.Duration {
+	value := p.resolver.get(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := _time.ParseDuration(_strings.TrimSpace(value))
+	if err != nil {
+		p.fail(key, "must be a duration such as 30s or 2m")
+		return def
+	}
+	return parsed
+}
+
+// list splits key on commas, dropping empty items
+func (p *configParser) list(key string) []string {
+	var items []string
+	for _, item := range _strings.Split(p.resolver.get(key), ",") {
+		if trimmed := _strings.TrimSpace(item); trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+	return items
+}
+
+// fileMode parses key as an octal permission value
+func (p *configParser) fileMode(key string, def _os.FileMode) _os.FileMode {
+	value := p.resolver.get(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := _strconv.ParseUint(_strings.TrimSpace(value), 8, 32)
+	if err != nil || parsed > 0777 {
+		p.fail(key, "must be an octal permission such as 0644")
+		return def
+	}
+	return _os.FileMode(parsed)
+}
+
+// Validate checks value ranges and referenced files, returning every problem found
+func (c *Config) Validate() ConfigErrors {
+	return c.validate(c.readsInput())
+}
+
+// readsInput reports whether the selected mode matches rules against INPUT; comparing two orgs does not
+func (c *Config) readsInput() bool {
+	return c.Compare.SourceOrg == ""
+}
+
+// validate is Validate with the INPUT check only when checkInput is set
+func (c *Config) validate(checkInput bool) ConfigErrors {
+	var errs ConfigErrors
+	add := func(key string, message string) {
+		resolved := c.Sources[key]
+		errs = append(errs, ConfigError{
+			Key:     key,
+			Value:   RedactValue(key, resolved.Value),
+			Source:  resolved.Source,
+			Message: message,
+		})
+	}
+
+	// A multi-org run takes the keys of each org from the orgs file; a replay or an offline run never sends them
+	if c.OrgsFile != "" {
+		if _, err := _os.Stat(c.OrgsFile); err != nil {
+			add("ORGS_FILE", _fmt.Sprintf("orgs file %s is not readable: %v", c.OrgsFile, err))
+		}
+		if c.SnapshotFile != "" {
+			add("SNAPSHOT_FILE", "cannot be combined with ORGS_FILE")
+		}
+	} else if c.SnapshotFile != "" {
+		if _, err := _os.Stat(c.SnapshotFile); err != nil {
+			add("SNAPSHOT_FILE", _fmt.Sprintf("listing snapshot %s is not readable: %v", c.SnapshotFile, err))
+		}
+		if !c.Tagging.DryRun {
+			add("DRYRUN", "must be true with SNAPSHOT_FILE: an offline run only plans changes")
+		}
+	} else if c.HTTP.CassetteMode != CassetteReplay {
+		if c.DDAPIKey == "" {
+			add("DD_API_KEY", "is required (flag, environment variable, DD_API_KEY_FILE, DD_CREDENTIAL_HELPER or profile api_key_env)")
+		}
+		if c.DDAppKey == "" {
+			add("DD_APP_KEY", "is required (flag, environment variable, DD_APP_KEY_FILE, DD_CREDENTIAL_HELPER or profile app_key_env)")
+		}
+	}
+	if (c.Compare.SourceOrg == "") != (c.Compare.TargetOrg == "") {
+		add("COMPARE_SOURCE_ORG", "COMPARE_SOURCE_ORG and COMPARE_TARGET_ORG must be set together")
+	} else if c.Compare.SourceOrg != "" {
+		if c.OrgsFile == "" {
+			add("COMPARE_SOURCE_ORG", "comparing orgs requires ORGS_FILE")
+		}
+		if c.Compare.SourceOrg == c.Compare.TargetOrg {
+			add("COMPARE_TARGET_ORG", "must differ from COMPARE_SOURCE_ORG")
+		}
+	}
+	if c.Compare.SyncInputFile != "" && c.Compare.SourceOrg == "" {
+		add("SYNC_INPUT_FILE", "requires COMPARE_SOURCE_ORG and COMPARE_TARGET_ORG")
+	}
+	if c.MaxParallelOrgs < 1 {
+		add("MAX_PARALLEL_ORGS", "must be at least 1")
+	}
+
+	knownSite := false
+	for _, site := range KnownDDSites {
+		if c.DDSite == site {
+			knownSite = true
+			break
+		}
+	}
+	if !knownSite {
+		add("DD_SITE", _fmt.Sprintf("unknown Datadog site (expected one of %s)", _strings.Join(KnownDDSites, ", ")))
+	}
+
+	if c.Pagination.PageSize < MinPageSize || c.Pagination.PageSize > MaxPageSize {
+		add("PAGE_SIZE", _fmt.Sprintf("must be between %d and %d", MinPageSize, MaxPageSize))
+	}
+	if c.Pagination.MaxPages < 0 {
+		add("MAX_PAGES", "must be 0 (no limit) or positive")
+	}
+	if c.Pagination.Cache.TTL < 0 {
+		add("RULE_CACHE_TTL", "must be 0 (always refresh) or positive")
+	} else if c.Pagination.Cache.TTL > 0 && c.Pagination.Cache.File == "" {
+		add("RULE_CACHE_TTL", "requires RULE_CACHE_FILE")
+	}
+	if c.Tagging.MaxConcurrency < MinMaxConcurrency || c.Tagging.MaxConcurrency > MaxMaxConcurrency {
+		add("MAX_CONCURRENCY", _fmt.Sprintf("must be between %d and %d", MinMaxConcurrency, MaxMaxConcurrency))
+	}
+
+	if c.Tagging.Safety.MaxChanges < 0 {
+		add("MAX_CHANGES", "must be 0 (no limit) or positive")
+	}
+	if c.Tagging.Safety.MaxChangesPercent < 0 || c.Tagging.Safety.MaxChangesPercent > 100 {
+		add("MAX_CHANGES_PERCENT", "must be between 0 (no limit) and 100")
+	}
+
+	for key, value := range map[string]string{"DD_BASE_URL": c.HTTP.BaseURL, "DD_PROXY_URL": c.HTTP.ProxyURL} {
+		if value == "" {
+			continue
+		}
+		if parsed, err := _neturl.Parse(value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			add(key, "must be an absolute http:// or https:// URL")
+		}
+	}
+	if c.HTTP.Timeout < 0 {
+		add("DD_REQUEST_TIMEOUT", "must be 0 (no timeout) or positive")
+	}
+	if _, err := (HTTPConfig{CABundle: c.HTTP.CABundle}).tlsConfig(); err != nil {
+		add("DD_CA_BUNDLE", err.Error())
+	}
+	if (c.HTTP.ClientCert == "") != (c.HTTP.ClientKey == "") {
+		add("DD_CLIENT_CERT", "DD_CLIENT_CERT and DD_CLIENT_KEY must be set together")
+	} else if _, err := (HTTPConfig{ClientCert: c.HTTP.ClientCert, ClientKey: c.HTTP.ClientKey}).tlsConfig(); err != nil {
+		add("DD_CLIENT_CERT", err.Error())
+	}
+
+	switch c.HTTP.CassetteMode {
+	case CassetteOff:
+	case CassetteRecord, CassetteReplay:
+		if c.HTTP.CassetteFile == "" {
+			add("DD_CASSETTE_FILE", _fmt.Sprintf("is required with DD_CASSETTE_MODE=%s", c.HTTP.CassetteMode))
+		} else if c.HTTP.CassetteMode == CassetteReplay {
+			if _, err := _os.Stat(c.HTTP.CassetteFile); err != nil {
+				add("DD_CASSETTE_FILE", _fmt.Sprintf("cassette %s is not readable: %v", c.HTTP.CassetteFile, err))
+			}
+		}
+	default:
+		add("DD_CASSETTE_MODE", "must be record or replay")
+	}
+
+	if checkInput {
+		if info, err := _os.Stat(c.InputRuleFilename); err != nil {
+			add("INPUT", _fmt.Sprintf("input file %s is not readable: %v", c.InputRuleFilename, err))
+		} else if info.IsDir() {
+			add("INPUT", _fmt.Sprintf("input file %s is a directory", c.InputRuleFilename))
+		}
+	}
+
+	return errs
+}
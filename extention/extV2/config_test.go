@@ -0,0 +1,151 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_errors "errors"
+	_logslog "log/slog"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_strconv "strconv"
+	_strings "strings"
+	_testing "testing"
+)
+
+// loadTestConfig loads a config from flags alone, in an empty working directory with an input.json
+func loadTestConfig(t *_testing.T, flags map[string]string) (*Config, error) {
+	t.Helper()
+	return loadTestConfigFiles(t, nil, LoadOptions{Flags: flags})
+}
+
+// loadTestConfigFiles loads a config in a working directory holding files and an input.json.
+// The keys are passed as flags unless options sets them already.
+func loadTestConfigFiles(t *_testing.T, files map[string]string, options LoadOptions) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	chdir(t, dir)
+	for _, key := range []string{"PROFILE", "ENV_FILE", "CONFIG_FILE", "DD_SITE", "DD_API_KEY", "DD_APP_KEY", "LOG_FORMAT", "EVENTS_FD"} {
+		t.Setenv(key, "")
+	}
+	writeFile(t, _pathfilepath.Join(dir, "input.json"), "[]")
+	for name, data := range files {
+		writeFile(t, _pathfilepath.Join(dir, name), data)
+	}
+
+	flags := map[string]string{"DD_API_KEY": "test-api-key", "DD_APP_KEY": "test-app-key"}
+	for key, value := range options.Flags {
+		flags[key] = value
+	}
+	options.Flags = flags
+	return LoadConfigWithOptions(_context.Background(), options)
+}
+
+func TestLoadConfigLogging(t *_testing.T) {
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		wantFormat string
+		wantErrKey string
+	}{
+		{name: "default format", wantFormat: LogFormatHuman},
+		{name: "json", flags: map[string]string{"LOG_FORMAT": "json"}, wantFormat: LogFormatJSON},
+		{name: "case-insensitive", flags: map[string]string{"LOG_FORMAT": "Text"}, wantFormat: LogFormatText},
+		{name: "unknown format", flags: map[string]string{"LOG_FORMAT": "xml"}, wantErrKey: "LOG_FORMAT"},
+		{name: "unknown level", flags: map[string]string{"LOG_LEVEL": "loud"}, wantErrKey: "LOG_LEVEL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			config, err := loadTestConfig(t, tt.flags)
+			if tt.wantErrKey != "" {
+				var configErrs ConfigErrors
+				if !_errors.As(err, &configErrs) || configErrs[0].Key != tt.wantErrKey {
+					t.Fatalf("LoadConfigWithOptions() error = %v, want an error for %s", err, tt.wantErrKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigWithOptions() error = %v", err)
+			}
+			if config.Logging.Format != tt.wantFormat {
+				t.Errorf("Logging.Format = %q, want %q", config.Logging.Format, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLogsToContext(t *_testing.T) {
+	var global, contextual _bytes.Buffer
+	previous := Logger()
+	globalLogger, _ := NewLogger(LogConfig{Format: LogFormatText, Level: _logslog.LevelDebug}, &global)
+	SetLogger(globalLogger)
+	t.Cleanup(func() { SetLogger(previous) })
+	ctxLogger, _ := NewLogger(LogConfig{Format: LogFormatText, Level: _logslog.LevelDebug}, &contextual)
+
+	dir := t.TempDir()
+	chdir(t, dir)
+	for _, key := range []string{"PROFILE", "ENV_FILE", "CONFIG_FILE", "DD_SITE", "DD_API_KEY", "DD_APP_KEY", "LOG_FORMAT", "EVENTS_FD"} {
+		t.Setenv(key, "")
+	}
+	writeFile(t, _pathfilepath.Join(dir, "input.json"), "[]")
+	writeFile(t, _pathfilepath.Join(dir, ".env"), "DD_API_KEY=test-api-key\nDD_APP_KEY=test-app-key\nnot an assignment\n")
+
+	config, err := LoadConfigWithOptions(ContextWithLogger(_context.Background(), ctxLogger), LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadConfigWithOptions() error = %v", err)
+	}
+	if global.Len() != 0 {
+		t.Errorf("LoadConfigWithOptions() logged to the global logger:\n%s", global.String())
+	}
+	for _, want := range []string{"Loaded env file", "Skipping env line", "line=3"} {
+		if !_strings.Contains(contextual.String(), want) {
+			t.Errorf("context logger output does not contain %q:\n%s", want, contextual.String())
+		}
+	}
+	// The default site is reported through the resolved sources instead
+	if config.DDSite != DefaultDDSite || config.Sources["DD_SITE"].Source != SourceDefault {
+		t.Errorf("DD_SITE = %q from %q, want the default", config.DDSite, config.Sources["DD_SITE"].Source)
+	}
+}
+
+func TestLoadConfigEventsFD(t *_testing.T) {
+	reader, writer, err := _os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	fd := _strconv.FormatUint(uint64(writer.Fd()), 10)
+
+	// Only the failing cases use the pipe: a handler opened on it could close its descriptor when collected
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		wantErrKey string
+	}{
+		{name: "unset"},
+		{name: "not a number", flags: map[string]string{"EVENTS_FD": "stdout"}, wantErrKey: "EVENTS_FD"},
+		{name: "closed descriptor", flags: map[string]string{"EVENTS_FD": "987654"}, wantErrKey: "EVENTS_FD"},
+		// The descriptor is not opened when another setting is invalid
+		{name: "other setting invalid", flags: map[string]string{"EVENTS_FD": fd, "LOG_FORMAT": "xml"}, wantErrKey: "LOG_FORMAT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			config, err := loadTestConfig(t, tt.flags)
+			if tt.wantErrKey != "" {
+				var configErrs ConfigErrors
+				if !_errors.As(err, &configErrs) || len(configErrs) != 1 || configErrs[0].Key != tt.wantErrKey {
+					t.Fatalf("LoadConfigWithOptions() error = %v, want one error for %s", err, tt.wantErrKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigWithOptions() error = %v", err)
+			}
+			if config.Output.Events != nil {
+				t.Error("Output.Events is set without EVENTS_FD")
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_fmt "fmt"
+	_logslog "log/slog"
+	_os "os"
+	_osexec "os/exec"
+	_strings "strings"
+	_time "time"
+)
+
+// CredentialHelperTimeout bounds how long a credential helper may run
+const CredentialHelperTimeout = 10 * _time.Second
+
+// Credential sources recorded in Config.Sources
+const (
+	SourceSecretFile       ConfigSource = "secret-file"
+	SourceCredentialHelper ConfigSource = "credential-helper"
+)
+
+// CredentialSource resolves a secret such as DD_API_KEY when it is not set directly
+type CredentialSource interface {
+	// Source names the source for Config.Sources
+	Source() ConfigSource
+	// Resolve returns the secret for key, or "" when this source has none
+	Resolve(key string) (string, error)
+}
+
+// FileCredentialSource reads a secret from the file named by <key>_FILE, as used by Docker and Kubernetes secret mounts
+type FileCredentialSource struct {
+	Lookup func(key string) string // Resolves the <key>_FILE setting
+}
+
+// Source implements CredentialSource
+func (s FileCredentialSource) Source() ConfigSource {
+	return SourceSecretFile
+}
+
+// Resolve implements CredentialSource
+func (s FileCredentialSource) Resolve(key string) (string, error) {
+	filename := s.Lookup(key + "_FILE")
+	if filename == "" {
+		return "", nil
+	}
+
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return "", _fmt.Errorf("failed to read %s_FILE: %w", key, err)
+	}
+	secret := _strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", _fmt.Errorf("%s_FILE %s is empty", key, filename)
+	}
+	return secret, nil
+}
+
+// HelperCredentialSource runs an external program that prints the secret, similar to git credential helpers.
+// The program is called as "<command> get <key>" and must print only the secret on stdout.
+type HelperCredentialSource struct {
+	Command string   // Program and optional arguments, split on whitespace
+	Env     []string // Extra environment passed to the helper, such as DD_SITE and PROFILE
+}
+
+// Source implements CredentialSource
+func (s HelperCredentialSource) Source() ConfigSource {
+	return SourceCredentialHelper
+}
+
+// Resolve implements CredentialSource
+func (s HelperCredentialSource) Resolve(key string) (string, error) {
+	args := _strings.Fields(s.Command)
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	ctx, cancel := _context.WithTimeout(_context.Background(), CredentialHelperTimeout)
+	defer cancel()
+
+	cmd := _osexec.CommandContext(ctx, args[0], append(args[1:], "get", key)...)
+	cmd.Env = append(_os.Environ(), s.Env...)
+	var stdout, stderr _bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// stderr is safe to report, stdout may hold a partial secret
+		return "", _fmt.Errorf("credential helper %s failed for %s: %w: %s", args[0], key, err, _strings.TrimSpace(stderr.String()))
+	}
+	secret := _strings.TrimSpace(stdout.String())
+	if secret == "" {
+		return "", _fmt.Errorf("credential helper %s printed no value for %s", args[0], key)
+	}
+	return secret, nil
+}
+
+// resolveCredential returns the plain setting for key or asks each credential source in turn
+func resolveCredential(parser *configParser, key string, sources []CredentialSource) string {
+	if value := parser.resolver.get(key); value != "" {
+		return value
+	}
+
+	secret, source, err := lookupCredential(key, sources)
+	if err != nil {
+		parser.fail(key, err.Error())
+		return ""
+	}
+	if secret != "" {
+		parser.resolver.resolved[key] = ResolvedValue{Value: secret, Source: source}
+	}
+	return secret
+}
+
+// lookupCredential returns the first secret any source has for key, and the source it came from
+func lookupCredential(key string, sources []CredentialSource) (string, ConfigSource, error) {
+	for _, source := range sources {
+		secret, err := source.Resolve(key)
+		if err != nil {
+			return "", "", err
+		}
+		if secret != "" {
+			return secret, source.Source(), nil
+		}
+	}
+	return "", "", nil
+}
+
+// plainConfig has Config's fields without its methods, so it can be formatted without recursion
+type plainConfig Config
+
+// String formats the config with credentials redacted
+func (c Config) String() string {
+	return _fmt.Sprintf("%+v", plainConfig(c.Redacted()))
+}
+
+// LogValue keeps credentials out of structured logs
+func (c Config) LogValue() _logslog.Value {
+	redacted := c.Redacted()
+	return _logslog.GroupValue(
+		_logslog.String("site", redacted.DDSite),
+		_logslog.String("apiKey", redacted.DDAPIKey),
+		_logslog.String("appKey", redacted.DDAppKey),
+		_logslog.String("profile", redacted.Profile),
+		_logslog.String("input", redacted.InputRuleFilename),
+		_logslog.Bool("dryRun", redacted.Tagging.DryRun),
+	)
+}
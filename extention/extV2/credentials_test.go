@@ -0,0 +1,187 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_errors "errors"
+	_fmt "fmt"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_runtime "runtime"
+	_strings "strings"
+	_testing "testing"
+)
+
+// staticCredentialSource serves fixed secrets
+type staticCredentialSource map[string]string
+
+func (s staticCredentialSource) Source() ConfigSource { return "static" }
+
+func (s staticCredentialSource) Resolve(key string) (string, error) {
+	if s["error"] != "" {
+		return "", _errors.New(s["error"])
+	}
+	return s[key], nil
+}
+
+func TestFileCredentialSource(t *_testing.T) {
+	dir := t.TempDir()
+	writeFile(t, _pathfilepath.Join(dir, "api-key"), "  file-api-key\n")
+	writeFile(t, _pathfilepath.Join(dir, "empty"), "\n")
+
+	tests := []struct {
+		name    string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{name: "not set"},
+		{name: "trimmed", file: _pathfilepath.Join(dir, "api-key"), want: "file-api-key"},
+		{name: "empty file", file: _pathfilepath.Join(dir, "empty"), wantErr: true},
+		{name: "missing file", file: _pathfilepath.Join(dir, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			source := FileCredentialSource{Lookup: func(key string) string {
+				if key != "DD_API_KEY_FILE" {
+					t.Errorf("looked up %s, want DD_API_KEY_FILE", key)
+				}
+				return tt.file
+			}}
+			got, err := source.Resolve("DD_API_KEY")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Resolve() = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHelperCredentialSource(t *_testing.T) {
+	if _runtime.GOOS == "windows" {
+		t.Skip("the test helper is a shell script")
+	}
+	dir := t.TempDir()
+	helper := _pathfilepath.Join(dir, "helper")
+	// Prints "<PROFILE>-<key>", fails for DD_APP_KEY and prints nothing for other keys
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = get ] || exit 2\n" +
+		"case \"$2\" in\n" +
+		"DD_API_KEY) echo \"$PROFILE-$2\" ;;\n" +
+		"DD_APP_KEY) echo 'vault is sealed' >&2; exit 1 ;;\n" +
+		"esac\n"
+	if err := _os.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		key       string
+		want      string
+		wantInErr string
+	}{
+		{name: "not set", key: "DD_API_KEY"},
+		{name: "printed secret", command: helper, key: "DD_API_KEY", want: "prod-DD_API_KEY"},
+		{name: "failure reports stderr", command: helper, key: "DD_APP_KEY", wantInErr: "vault is sealed"},
+		{name: "no output", command: helper, key: "OTHER_KEY", wantInErr: "printed no value"},
+		{name: "missing program", command: _pathfilepath.Join(dir, "missing"), key: "DD_API_KEY", wantInErr: "credential helper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			source := HelperCredentialSource{Command: tt.command, Env: []string{"PROFILE=prod"}}
+			got, err := source.Resolve(tt.key)
+			if tt.wantInErr != "" {
+				if err == nil || !_strings.Contains(err.Error(), tt.wantInErr) {
+					t.Fatalf("Resolve() error = %v, want one containing %q", err, tt.wantInErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCredentialSources(t *_testing.T) {
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		sources    []CredentialSource
+		wantKey    string
+		wantSource ConfigSource
+		wantErrKey string
+	}{
+		{
+			name:       "set directly wins over the sources",
+			flags:      map[string]string{"DD_API_KEY": "flag-api-key"},
+			sources:    []CredentialSource{staticCredentialSource{"DD_API_KEY": "static-api-key"}},
+			wantKey:    "flag-api-key",
+			wantSource: SourceFlag,
+		},
+		{
+			name:       "first source with a value",
+			sources:    []CredentialSource{staticCredentialSource{}, staticCredentialSource{"DD_API_KEY": "static-api-key"}},
+			wantKey:    "static-api-key",
+			wantSource: "static",
+		},
+		{
+			name:       "failing source",
+			sources:    []CredentialSource{staticCredentialSource{"error": "helper unavailable"}},
+			wantErrKey: "DD_API_KEY",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			options := LoadOptions{Flags: map[string]string{"DD_API_KEY": ""}, CredentialSources: tt.sources}
+			for key, value := range tt.flags {
+				options.Flags[key] = value
+			}
+			config, err := loadTestConfigFiles(t, nil, options)
+			if tt.wantErrKey != "" {
+				keys := errorKeys(err)
+				if len(keys) == 0 || !_strings.Contains(err.Error(), "helper unavailable") {
+					t.Fatalf("LoadConfigWithOptions() error = %v, want the error of the source", err)
+				}
+				for _, key := range keys {
+					if key != tt.wantErrKey {
+						t.Errorf("error for %s, want only errors for %s", key, tt.wantErrKey)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigWithOptions() error = %v", err)
+			}
+			if config.DDAPIKey != tt.wantKey || config.Sources["DD_API_KEY"].Source != tt.wantSource {
+				t.Errorf("DD_API_KEY = %q from %q, want %q from %q", config.DDAPIKey, config.Sources["DD_API_KEY"].Source, tt.wantKey, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestConfigKeepsKeysOutOfOutput(t *_testing.T) {
+	config := Config{DDSite: "datadoghq.com", DDAPIKey: "s3cr3t-api-key", DDAppKey: "s3cr3t-app-key",
+		Sources: map[string]ResolvedValue{"DD_APP_KEY": {Value: "s3cr3t-app-key", Source: SourceSecretFile}}}
+
+	var buf _bytes.Buffer
+	logger, _ := NewLogger(LogConfig{Format: LogFormatJSON}, &buf)
+	logger.Info("loaded", "config", config)
+
+	outputs := map[string]string{
+		"String":   config.String(),
+		"%v":       _fmt.Sprintf("%v", config),
+		"log":      buf.String(),
+		"resolved": FormatResolvedConfig(&config),
+	}
+	for name, output := range outputs {
+		if _strings.Contains(output, "s3cr3t-") {
+			t.Errorf("%s output shows a key: %s", name, output)
+		}
+	}
+	if config.DDAPIKey != "s3cr3t-api-key" {
+		t.Error("formatting modified the config")
+	}
+}
@@ -1,11 +1,13 @@
 package extV2
 
 import (
+	_context "context"
 	_fmt "fmt"
+	_logslog "log/slog"
 	_nethttp "net/http"
-	_os "os"
 	_reflect "reflect"
 	_runtime "runtime"
+	_strings "strings"
 )
 
 // APICall represents an API call with metadata
@@ -14,19 +16,102 @@ type APICall struct {
 	APIName    string
 }
 
-// CallWithErrorHandling executes an API call and handles errors
-func (ac *APICall) CallWithErrorHandling(fn func() (interface{}, *_nethttp.Response, error)) (interface{}, *_nethttp.Response, error) {
-	resp, r, err := fn()
+// Invoker performs an API call; the response is type-erased so middlewares work for every method
+type Invoker func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error)
+
+// Middleware wraps an Invoker to add behaviour around every API call
+type Middleware func(next Invoker) Invoker
+
+// middlewaresKey is the context key of the middleware chain set by ContextWithMiddlewares
+type middlewaresKey struct{}
+
+// DefaultMiddlewares returns the chain used when a context carries none: LoggingMiddleware only
+func DefaultMiddlewares() []Middleware {
+	return []Middleware{LoggingMiddleware()}
+}
+
+// ContextWithMiddlewares returns a context whose API calls run through chain; the first middleware is the outermost.
+// Include LoggingMiddleware to keep the default error reporting.
+func ContextWithMiddlewares(ctx _context.Context, chain ...Middleware) _context.Context {
+	return _context.WithValue(ctx, middlewaresKey{}, append([]Middleware(nil), chain...))
+}
+
+// MiddlewaresFrom returns the middleware chain of ctx, or DefaultMiddlewares if none was set
+func MiddlewaresFrom(ctx _context.Context) []Middleware {
+	if chain, ok := ctx.Value(middlewaresKey{}).([]Middleware); ok {
+		return chain
+	}
+	return DefaultMiddlewares()
+}
+
+// CallAPI executes fn through the middleware chain of ctx and returns its concrete response type.
+// fn must use the context it is given so middlewares can add deadlines or cancel retries.
+func CallAPI[T any](ctx _context.Context, call *APICall, fn func(ctx _context.Context) (T, *_nethttp.Response, error)) (T, *_nethttp.Response, error) {
+	var invoke Invoker = func(ctx _context.Context, _ APICall) (any, *_nethttp.Response, error) {
+		return fn(ctx)
+	}
+	chain := MiddlewaresFrom(ctx)
+	for i := len(chain) - 1; i >= 0; i-- {
+		invoke = chain[i](invoke)
+	}
+
+	resp, r, err := invoke(ctx, *call)
 	if err != nil {
-		_fmt.Fprintf(_os.Stderr, "Error when calling `%s.%s`: %v\n", ac.APIName, ac.MethodName, err)
-		_fmt.Fprintf(_os.Stderr, "Full HTTP response: %v\n", r)
+		err = classifyAPIError(*call, r, err)
+	}
+	typed, ok := resp.(T)
+	if !ok && resp != nil {
+		var zero T
+		return zero, r, _fmt.Errorf("middleware returned %T from `%s.%s`, expected %T", resp, call.APIName, call.MethodName, zero)
+	}
+	return typed, r, err
+}
+
+// secretHeaders carry credentials and are never logged
+var secretHeaders = map[string]bool{
+	"Dd-Api-Key":         true,
+	"Dd-Application-Key": true,
+	"Authorization":      true,
+	"Cookie":             true,
+	"Set-Cookie":         true,
+}
+
+// RedactHeaders returns a copy of header with credential values masked
+func RedactHeaders(header _nethttp.Header) _nethttp.Header {
+	redacted := make(_nethttp.Header, len(header))
+	for key, values := range header {
+		if secretHeaders[_nethttp.CanonicalHeaderKey(key)] {
+			redacted[key] = []string{redactedValue}
+			continue
+		}
+		redacted[key] = append([]string(nil), values...)
+	}
+	return redacted
+}
+
+// responseLogValue summarises a response for logging; the raw value holds the request and its API key headers
+func responseLogValue(r *_nethttp.Response) _logslog.Value {
+	if r == nil {
+		return _logslog.StringValue("<nil>")
+	}
+	attrs := []_logslog.Attr{
+		_logslog.String("status", r.Status),
+		_logslog.Any("headers", RedactHeaders(r.Header)),
+	}
+	if r.Request != nil {
+		attrs = append(attrs,
+			_logslog.String("method", r.Request.Method),
+			_logslog.String("url", r.Request.URL.String()),
+			_logslog.Any("requestHeaders", RedactHeaders(r.Request.Header)),
+		)
 	}
-	return resp, r, err
+	return _logslog.GroupValue(attrs...)
 }
 
 // NewAPICall creates a new APICall with automatic method name detection
 func NewAPICall(apiName string, fn interface{}) *APICall {
-	methodName := _runtime.FuncForPC(_reflect.ValueOf(fn).Pointer()).Name()
+	// Method values such as api.ListSecurityMonitoringRules are named "pkg.(*T).Method-fm"
+	methodName := _strings.TrimSuffix(_runtime.FuncForPC(_reflect.ValueOf(fn).Pointer()).Name(), "-fm")
 	// Extract just the method name from the full path
 	if lastDot := len(methodName) - 1; lastDot >= 0 {
 		for i := lastDot; i >= 0; i-- {
@@ -0,0 +1,154 @@
+package extV2
+
+import (
+	_context "context"
+	_errors "errors"
+	_nethttp "net/http"
+	_reflect "reflect"
+	_strings "strings"
+	_testing "testing"
+)
+
+type fakeAPI struct{}
+
+func (fakeAPI) ListThings() {}
+
+func TestNewAPICall(t *_testing.T) {
+	call := NewAPICall("FakeApi", fakeAPI{}.ListThings)
+	if call.APIName != "FakeApi" || call.MethodName != "ListThings" {
+		t.Errorf("NewAPICall() = %+v, want FakeApi.ListThings", call)
+	}
+}
+
+func TestCallAPI(t *_testing.T) {
+	call := &APICall{APIName: "FakeApi", MethodName: "GetThing"}
+	failure := _errors.New("failed")
+
+	tests := []struct {
+		name     string
+		chain    []Middleware
+		fn       func(_context.Context) (string, *_nethttp.Response, error)
+		want     string
+		wantErr  error
+		wantText string // Substring of the error
+	}{
+		{
+			name: "typed response",
+			fn:   func(_context.Context) (string, *_nethttp.Response, error) { return "thing", nil, nil },
+			want: "thing",
+		},
+		{
+			name:    "no response is a network error",
+			fn:      func(_context.Context) (string, *_nethttp.Response, error) { return "", nil, failure },
+			wantErr: ErrNetwork,
+		},
+		{
+			name: "status is classified",
+			fn: func(_context.Context) (string, *_nethttp.Response, error) {
+				return "", &_nethttp.Response{StatusCode: _nethttp.StatusNotFound, Header: _nethttp.Header{}}, failure
+			},
+			wantErr:  ErrNotFound,
+			wantText: "FakeApi.GetThing: not-found error (HTTP 404)",
+		},
+		{
+			name: "middleware replaces the response",
+			chain: []Middleware{func(next Invoker) Invoker {
+				return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+					return 42, nil, nil
+				}
+			}},
+			fn:       func(_context.Context) (string, *_nethttp.Response, error) { return "thing", nil, nil },
+			wantText: "middleware returned int from `FakeApi.GetThing`, expected string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			ctx := ContextWithMiddlewares(_context.Background(), tt.chain...)
+			got, _, err := CallAPI(ctx, call, tt.fn)
+			if tt.wantErr == nil && tt.wantText == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("CallAPI() = %q, %v; want %q", got, err, tt.want)
+				}
+				return
+			}
+			if tt.wantErr != nil && !_errors.Is(err, tt.wantErr) {
+				t.Errorf("CallAPI() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil || !_strings.Contains(err.Error(), tt.wantText) {
+				t.Errorf("CallAPI() error = %v, want it to contain %q", err, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestContextWithMiddlewaresOrder(t *_testing.T) {
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(next Invoker) Invoker {
+			return func(ctx _context.Context, call APICall) (any, *_nethttp.Response, error) {
+				order = append(order, name+" before")
+				resp, r, err := next(ctx, call)
+				order = append(order, name+" after")
+				return resp, r, err
+			}
+		}
+	}
+
+	ctx := ContextWithMiddlewares(_context.Background(), middleware("outer"), middleware("inner"))
+	_, _, err := CallAPI(ctx, &APICall{APIName: "FakeApi", MethodName: "GetThing"}, func(_context.Context) (string, *_nethttp.Response, error) {
+		order = append(order, "call")
+		return "thing", nil, nil
+	})
+	if err != nil {
+		t.Fatalf("CallAPI() error = %v", err)
+	}
+	want := []string{"outer before", "inner before", "call", "inner after", "outer after"}
+	if !_reflect.DeepEqual(order, want) {
+		t.Errorf("call order = %v, want %v", order, want)
+	}
+
+	// A context without a chain uses the defaults
+	if chain := MiddlewaresFrom(_context.Background()); len(chain) != len(DefaultMiddlewares()) {
+		t.Errorf("MiddlewaresFrom() = %d middlewares, want the defaults", len(chain))
+	}
+}
+
+func TestRedactHeaders(t *_testing.T) {
+	header := _nethttp.Header{
+		"Dd-Api-Key":         {"api-secret"},
+		"Dd-Application-Key": {"app-secret"},
+		"Authorization":      {"Bearer token"},
+		"Content-Type":       {"application/json"},
+	}
+	header.Set("set-cookie", "session=secret")
+
+	redacted := RedactHeaders(header)
+	for _, key := range []string{"Dd-Api-Key", "Dd-Application-Key", "Authorization", "Set-Cookie"} {
+		if got := redacted.Get(key); got != redactedValue {
+			t.Errorf("%s = %q, want it redacted", key, got)
+		}
+	}
+	if got := redacted.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want it kept", got)
+	}
+	if header.Get("Dd-Api-Key") != "api-secret" {
+		t.Error("RedactHeaders() modified the original header")
+	}
+}
+
+func TestResponseLogValue(t *_testing.T) {
+	r := statusResponse(_nethttp.StatusForbidden, _nethttp.Header{"X-Datadog-Request-Id": {"req-1"}})
+	got := responseLogValue(r).String()
+	for _, want := range []string{"403", "req-1", "GET", "/api/v2/security_monitoring/rules"} {
+		if !_strings.Contains(got, want) {
+			t.Errorf("responseLogValue() = %s, want it to contain %q", got, want)
+		}
+	}
+	if _strings.Contains(got, "api-secret") {
+		t.Errorf("responseLogValue() = %s holds the API key", got)
+	}
+	if got := responseLogValue(nil).String(); got != "<nil>" {
+		t.Errorf("responseLogValue(nil) = %q", got)
+	}
+}
@@ -0,0 +1,212 @@
+package extV2
+
+import (
+	_context "context"
+	_tls "crypto/tls"
+	_x509 "crypto/x509"
+	_fmt "fmt"
+	_nethttp "net/http"
+	_neturl "net/url"
+	_os "os"
+	_strings "strings"
+	_time "time"
+
+	extension "github.com/kkumtree/dd-security-rule-extension-go/v2"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// DefaultRequestTimeout bounds a single API request when DD_REQUEST_TIMEOUT is not set
+const DefaultRequestTimeout = 60 * _time.Second
+
+// UserAgentProduct identifies this tool in the User-Agent header
+const UserAgentProduct = "dd-security-rule-extension-go"
+
+// HTTPConfig holds the endpoint, proxy and TLS settings of the Datadog API client
+type HTTPConfig struct {
+	BaseURL         string         // Full API base URL such as http://127.0.0.1:8080; overrides DD_SITE for requests
+	ProxyURL        string         // HTTP(S) proxy; HTTPS_PROXY/HTTP_PROXY/NO_PROXY apply when empty
+	CABundle        string         // PEM file with extra trusted CA certificates
+	ClientCert      string         // PEM client certificate for mutual TLS
+	ClientKey       string         // PEM private key of ClientCert
+	Timeout         _time.Duration // Per-request timeout (0 means no timeout)
+	UserAgentSuffix string         // Appended to the User-Agent after the tool name and version
+	CassetteMode    CassetteMode   // Record Datadog traffic to CassetteFile or replay it from there
+	CassetteFile    string         // Cassette JSON file of CassetteMode
+	Cassette        *Cassette      `json:"-"` // Open cassette of CassetteMode, set by Config.OpenCassette
+}
+
+// NewDatadogContext returns a context carrying the site and API keys of the config.
+// Unlike datadog.NewDefaultContext it never reads the process environment, so several
+// configs (and orgs) can be used from one process at the same time.
+func (c *Config) NewDatadogContext(parent _context.Context) _context.Context {
+	if parent == nil {
+		parent = _context.Background()
+	}
+
+	ctx := _context.WithValue(
+		parent,
+		datadog.ContextServerVariables,
+		map[string]string{"site": c.DDSite},
+	)
+	return _context.WithValue(
+		ctx,
+		datadog.ContextAPIKeys,
+		map[string]datadog.APIKey{
+			"apiKeyAuth": {Key: c.DDAPIKey},
+			"appKeyAuth": {Key: c.DDAppKey},
+		},
+	)
+}
+
+// NewDatadogConfiguration returns a new client configuration with the endpoint, proxy, TLS,
+// timeout and User-Agent settings of this config applied
+func (c *Config) NewDatadogConfiguration() (*datadog.Configuration, error) {
+	configuration := datadog.NewConfiguration()
+	configuration.UserAgent = c.HTTP.userAgent(configuration.UserAgent)
+
+	if c.HTTP.BaseURL != "" {
+		// A single server without variables makes every operation use the base URL
+		configuration.Servers = datadog.ServerConfigurations{
+			{URL: _strings.TrimRight(c.HTTP.BaseURL, "/"), Description: "Base URL override"},
+		}
+		configuration.OperationServers = map[string]datadog.ServerConfigurations{}
+	}
+
+	if _, err := c.OpenCassette(); err != nil {
+		return nil, err
+	}
+	httpClient, err := c.HTTP.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	configuration.HTTPClient = httpClient
+	return configuration, nil
+}
+
+// OpenCassette opens the cassette of CassetteMode once for this config, so every client built from it
+// records into or replays from the same cassette. It returns nil when no cassette is configured.
+func (c *Config) OpenCassette() (*Cassette, error) {
+	if c.HTTP.CassetteMode == CassetteOff || c.HTTP.Cassette != nil {
+		return c.HTTP.Cassette, nil
+	}
+	cassette, err := OpenCassette(c.HTTP.CassetteFile, c.HTTP.CassetteMode)
+	if err != nil {
+		return nil, err
+	}
+	c.HTTP.Cassette = cassette
+	return cassette, nil
+}
+
+// CloseCassette saves what this config's clients recorded and detaches the cassette, so the next
+// run opens a fresh one. Call it when the run ends, also after a failure.
+func (c *Config) CloseCassette() error {
+	if c.HTTP.Cassette == nil {
+		return nil
+	}
+	err := c.HTTP.Cassette.Close()
+	c.HTTP.Cassette = nil
+	return err
+}
+
+// NewSecurityMonitoringApi returns a SecurityMonitoringApi client with its own configuration
+func (c *Config) NewSecurityMonitoringApi() (*datadogV2.SecurityMonitoringApi, error) {
+	configuration, err := c.NewDatadogConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	return datadogV2.NewSecurityMonitoringApi(datadog.NewAPIClient(configuration)), nil
+}
+
+// NewSecurityMonitoringClient builds the request context and API client for a config in one call.
+// The context carries the config's middleware chain and logger, tagged with its org, so orgs of
+// one process never share them.
+func NewSecurityMonitoringClient(parent _context.Context, config *Config) (_context.Context, *datadogV2.SecurityMonitoringApi, error) {
+	api, err := config.NewSecurityMonitoringApi()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config.withCallContext(config.NewDatadogContext(parent)), api, nil
+}
+
+// withCallContext attaches the config's middleware chain and its logger, tagged with the org, to ctx
+func (c *Config) withCallContext(ctx _context.Context) _context.Context {
+	if c.Middlewares != nil {
+		ctx = ContextWithMiddlewares(ctx, c.Middlewares...)
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = LoggerFrom(ctx)
+	}
+	if c.Output.Org != "" {
+		logger = logger.With("org", c.Output.Org)
+	}
+	return ContextWithLogger(ctx, logger)
+}
+
+// userAgent appends the tool name, version and optional suffix to the client's User-Agent
+func (h HTTPConfig) userAgent(base string) string {
+	userAgent := _fmt.Sprintf("%s %s/%s", base, UserAgentProduct, extension.Version)
+	if h.UserAgentSuffix != "" {
+		userAgent += " " + h.UserAgentSuffix
+	}
+	return userAgent
+}
+
+// NewHTTPClient builds the HTTP client used for Datadog API requests
+func (h HTTPConfig) NewHTTPClient() (*_nethttp.Client, error) {
+	transport := _nethttp.DefaultTransport.(*_nethttp.Transport).Clone()
+
+	if h.ProxyURL != "" {
+		proxyURL, err := _neturl.Parse(h.ProxyURL)
+		if err != nil {
+			return nil, _fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = _nethttp.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := h.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if h.CassetteMode != CassetteOff {
+		if h.Cassette == nil {
+			return nil, _fmt.Errorf("cassette %s is not open; open it once per run with Config.OpenCassette", h.CassetteFile)
+		}
+		return &_nethttp.Client{Transport: h.Cassette.Transport(h.CassetteMode, transport), Timeout: h.Timeout}, nil
+	}
+	return &_nethttp.Client{Transport: transport, Timeout: h.Timeout}, nil
+}
+
+// tlsConfig adds the CA bundle to the system roots and loads the client certificate
+func (h HTTPConfig) tlsConfig() (*_tls.Config, error) {
+	tlsConfig := &_tls.Config{MinVersion: _tls.VersionTLS12}
+
+	if h.CABundle != "" {
+		pool, err := _x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = _x509.NewCertPool()
+		}
+		pem, err := _os.ReadFile(h.CABundle)
+		if err != nil {
+			return nil, _fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, _fmt.Errorf("CA bundle %s contains no PEM certificates", h.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if h.ClientCert != "" || h.ClientKey != "" {
+		cert, err := _tls.LoadX509KeyPair(h.ClientCert, h.ClientKey)
+		if err != nil {
+			return nil, _fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []_tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,176 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingpem "encoding/pem"
+	_io "io"
+	_nethttp "net/http"
+	_nethttptest "net/http/httptest"
+	_pathfilepath "path/filepath"
+	_strings "strings"
+	_testing "testing"
+	_time "time"
+
+	extension "github.com/kkumtree/dd-security-rule-extension-go/v2"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+)
+
+func TestNewDatadogContext(t *_testing.T) {
+	// The process environment must not leak into a context built from a config
+	t.Setenv("DD_SITE", "datadoghq.eu")
+	t.Setenv("DD_API_KEY", "env-api-key")
+	t.Setenv("DD_APP_KEY", "env-app-key")
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "us1", config: Config{DDSite: "datadoghq.com", DDAPIKey: "api-1", DDAppKey: "app-1"}},
+		{name: "us5", config: Config{DDSite: "us5.datadoghq.com", DDAPIKey: "api-5", DDAppKey: "app-5"}},
+		{name: "no keys", config: Config{DDSite: "ap1.datadoghq.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			ctx := tt.config.NewDatadogContext(_context.Background())
+
+			variables, _ := ctx.Value(datadog.ContextServerVariables).(map[string]string)
+			if variables["site"] != tt.config.DDSite {
+				t.Errorf("site = %q, want %q", variables["site"], tt.config.DDSite)
+			}
+			keys, _ := ctx.Value(datadog.ContextAPIKeys).(map[string]datadog.APIKey)
+			if keys["apiKeyAuth"].Key != tt.config.DDAPIKey || keys["appKeyAuth"].Key != tt.config.DDAppKey {
+				t.Errorf("keys = %q, %q; want %q, %q", keys["apiKeyAuth"].Key, keys["appKeyAuth"].Key, tt.config.DDAPIKey, tt.config.DDAppKey)
+			}
+		})
+	}
+
+	if ctx := (&Config{DDSite: "datadoghq.com"}).NewDatadogContext(nil); ctx == nil {
+		t.Error("NewDatadogContext(nil) returned nil")
+	}
+}
+
+func TestNewDatadogConfiguration(t *_testing.T) {
+	tests := []struct {
+		name          string
+		http          HTTPConfig
+		wantServerURL string // First server URL; "" keeps the site-based default
+		wantUserAgent string // Suffix of the User-Agent
+	}{
+		{
+			name:          "defaults",
+			wantUserAgent: UserAgentProduct + "/" + extension.Version,
+		},
+		{
+			name:          "base URL and user agent suffix",
+			http:          HTTPConfig{BaseURL: "http://127.0.0.1:8080/", UserAgentSuffix: "ci/42"},
+			wantServerURL: "http://127.0.0.1:8080",
+			wantUserAgent: UserAgentProduct + "/" + extension.Version + " ci/42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			config := &Config{HTTP: tt.http}
+			configuration, err := config.NewDatadogConfiguration()
+			if err != nil {
+				t.Fatalf("NewDatadogConfiguration() error = %v", err)
+			}
+			if !_strings.HasSuffix(configuration.UserAgent, tt.wantUserAgent) {
+				t.Errorf("UserAgent = %q, want suffix %q", configuration.UserAgent, tt.wantUserAgent)
+			}
+			if tt.wantServerURL != "" {
+				if len(configuration.Servers) != 1 || configuration.Servers[0].URL != tt.wantServerURL || len(configuration.OperationServers) != 0 {
+					t.Errorf("Servers = %+v, operation servers = %d; want only %s", configuration.Servers, len(configuration.OperationServers), tt.wantServerURL)
+				}
+			} else if len(configuration.Servers) < 2 {
+				t.Errorf("Servers = %+v, want the default site servers", configuration.Servers)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientTLS(t *_testing.T) {
+	server := _nethttptest.NewTLSServer(_nethttp.HandlerFunc(func(w _nethttp.ResponseWriter, r *_nethttp.Request) {
+		_io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caBundle := _pathfilepath.Join(dir, "ca.pem")
+	writeFile(t, caBundle, string(_encodingpem.EncodeToMemory(&_encodingpem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	notPEM := _pathfilepath.Join(dir, "not.pem")
+	writeFile(t, notPEM, "not a certificate")
+
+	tests := []struct {
+		name         string
+		http         HTTPConfig
+		wantBuildErr bool
+		wantGetErr   bool
+	}{
+		{name: "untrusted server", wantGetErr: true},
+		{name: "CA bundle", http: HTTPConfig{CABundle: caBundle, Timeout: 5 * _time.Second}},
+		{name: "missing CA bundle", http: HTTPConfig{CABundle: _pathfilepath.Join(dir, "missing.pem")}, wantBuildErr: true},
+		{name: "CA bundle without certificates", http: HTTPConfig{CABundle: notPEM}, wantBuildErr: true},
+		{name: "client certificate without its key", http: HTTPConfig{ClientCert: caBundle}, wantBuildErr: true},
+		{name: "cassette mode without an open cassette", http: HTTPConfig{CassetteMode: CassetteRecord, CassetteFile: "cassette.json"}, wantBuildErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			client, err := tt.http.NewHTTPClient()
+			if tt.wantBuildErr {
+				if err == nil {
+					t.Fatal("NewHTTPClient() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHTTPClient() error = %v", err)
+			}
+			if client.Timeout != tt.http.Timeout {
+				t.Errorf("Timeout = %v, want %v", client.Timeout, tt.http.Timeout)
+			}
+
+			response, err := client.Get(server.URL)
+			if tt.wantGetErr {
+				if err == nil {
+					response.Body.Close()
+					t.Fatal("GET succeeded, want a TLS error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			response.Body.Close()
+		})
+	}
+}
+
+func TestNewHTTPClientProxy(t *_testing.T) {
+	var proxied []string
+	proxy := _nethttptest.NewServer(_nethttp.HandlerFunc(func(w _nethttp.ResponseWriter, r *_nethttp.Request) {
+		proxied = append(proxied, r.URL.String())
+		_io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	client, err := HTTPConfig{ProxyURL: proxy.URL}.NewHTTPClient()
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	response, err := client.Get("http://api.datadoghq.invalid/api/v2/validate")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	response.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "http://api.datadoghq.invalid/api/v2/validate" {
+		t.Errorf("proxy received %v, want the request to the API", proxied)
+	}
+
+	if _, err := (HTTPConfig{ProxyURL: "http://[::1"}).NewHTTPClient(); err == nil {
+		t.Error("NewHTTPClient() accepted an invalid proxy URL")
+	}
+}
@@ -0,0 +1,126 @@
+package ddFake
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_errors "errors"
+	_nethttp "net/http"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+// connectCassette is connect with the cassette of mode open on the config
+func connectCassette(t *_testing.T, server *Server, mode extV2.CassetteMode, filename string) (*extV2.Config, _context.Context, *datadogV2.SecurityMonitoringApi) {
+	t.Helper()
+	config := &extV2.Config{
+		Pagination:  extV2.PaginationConfig{PageSize: DefaultPageSize},
+		Output:      extV2.OutputConfig{NoFiles: true},
+		Tagging:     extV2.TaggingConfig{MaxConcurrency: 1, Safety: extV2.SafetyConfig{ConfirmWrites: true}},
+		Middlewares: extV2.DefaultMiddlewares(),
+	}
+	server.Configure(config)
+	config.HTTP.CassetteMode = mode
+	config.HTTP.CassetteFile = filename
+	ctx, api, err := extV2.NewSecurityMonitoringClient(_context.Background(), config)
+	if err != nil {
+		t.Fatalf("NewSecurityMonitoringClient() error = %v", err)
+	}
+	return config, ctx, api
+}
+
+// listAndTag lists the rules and adds team:a to Rule A
+func listAndTag(ctx _context.Context, config *extV2.Config, api extV2.RuleStore) (*extV2.PaginatedResult, *extV2.BatchTaggingResult, error) {
+	listResult, err := extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	matchResult := &extV2.MatchResult{}
+	for _, rule := range listResult.Rules {
+		if rule.Name == "Rule A" {
+			matchResult.MatchedRules = append(matchResult.MatchedRules, extV2.MatchedRule{ID: rule.ID, Name: rule.Name, IsDefault: true, Tags: []string{"team:a"}})
+		}
+	}
+	taggingResult, err := extV2.TagRulesFromMatchResult(ctx, api, matchResult, config.Tagging)
+	return listResult, taggingResult, err
+}
+
+func TestCassetteRecordAndReplay(t *_testing.T) {
+	server := NewServer(testRules...)
+	filename := _pathfilepath.Join(t.TempDir(), "cassettes", "run.json")
+
+	config, ctx, api := connectCassette(t, server, extV2.CassetteRecord, filename)
+	recordedList, recordedTagging, err := listAndTag(ctx, config, api)
+	if err != nil {
+		t.Fatalf("recording run error = %v", err)
+	}
+	if err := config.CloseCassette(); err != nil {
+		t.Fatalf("CloseCassette() error = %v", err)
+	}
+	server.Close()
+
+	recording, err := _os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cassette was not saved: %v", err)
+	}
+	for _, secret := range []string{FakeAPIKey, FakeAppKey} {
+		if _bytes.Contains(recording, []byte(secret)) {
+			t.Errorf("cassette holds the credential %q", secret)
+		}
+	}
+
+	// The server is gone: every response comes from the cassette
+	config, ctx, api = connectCassette(t, server, extV2.CassetteReplay, filename)
+	replayedList, replayedTagging, err := listAndTag(ctx, config, api)
+	if err != nil {
+		t.Fatalf("replayed run error = %v", err)
+	}
+	if !_reflect.DeepEqual(replayedList.Rules, recordedList.Rules) {
+		t.Errorf("replayed listing = %+v, want %+v", replayedList.Rules, recordedList.Rules)
+	}
+	if replayedTagging.SuccessfulTags != 1 || !_reflect.DeepEqual(replayedTagging.Results, recordedTagging.Results) {
+		t.Errorf("replayed tagging = %+v, want %+v", replayedTagging.Results, recordedTagging.Results)
+	}
+
+	// Every interaction is used once, and replaying leaves the cassette as recorded
+	if _, err := extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output); !_errors.Is(err, extV2.ErrNetwork) {
+		t.Errorf("listing beyond the recording error = %v, want ErrNetwork", err)
+	}
+	if err := config.CloseCassette(); err != nil {
+		t.Fatalf("CloseCassette() error = %v", err)
+	}
+	if replayed, _ := _os.ReadFile(filename); !_bytes.Equal(replayed, recording) {
+		t.Error("replaying changed the cassette")
+	}
+}
+
+func TestRunOrgSavesCassetteOnFailure(t *_testing.T) {
+	server := NewServer(testRules...)
+	defer server.Close()
+	server.InjectFault(Fault{Operation: OperationList, StatusCode: _nethttp.StatusForbidden})
+	filename := _pathfilepath.Join(t.TempDir(), "run.json")
+
+	config := &extV2.Config{
+		Pagination:    extV2.PaginationConfig{PageSize: DefaultPageSize},
+		Output:        extV2.OutputConfig{NoFiles: true},
+		SkipPreflight: true,
+		HTTP:          extV2.HTTPConfig{CassetteMode: extV2.CassetteRecord, CassetteFile: filename},
+	}
+	server.Configure(config)
+
+	result := extV2.RunOrg(_context.Background(), config)
+	if result.Success || result.Stage != extV2.StageListing {
+		t.Fatalf("RunOrg() = %+v, want a listing failure", result)
+	}
+	cassette, err := extV2.LoadCassette(filename)
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+	if len(cassette.Interactions) != 1 || cassette.Interactions[0].Response.StatusCode != _nethttp.StatusForbidden {
+		t.Errorf("cassette of the failed run = %+v, want the forbidden listing", cassette.Interactions)
+	}
+}
@@ -0,0 +1,60 @@
+package ddFake
+
+import (
+	_errors "errors"
+	_sync "sync"
+	_testing "testing"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+func TestClientsForTwoOrgs(t *_testing.T) {
+	tests := []struct {
+		name      string
+		rules     []Rule
+		noAppKey  bool
+		wantRules int
+		wantErr   error
+	}{
+		{name: "prod", rules: testRules, wantRules: len(testRules)},
+		{name: "staging", rules: testRules[:2], wantRules: 2},
+		{name: "revoked", rules: testRules, noAppKey: true, wantErr: extV2.ErrPermission},
+	}
+
+	// Every org lists its own server with its own keys at the same time
+	var wg _sync.WaitGroup
+	results := make([]*extV2.PaginatedResult, len(tests))
+	errs := make([]error, len(tests))
+	for i, tt := range tests {
+		server := NewServer(tt.rules...)
+		defer server.Close()
+		config, ctx, api := connect(t, server)
+		if tt.noAppKey {
+			config.DDAppKey = ""
+			ctx = config.NewDatadogContext(ctx)
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, tt := range tests {
+		if tt.wantErr != nil {
+			if !_errors.Is(errs[i], tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, errs[i], tt.wantErr)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("%s: error = %v", tt.name, errs[i])
+			continue
+		}
+		if len(results[i].Rules) != tt.wantRules {
+			t.Errorf("%s: got %d rules, want %d", tt.name, len(results[i].Rules), tt.wantRules)
+		}
+	}
+}
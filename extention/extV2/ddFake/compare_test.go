@@ -0,0 +1,98 @@
+package ddFake
+
+import (
+	_context "context"
+	_errors "errors"
+	_nethttp "net/http"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+func TestCompareOrgs(t *_testing.T) {
+	// Default rules have their own IDs in each org
+	source := NewServer(testRules...)
+	defer source.Close()
+	target := NewServer(
+		Rule{Name: "Rule A", IsDefault: true, Tags: []string{"source:cloudtrail", "team:old"}},
+		Rule{Name: "Rule B", IsDefault: true},
+		Rule{Name: "Rule D", IsDefault: true, Tags: []string{"team:d"}},
+		Rule{Name: "Rule F"},
+	)
+	defer target.Close()
+
+	sourceConfig, _, _ := connect(t, source)
+	sourceConfig.Output.Org = "prod"
+	targetConfig, _, _ := connect(t, target)
+	targetConfig.Output.Org = "staging"
+	syncFile := _pathfilepath.Join(t.TempDir(), "sync.json")
+
+	result, err := extV2.CompareOrgs(_context.Background(), sourceConfig, targetConfig,
+		extV2.CompareConfig{SyncInputFile: syncFile}, extV2.OutputConfig{NoFiles: true})
+	if err != nil {
+		t.Fatalf("CompareOrgs() error = %v", err)
+	}
+
+	if result.SourceOrg != "prod" || result.TargetOrg != "staging" || result.CommonRules != 3 {
+		t.Errorf("CompareOrgs() = %s -> %s with %d common rules, want prod -> staging with 3", result.SourceOrg, result.TargetOrg, result.CommonRules)
+	}
+	var onlyInSource, onlyInTarget, differing []string
+	for _, rule := range result.OnlyInSource {
+		onlyInSource = append(onlyInSource, rule.Name)
+	}
+	for _, rule := range result.OnlyInTarget {
+		onlyInTarget = append(onlyInTarget, rule.Name)
+	}
+	for _, difference := range result.TagDifferences {
+		differing = append(differing, difference.Name)
+	}
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{name: "only in source", got: onlyInSource, want: []string{"Rule C", "Rule E"}},
+		{name: "only in target", got: onlyInTarget, want: []string{"Rule F"}},
+		{name: "tag differences", got: differing, want: []string{"Rule A", "Rule D"}},
+	}
+	for _, tt := range tests {
+		if !_reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// The sync input can be read back as a tagging input with the source tags
+	input, err := extV2.LoadInputJSON(_context.Background(), syncFile)
+	if err != nil {
+		t.Fatalf("LoadInputJSON() error = %v", err)
+	}
+	if len(input.Rules) != 2 || input.Rules[0].Name != "Rule A" || len(input.Rules[0].Tags) != 1 || input.Rules[0].Tags[0] != "source:cloudtrail" {
+		t.Errorf("sync input = %+v, want Rule A and Rule D with the source tags", input.Rules)
+	}
+	if source.CountRequests(OperationUpdate) != 0 || target.CountRequests(OperationUpdate) != 0 {
+		t.Error("CompareOrgs() updated rules")
+	}
+}
+
+func TestCompareOrgsListingFails(t *_testing.T) {
+	source := NewServer(testRules...)
+	defer source.Close()
+	target := NewServer(testRules...)
+	defer target.Close()
+	target.InjectFault(Fault{Operation: OperationList, StatusCode: _nethttp.StatusForbidden})
+
+	sourceConfig, _, _ := connect(t, source)
+	targetConfig, _, _ := connect(t, target)
+	syncFile := _pathfilepath.Join(t.TempDir(), "sync.json")
+
+	_, err := extV2.CompareOrgs(_context.Background(), sourceConfig, targetConfig,
+		extV2.CompareConfig{SyncInputFile: syncFile}, extV2.OutputConfig{NoFiles: true})
+	if !_errors.Is(err, extV2.ErrPermission) {
+		t.Errorf("CompareOrgs() error = %v, want ErrPermission", err)
+	}
+	if _, err := extV2.LoadInputJSON(_context.Background(), syncFile); err == nil {
+		t.Error("CompareOrgs() wrote a sync input after a failed listing")
+	}
+}
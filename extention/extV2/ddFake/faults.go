@@ -0,0 +1,104 @@
+package ddFake
+
+import (
+	_nethttp "net/http"
+	_strconv "strconv"
+	_time "time"
+)
+
+// Operation names a request the fake serves
+type Operation string
+
+const (
+	OperationValidate Operation = "validate" // GET /api/v1/validate
+	OperationList     Operation = "list"     // GET /api/v2/security_monitoring/rules
+	OperationGet      Operation = "get"      // GET /api/v2/security_monitoring/rules/{rule_id}
+	OperationUpdate   Operation = "update"   // PUT /api/v2/security_monitoring/rules/{rule_id}
+	OperationUnknown  Operation = "unknown"
+	OperationAny      Operation = "" // Matches every operation in a Fault
+)
+
+// Fault changes the response to requests of an operation
+type Fault struct {
+	Operation  Operation
+	StatusCode int               // Error status to answer with; 0 serves the request normally after Delay
+	Headers    map[string]string // Extra response headers of the error
+	Message    string            // Message of the errors array; the status text when empty
+	Delay      _time.Duration    // Wait before answering, or until the client gives up
+	Times      int               // Number of requests affected; 0 means every request
+
+	used int
+}
+
+func (f *Fault) message() string {
+	if f.Message != "" {
+		return f.Message
+	}
+	return _nethttp.StatusText(f.StatusCode)
+}
+
+// InjectFault adds a fault. Faults apply in the order they were injected, one per request.
+func (s *Server) InjectFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes every fault
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// takeFault returns the first fault left for the operation and counts its use; s.mu must be held
+func (s *Server) takeFault(operation Operation) *Fault {
+	for _, fault := range s.faults {
+		if fault.Operation != OperationAny && fault.Operation != operation {
+			continue
+		}
+		if fault.Times > 0 && fault.used >= fault.Times {
+			continue
+		}
+		fault.used++
+		return fault
+	}
+	return nil
+}
+
+// RateLimited answers times requests with 429 and Datadog's rate-limit headers, resetting after resetSeconds
+func RateLimited(operation Operation, times int, resetSeconds int) Fault {
+	return Fault{
+		Operation:  operation,
+		StatusCode: _nethttp.StatusTooManyRequests,
+		Headers: map[string]string{
+			"X-RateLimit-Limit":     "100",
+			"X-RateLimit-Period":    "60",
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     _strconv.Itoa(resetSeconds),
+			"X-RateLimit-Name":      "security_monitoring_rules",
+		},
+		Message: "Too many requests",
+		Times:   times,
+	}
+}
+
+// ServerError answers times requests with a 5xx status
+func ServerError(operation Operation, times int, status int) Fault {
+	return Fault{Operation: operation, StatusCode: status, Times: times}
+}
+
+// Slow delays times requests by delay before serving them normally
+func Slow(operation Operation, times int, delay _time.Duration) Fault {
+	return Fault{Operation: operation, Delay: delay, Times: times}
+}
+
+// Conflict answers times updates with 409, as when the rule changed since it was read
+func Conflict(times int) Fault {
+	return Fault{
+		Operation:  OperationUpdate,
+		StatusCode: _nethttp.StatusConflict,
+		Message:    "rule was modified concurrently",
+		Times:      times,
+	}
+}
@@ -0,0 +1,86 @@
+package ddFake
+
+import (
+	_context "context"
+	_errors "errors"
+	_nethttp "net/http"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_testing "testing"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+func TestRunOrgs(t *_testing.T) {
+	input := _pathfilepath.Join(t.TempDir(), "input.json")
+	data := `{"rules": [{"name": "Rule A", "isDefault": true, "tags": ["team:a"]}, {"name": "Rule E", "tags": ["team:e"]}]}`
+	if err := _os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_API_KEY", FakeAPIKey)
+	t.Setenv("FAKE_APP_KEY", FakeAppKey)
+
+	servers := map[string]*Server{
+		"prod":    NewServer(testRules...),
+		"staging": NewServer(testRules[:2]...),
+		"revoked": NewServer(testRules...),
+	}
+	for _, server := range servers {
+		defer server.Close()
+	}
+	servers["revoked"].InjectFault(Fault{Operation: OperationList, StatusCode: _nethttp.StatusForbidden})
+
+	tests := []struct {
+		org       extV2.OrgConfig
+		wantStage string // Failing stage, "" for success
+		wantTags  int    // Rules tagged
+	}{
+		{org: extV2.OrgConfig{Name: "prod", MaxConcurrency: 3}, wantTags: 2},
+		{org: extV2.OrgConfig{Name: "staging"}, wantTags: 1},
+		{org: extV2.OrgConfig{Name: "revoked"}, wantStage: extV2.StageListing},
+		{org: extV2.OrgConfig{Name: "no-keys"}, wantStage: "config"},
+	}
+
+	inventory := &extV2.OrgsInventory{}
+	for _, tt := range tests {
+		org := tt.org
+		if server, ok := servers[org.Name]; ok {
+			org.BaseURL = server.URL
+			org.APIKeyEnv, org.AppKeyEnv = "FAKE_API_KEY", "FAKE_APP_KEY"
+		}
+		inventory.Orgs = append(inventory.Orgs, org)
+	}
+	config := &extV2.Config{
+		DDSite:            "datadoghq.com",
+		InputRuleFilename: input,
+		Pagination:        extV2.PaginationConfig{PageSize: DefaultPageSize},
+		Tagging:           extV2.TaggingConfig{MaxConcurrency: 2, Safety: extV2.SafetyConfig{ConfirmWrites: true}},
+		Output:            extV2.OutputConfig{NoFiles: true},
+		SkipPreflight:     true,
+		MaxParallelOrgs:   2,
+		Middlewares:       extV2.DefaultMiddlewares(),
+	}
+
+	result, err := extV2.RunOrgs(_context.Background(), config, inventory)
+	if !_errors.Is(err, extV2.ErrOrgsFailed) || extV2.ExitCode(err) != extV2.ExitCodeOrgsFailed {
+		t.Errorf("RunOrgs() error = %v, want ErrOrgsFailed", err)
+	}
+	if result.Succeeded != 2 || result.Failed != 2 {
+		t.Errorf("got %d succeeded and %d failed orgs, want 2 and 2", result.Succeeded, result.Failed)
+	}
+
+	for i, tt := range tests {
+		orgResult := result.Orgs[i]
+		if orgResult.Org != tt.org.Name || orgResult.Success != (tt.wantStage == "") || orgResult.Stage != tt.wantStage {
+			t.Errorf("org %s: success = %v, stage = %q (%s); want stage %q", tt.org.Name, orgResult.Success, orgResult.Stage, orgResult.Error, tt.wantStage)
+		}
+		if orgResult.SuccessfulTags != tt.wantTags {
+			t.Errorf("org %s: tagged %d rules, want %d", tt.org.Name, orgResult.SuccessfulTags, tt.wantTags)
+		}
+		if server, ok := servers[tt.org.Name]; ok {
+			if got := server.CountRequests(OperationUpdate); got != tt.wantTags {
+				t.Errorf("org %s: server got %d updates, want %d", tt.org.Name, got, tt.wantTags)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ddFake
+
+import (
+	_errors "errors"
+	_nethttp "net/http"
+	_testing "testing"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+// probeRuleID is the rule the write probe of RunPreflight updates; it does not exist in a real org
+const probeRuleID = "dd-security-rule-extension-preflight-probe"
+
+func TestRunPreflight(t *_testing.T) {
+	forbidden := func(operation Operation) Fault {
+		return Fault{Operation: operation, StatusCode: _nethttp.StatusForbidden}
+	}
+
+	tests := []struct {
+		name       string
+		dryRun     bool
+		faults     []Fault
+		probeRule  bool                    // Serve a rule with the probe ID
+		want       []extV2.PreflightStatus // API key, read and write checks
+		wantFailed bool
+	}{
+		{name: "dry run skips the write probe", dryRun: true, want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightPassed, extV2.PreflightSkipped}},
+		{name: "probe rule not found passes", want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightPassed, extV2.PreflightPassed}},
+		{name: "probe rule updated passes", probeRule: true, want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightPassed, extV2.PreflightPassed}},
+		{name: "rejected API key", faults: []Fault{forbidden(OperationValidate)}, want: []extV2.PreflightStatus{extV2.PreflightFailed, extV2.PreflightPassed, extV2.PreflightPassed}, wantFailed: true},
+		{name: "missing read scope", faults: []Fault{forbidden(OperationList)}, want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightFailed, extV2.PreflightPassed}, wantFailed: true},
+		{name: "missing write scope", faults: []Fault{forbidden(OperationUpdate)}, want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightPassed, extV2.PreflightFailed}, wantFailed: true},
+		{name: "bad request leaves the write scope unverified", faults: []Fault{ServerError(OperationUpdate, 1, _nethttp.StatusBadRequest)}, want: []extV2.PreflightStatus{extV2.PreflightPassed, extV2.PreflightPassed, extV2.PreflightUnverified}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			server := NewServer(testRules...)
+			defer server.Close()
+			for _, fault := range tt.faults {
+				server.InjectFault(fault)
+			}
+			if tt.probeRule {
+				server.AddRule(Rule{ID: probeRuleID, Name: "Probe", Tags: []string{"team:a"}})
+			}
+			config, ctx, api := connect(t, server)
+			config.Tagging.DryRun = tt.dryRun
+
+			result, err := extV2.RunPreflight(ctx, config, api)
+			if tt.wantFailed {
+				if !_errors.Is(err, extV2.ErrPreflightFailed) || extV2.ExitCode(err) != extV2.ExitCodePreflightFailed {
+					t.Errorf("RunPreflight() error = %v, want ErrPreflightFailed with exit code 3", err)
+				}
+			} else if err != nil {
+				t.Errorf("RunPreflight() error = %v", err)
+			}
+			if result == nil || len(result.Checks) != len(tt.want) {
+				t.Fatalf("RunPreflight() result = %+v, want %d checks", result, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if check := result.Checks[i]; check.Status != want {
+					t.Errorf("check %s is %s (%s), want %s", check.Name, check.Status, check.Message, want)
+				} else if check.Status == extV2.PreflightFailed && check.Remedy == "" {
+					t.Errorf("failed check %s has no remedy", check.Name)
+				}
+			}
+
+			// The write probe only ever touches the probe rule, and only changes it when it exists
+			for _, rule := range server.Rules() {
+				if rule.ID != probeRuleID && rule.Version != 1 {
+					t.Errorf("rule %s was updated by the probe", rule.ID)
+				}
+			}
+			if tt.probeRule {
+				if message := result.Checks[2].Message; message == "" {
+					t.Error("write check does not report that the probe updated a rule")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package ddFake
+
+import (
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+	_time "time"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+// listCached lists server through a config using the rule cache file
+func listCached(t *_testing.T, server *Server, cache extV2.RuleCacheConfig, maxPages int64) *extV2.PaginatedResult {
+	t.Helper()
+	config, ctx, api := connect(t, server)
+	config.Pagination.Cache = cache
+	config.Pagination.PageSize = 2
+	config.Pagination.MaxPages = maxPages
+	result, err := extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+	if err != nil {
+		t.Fatalf("ProcessRuleListing() error = %v", err)
+	}
+	if result.Cache == nil {
+		t.Fatal("ProcessRuleListing() reported no cache stats")
+	}
+	return result
+}
+
+func TestRuleCacheListing(t *_testing.T) {
+	server := NewServer(testRules...)
+	defer server.Close()
+	cache := extV2.RuleCacheConfig{File: _pathfilepath.Join(t.TempDir(), "cache.json")}
+	rules := server.Rules()
+
+	changed := rules[0]
+	changed.Version++
+	changed.Tags = []string{"team:changed"}
+
+	tests := []struct {
+		name        string
+		server      *Server
+		change      func()
+		ttl         _time.Duration
+		maxPages    int64
+		wantRules   int
+		wantHits    int
+		wantMisses  int
+		wantEvicted int
+		wantSkipped bool
+	}{
+		{name: "first listing stores every rule", server: server, wantRules: 5, wantMisses: 5},
+		{name: "unchanged rules are served from the cache", server: server, wantRules: 5, wantHits: 5},
+		{name: "a new version is stored again", server: server, change: func() { server.AddRule(changed) }, wantRules: 5, wantHits: 4, wantMisses: 1},
+		{name: "a fresh cache skips listing", server: server, ttl: _time.Hour, wantRules: 5, wantHits: 5, wantSkipped: true},
+		{name: "a partial listing keeps unlisted rules", server: NewServer(rules[2:]...), maxPages: 1, wantRules: 2, wantHits: 2},
+		// The partial listing left the cache stale, so it is not served even within the TTL
+		{name: "a complete listing evicts unlisted rules", server: NewServer(rules[2:]...), ttl: _time.Hour, wantRules: 3, wantHits: 3, wantEvicted: 2},
+	}
+
+	for _, tt := range tests {
+		if tt.server != server {
+			defer tt.server.Close()
+		}
+		if tt.change != nil {
+			tt.change()
+		}
+		listRequests := tt.server.CountRequests(OperationList)
+		cache.TTL = tt.ttl
+
+		result := listCached(t, tt.server, cache, tt.maxPages)
+		stats := *result.Cache
+		if len(result.Rules) != tt.wantRules || stats.Hits != tt.wantHits || stats.Misses != tt.wantMisses || stats.Evicted != tt.wantEvicted || stats.ListingSkipped != tt.wantSkipped {
+			t.Errorf("%s: %d rules, stats %+v; want %d rules, %d hits, %d misses, %d evicted, skipped %v",
+				tt.name, len(result.Rules), stats, tt.wantRules, tt.wantHits, tt.wantMisses, tt.wantEvicted, tt.wantSkipped)
+		}
+		if skipped := tt.server.CountRequests(OperationList) == listRequests; skipped != tt.wantSkipped {
+			t.Errorf("%s: listing skipped = %v, want %v", tt.name, skipped, tt.wantSkipped)
+		}
+		if tt.change != nil && !_reflect.DeepEqual(result.Rules[0].Tags, changed.Tags) {
+			t.Errorf("%s: served tags %v, want the new version's %v", tt.name, result.Rules[0].Tags, changed.Tags)
+		}
+	}
+}
+
+func TestRuleCacheInvalidatedByTagging(t *_testing.T) {
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantSkipped bool // Whether the listing after tagging is served from the cache
+	}{
+		{name: "live run", dryRun: false},
+		{name: "dry run", dryRun: true, wantSkipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			server := NewServer(testRules...)
+			defer server.Close()
+			cache := extV2.RuleCacheConfig{File: _pathfilepath.Join(t.TempDir(), "cache.json"), TTL: _time.Hour}
+			listed := listCached(t, server, cache, 0)
+
+			config, ctx, api := connect(t, server)
+			config.Tagging.DryRun = tt.dryRun
+			config.Tagging.MaxConcurrency = 1
+			config.Tagging.RuleCacheFile = cache.File
+			matchResult := &extV2.MatchResult{MatchedRules: []extV2.MatchedRule{
+				{ID: listed.Rules[0].ID, Name: listed.Rules[0].Name, IsDefault: true, Tags: []string{"team:a"}},
+			}}
+			if _, err := extV2.ProcessRuleTagging(ctx, api, matchResult, config.Tagging, config.Output); err != nil {
+				t.Fatalf("ProcessRuleTagging() error = %v", err)
+			}
+
+			result := listCached(t, server, cache, 0)
+			if result.Cache.ListingSkipped != tt.wantSkipped {
+				t.Errorf("listing skipped = %v, want %v", result.Cache.ListingSkipped, tt.wantSkipped)
+			}
+			wantTags := []string{"source:cloudtrail"}
+			if !tt.dryRun {
+				wantTags = append(wantTags, "team:a")
+			}
+			if !_reflect.DeepEqual(result.Rules[0].Tags, wantTags) {
+				t.Errorf("listed tags = %v after tagging, want %v", result.Rules[0].Tags, wantTags)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ddFake
+
+import (
+	_errors "errors"
+	_testing "testing"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+func TestTaggingSafetyGuards(t *_testing.T) {
+	tests := []struct {
+		name        string
+		safety      extV2.SafetyConfig
+		dryRun      bool
+		wantErr     error
+		wantUpdates int
+		wantPrompts int
+	}{
+		{name: "dry run writes nothing", dryRun: true},
+		{name: "unconfirmed", wantErr: extV2.ErrWritesNotConfirmed},
+		{name: "confirmed by flag", safety: extV2.SafetyConfig{ConfirmWrites: true}, wantUpdates: 3},
+		{name: "one prompt for the whole batch", wantUpdates: 3, wantPrompts: 1},
+		{name: "MAX_CHANGES counts the whole batch", safety: extV2.SafetyConfig{ConfirmWrites: true, MaxChanges: 2}, wantErr: extV2.ErrSafetyLimit},
+		{name: "MAX_CHANGES_PERCENT against the listing", safety: extV2.SafetyConfig{ConfirmWrites: true, MaxChangesPercent: 50}, wantErr: extV2.ErrSafetyLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			server := NewServer(testRules...)
+			defer server.Close()
+			config, ctx, api := connect(t, server)
+
+			prompts := 0
+			config.Tagging.DryRun = tt.dryRun
+			config.Tagging.Safety = tt.safety
+			if tt.wantPrompts > 0 {
+				config.Tagging.Safety.Confirm = func(request extV2.WriteConfirmation) (bool, error) {
+					prompts++
+					if request.RulesToWrite != 3 || request.TotalRules != len(testRules) {
+						t.Errorf("confirmation asked for %d of %d rules, want 3 of %d", request.RulesToWrite, request.TotalRules, len(testRules))
+					}
+					return true, nil
+				}
+			}
+
+			// Three of the five rules get a new tag
+			rules := server.Rules()
+			matchResult := &extV2.MatchResult{TotalResultRules: len(rules)}
+			for _, rule := range rules[:3] {
+				matchResult.MatchedRules = append(matchResult.MatchedRules, extV2.MatchedRule{ID: rule.ID, Name: rule.Name, Tags: []string{"team:safety"}})
+			}
+
+			_, err := extV2.TagRulesFromMatchResult(ctx, api, matchResult, config.Tagging)
+			if !_errors.Is(err, tt.wantErr) {
+				t.Fatalf("TagRulesFromMatchResult() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := server.CountRequests(OperationUpdate); got != tt.wantUpdates {
+				t.Errorf("got %d updates, want %d", got, tt.wantUpdates)
+			}
+			if prompts != tt.wantPrompts {
+				t.Errorf("got %d prompts, want %d", prompts, tt.wantPrompts)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+// Package ddFake is an in-process fake of the Datadog Security Monitoring rules API for tests.
+//
+// It serves list (with pagination), get and update of rules from in-memory state, validates the
+// API key, and can inject faults: rate limits, server errors, slow responses and version conflicts.
+//
+//	server := ddFake.NewServer(ddFake.Rule{Name: "Rule A", IsDefault: true, Tags: []string{"team:a"}})
+//	defer server.Close()
+//	server.Configure(config)
+//	server.InjectFault(ddFake.RateLimited(ddFake.OperationList, 1, 1))
+package ddFake
+
+import (
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_nethttp "net/http"
+	_nethttptest "net/http/httptest"
+	_strconv "strconv"
+	_strings "strings"
+	_sync "sync"
+	_time "time"
+
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+// Rule types served by the fake; the client decodes them as standard and signal rules
+const (
+	TypeLogDetection      = "log_detection"
+	TypeSignalCorrelation = "signal_correlation"
+)
+
+// DefaultPageSize is used when a list request has no page[size]
+const DefaultPageSize = 10
+
+// API keys set by Configure; any non-empty keys are accepted
+const (
+	FakeAPIKey = "fake-api-key"
+	FakeAppKey = "fake-app-key"
+)
+
+const rulesPath = "/api/v2/security_monitoring/rules"
+
+// Rule is a security monitoring rule held by the fake
+type Rule struct {
+	ID        string // Generated when empty
+	Name      string
+	Type      string // TypeLogDetection (standard) when empty, or TypeSignalCorrelation
+	IsDefault bool
+	IsEnabled bool
+	Tags      []string
+	Message   string
+	Version   int64 // Starts at 1 and increases with every update
+}
+
+// Request is one request received by the fake
+type Request struct {
+	Operation  Operation
+	Method     string
+	Path       string
+	RuleID     string
+	StatusCode int
+}
+
+// Server is a running fake Datadog API
+type Server struct {
+	*_nethttptest.Server
+
+	mu        _sync.Mutex
+	rules     map[string]*Rule
+	order     []string // Rule IDs in creation order, the list order
+	faults    []*Fault
+	requests  []Request
+	nextID    int
+	requestID int
+}
+
+// NewServer starts a fake holding rules, in order
+func NewServer(rules ...Rule) *Server {
+	s := &Server{rules: make(map[string]*Rule)}
+	for _, rule := range rules {
+		s.AddRule(rule)
+	}
+	s.Server = _nethttptest.NewServer(_nethttp.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Configure points config at the fake with its base URL and fake keys
+func (s *Server) Configure(config *extV2.Config) {
+	config.HTTP.BaseURL = s.URL
+	config.DDAPIKey = FakeAPIKey
+	config.DDAppKey = FakeAppKey
+}
+
+// AddRule stores a rule and returns it with its ID, type and version filled in
+func (s *Server) AddRule(rule Rule) Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rule.ID == "" {
+		s.nextID++
+		rule.ID = _fmt.Sprintf("fake-rule-%d", s.nextID)
+	}
+	if rule.Type == "" {
+		rule.Type = TypeLogDetection
+	}
+	if rule.Version == 0 {
+		rule.Version = 1
+	}
+	rule.Tags = append([]string{}, rule.Tags...)
+
+	if _, exists := s.rules[rule.ID]; !exists {
+		s.order = append(s.order, rule.ID)
+	}
+	stored := rule
+	s.rules[rule.ID] = &stored
+	return rule
+}
+
+// Rule returns a copy of the rule with the ID
+func (s *Server) Rule(id string) (Rule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return Rule{}, false
+	}
+	return copyRule(rule), true
+}
+
+// Rules returns a copy of every rule in list order
+func (s *Server) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]Rule, 0, len(s.order))
+	for _, id := range s.order {
+		rules = append(rules, copyRule(s.rules[id]))
+	}
+	return rules
+}
+
+// Requests returns every request received so far, in arrival order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// CountRequests returns how many requests of an operation were received
+func (s *Server) CountRequests(operation Operation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, request := range s.requests {
+		if request.Operation == operation {
+			count++
+		}
+	}
+	return count
+}
+
+func copyRule(rule *Rule) Rule {
+	copied := *rule
+	copied.Tags = append([]string{}, rule.Tags...)
+	return copied
+}
+
+// serveHTTP routes a request, applying a matching fault first
+func (s *Server) serveHTTP(w _nethttp.ResponseWriter, r *_nethttp.Request) {
+	operation, ruleID := route(r)
+
+	s.mu.Lock()
+	s.requestID++
+	w.Header().Set("X-Request-Id", _fmt.Sprintf("fake-request-%d", s.requestID))
+	fault := s.takeFault(operation)
+	s.mu.Unlock()
+
+	recorder := &statusRecorder{ResponseWriter: w, status: _nethttp.StatusOK}
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Operation:  operation,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RuleID:     ruleID,
+			StatusCode: recorder.status,
+		})
+		s.mu.Unlock()
+	}()
+
+	if fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-_time.After(fault.Delay):
+			}
+		}
+		if fault.StatusCode != 0 {
+			for key, value := range fault.Headers {
+				recorder.Header().Set(key, value)
+			}
+			writeErrors(recorder, fault.StatusCode, fault.message())
+			return
+		}
+	}
+
+	// Key validation needs only the API key; rule endpoints need the application key too
+	if r.Header.Get("DD-API-KEY") == "" ||
+		(operation != OperationValidate && r.Header.Get("DD-APPLICATION-KEY") == "") {
+		writeErrors(recorder, _nethttp.StatusForbidden, "Forbidden")
+		return
+	}
+
+	switch operation {
+	case OperationValidate:
+		writeJSON(recorder, _nethttp.StatusOK, map[string]bool{"valid": true})
+	case OperationList:
+		s.list(recorder, r)
+	case OperationGet:
+		s.get(recorder, ruleID)
+	case OperationUpdate:
+		s.update(recorder, r, ruleID)
+	default:
+		writeErrors(recorder, _nethttp.StatusNotFound, _fmt.Sprintf("%s %s is not served by the fake", r.Method, r.URL.Path))
+	}
+}
+
+// route names the operation of a request and the rule ID it targets
+func route(r *_nethttp.Request) (Operation, string) {
+	switch {
+	case r.URL.Path == "/api/v1/validate" && r.Method == _nethttp.MethodGet:
+		return OperationValidate, ""
+	case r.URL.Path == rulesPath && r.Method == _nethttp.MethodGet:
+		return OperationList, ""
+	case _strings.HasPrefix(r.URL.Path, rulesPath+"/"):
+		ruleID := _strings.TrimPrefix(r.URL.Path, rulesPath+"/")
+		switch r.Method {
+		case _nethttp.MethodGet:
+			return OperationGet, ruleID
+		case _nethttp.MethodPut:
+			return OperationUpdate, ruleID
+		}
+		return OperationUnknown, ruleID
+	}
+	return OperationUnknown, ""
+}
+
+func (s *Server) list(w _nethttp.ResponseWriter, r *_nethttp.Request) {
+	query := r.URL.Query()
+	pageSize := DefaultPageSize
+	pageNumber := 0
+	if value := query.Get("page[size]"); value != "" {
+		parsed, err := _strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeErrors(w, _nethttp.StatusBadRequest, "page[size] must be a positive integer")
+			return
+		}
+		pageSize = parsed
+	}
+	if value := query.Get("page[number]"); value != "" {
+		parsed, err := _strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeErrors(w, _nethttp.StatusBadRequest, "page[number] must be a non-negative integer")
+			return
+		}
+		pageNumber = parsed
+	}
+
+	s.mu.Lock()
+	total := len(s.order)
+	data := make([]map[string]any, 0, pageSize)
+	for i := pageNumber * pageSize; i < total && i < (pageNumber+1)*pageSize; i++ {
+		data = append(data, ruleJSON(s.rules[s.order[i]]))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, _nethttp.StatusOK, map[string]any{
+		"data": data,
+		"meta": map[string]any{"page": map[string]int{"total_count": total, "total_filtered_count": total}},
+	})
+}
+
+func (s *Server) get(w _nethttp.ResponseWriter, ruleID string) {
+	s.mu.Lock()
+	rule, ok := s.rules[ruleID]
+	var body map[string]any
+	if ok {
+		body = ruleJSON(rule)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeErrors(w, _nethttp.StatusNotFound, "Not found")
+		return
+	}
+	writeJSON(w, _nethttp.StatusOK, body)
+}
+
+// updatePayload holds the fields of SecurityMonitoringRuleUpdatePayload the fake applies
+type updatePayload struct {
+	Name      *string   `json:"name"`
+	IsEnabled *bool     `json:"isEnabled"`
+	Message   *string   `json:"message"`
+	Tags      *[]string `json:"tags"`
+	Version   *int64    `json:"version"`
+}
+
+func (s *Server) update(w _nethttp.ResponseWriter, r *_nethttp.Request, ruleID string) {
+	var payload updatePayload
+	if err := _encodingjson.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeErrors(w, _nethttp.StatusBadRequest, _fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	rule, ok := s.rules[ruleID]
+	if !ok {
+		s.mu.Unlock()
+		writeErrors(w, _nethttp.StatusNotFound, "Not found")
+		return
+	}
+	if payload.Version != nil && *payload.Version != rule.Version {
+		current := rule.Version
+		s.mu.Unlock()
+		writeErrors(w, _nethttp.StatusConflict, _fmt.Sprintf("rule %s is at version %d, not %d", ruleID, current, *payload.Version))
+		return
+	}
+
+	if payload.Name != nil {
+		rule.Name = *payload.Name
+	}
+	if payload.IsEnabled != nil {
+		rule.IsEnabled = *payload.IsEnabled
+	}
+	if payload.Message != nil {
+		rule.Message = *payload.Message
+	}
+	if payload.Tags != nil {
+		rule.Tags = append([]string{}, (*payload.Tags)...)
+	}
+	rule.Version++
+	body := ruleJSON(rule)
+	s.mu.Unlock()
+
+	writeJSON(w, _nethttp.StatusOK, body)
+}
+
+// ruleJSON renders a rule with the fields the client needs to decode it as a standard or signal rule
+func ruleJSON(rule *Rule) map[string]any {
+	return map[string]any{
+		"id":               rule.ID,
+		"name":             rule.Name,
+		"type":             rule.Type,
+		"isDefault":        rule.IsDefault,
+		"isEnabled":        rule.IsEnabled,
+		"isDeleted":        false,
+		"tags":             append([]string{}, rule.Tags...),
+		"message":          rule.Message,
+		"version":          rule.Version,
+		"createdAt":        int64(1700000000000),
+		"creationAuthorId": int64(1),
+		"hasExtendedTitle": false,
+		"queries":          []any{},
+		"cases":            []any{},
+		"options":          map[string]any{},
+		"filters":          []any{},
+	}
+}
+
+func writeJSON(w _nethttp.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_encodingjson.NewEncoder(w).Encode(body)
+}
+
+// writeErrors answers with Datadog's error body: {"errors": ["message"]}
+func writeErrors(w _nethttp.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string][]string{"errors": {message}})
+}
+
+// statusRecorder remembers the status written for the request log
+type statusRecorder struct {
+	_nethttp.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
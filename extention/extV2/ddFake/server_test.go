@@ -0,0 +1,157 @@
+package ddFake
+
+import (
+	_context "context"
+	_errors "errors"
+	_nethttp "net/http"
+	_strings "strings"
+	_testing "testing"
+	_time "time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/kkumtree/dd-security-rule-extension-go/v2/extention/extV2"
+)
+
+var testRules = []Rule{
+	{Name: "Rule A", IsDefault: true, Tags: []string{"source:cloudtrail"}},
+	{Name: "Rule B", IsDefault: true},
+	{Name: "Rule C", Type: TypeSignalCorrelation, Tags: []string{"team:detection"}},
+	{Name: "Rule D", IsDefault: true},
+	{Name: "Rule E"},
+}
+
+// connect returns a config pointed at server and the context and client built from it.
+// The config writes no files and runs its API calls through chain.
+func connect(t *_testing.T, server *Server, chain ...extV2.Middleware) (*extV2.Config, _context.Context, *datadogV2.SecurityMonitoringApi) {
+	t.Helper()
+	config := &extV2.Config{
+		Pagination:  extV2.PaginationConfig{PageSize: DefaultPageSize},
+		Output:      extV2.OutputConfig{NoFiles: true},
+		Tagging:     extV2.TaggingConfig{Safety: extV2.SafetyConfig{ConfirmWrites: true}},
+		Middlewares: append(extV2.DefaultMiddlewares(), chain...),
+	}
+	server.Configure(config)
+	ctx, api, err := extV2.NewSecurityMonitoringClient(_context.Background(), config)
+	if err != nil {
+		t.Fatalf("NewSecurityMonitoringClient() error = %v", err)
+	}
+	return config, ctx, api
+}
+
+func TestProcessRuleListingPaginates(t *_testing.T) {
+	tests := []struct {
+		pageSize     int64
+		maxPages     int64
+		wantRules    int
+		wantRequests int
+	}{
+		{pageSize: 1, wantRules: 5, wantRequests: 6}, // The sixth page is empty
+		{pageSize: 2, wantRules: 5, wantRequests: 3}, // The third page is short
+		{pageSize: 5, wantRules: 5, wantRequests: 2},
+		{pageSize: 10, wantRules: 5, wantRequests: 1},
+		{pageSize: 2, maxPages: 2, wantRules: 4, wantRequests: 2},
+	}
+
+	for _, tt := range tests {
+		server := NewServer(testRules...)
+		defer server.Close()
+		config, ctx, api := connect(t, server)
+		config.Pagination.PageSize = tt.pageSize
+		config.Pagination.MaxPages = tt.maxPages
+
+		result, err := extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+		if err != nil {
+			t.Fatalf("page size %d: ProcessRuleListing() error = %v", tt.pageSize, err)
+		}
+		if len(result.Rules) != tt.wantRules {
+			t.Errorf("page size %d, max pages %d: got %d rules, want %d", tt.pageSize, tt.maxPages, len(result.Rules), tt.wantRules)
+		}
+		if got := server.CountRequests(OperationList); got != tt.wantRequests {
+			t.Errorf("page size %d, max pages %d: got %d list requests, want %d", tt.pageSize, tt.maxPages, got, tt.wantRequests)
+		}
+		for i, rule := range result.Rules {
+			if rule.Name != testRules[i].Name {
+				t.Errorf("page size %d: rule %d is %q, want %q", tt.pageSize, i, rule.Name, testRules[i].Name)
+			}
+		}
+	}
+}
+
+func TestRetryMiddlewareHonoursRateLimitReset(t *_testing.T) {
+	server := NewServer(testRules...)
+	defer server.Close()
+	server.InjectFault(RateLimited(OperationList, 2, 7))
+
+	// Record the delays the policy asks for instead of sleeping them
+	var delays []_time.Duration
+	policy := extV2.NewRetryPolicy(3, _time.Millisecond)
+	recording := func(call extV2.APICall, attempt int, r *_nethttp.Response, err error) (_time.Duration, bool) {
+		delay, retry := policy(call, attempt, r, err)
+		delays = append(delays, delay)
+		return 0, retry
+	}
+	config, ctx, api := connect(t, server, extV2.RetryMiddleware(recording))
+
+	result, err := extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+	if err != nil {
+		t.Fatalf("ProcessRuleListing() error = %v", err)
+	}
+	if len(result.Rules) != len(testRules) {
+		t.Errorf("got %d rules, want %d", len(result.Rules), len(testRules))
+	}
+	if got := server.CountRequests(OperationList); got != 3 {
+		t.Errorf("got %d list requests, want 2 rate-limited and 1 served", got)
+	}
+	if len(delays) != 2 || delays[0] != 7*_time.Second || delays[1] != 7*_time.Second {
+		t.Errorf("retry delays = %v, want X-RateLimit-Reset (7s) twice", delays)
+	}
+
+	// Without the retry middleware the rate limit reaches the caller
+	server.InjectFault(RateLimited(OperationList, 1, 7))
+	config, ctx, api = connect(t, server)
+	_, err = extV2.ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+	if !_errors.Is(err, extV2.ErrRateLimited) {
+		t.Errorf("ProcessRuleListing() error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestTaggingReportsConflictOnUpdate(t *_testing.T) {
+	server := NewServer(testRules[:2]...)
+	defer server.Close()
+	server.InjectFault(Conflict(1))
+	config, ctx, api := connect(t, server, extV2.RetryMiddleware(extV2.NewRetryPolicy(3, _time.Millisecond)))
+
+	rules := server.Rules()
+	matchResult := &extV2.MatchResult{
+		TotalResultRules: len(rules),
+		MatchedRules: []extV2.MatchedRule{
+			{ID: rules[0].ID, Name: rules[0].Name, Tags: []string{"team:a"}},
+			{ID: rules[1].ID, Name: rules[1].Name, Tags: []string{"team:b"}},
+		},
+	}
+	result, err := extV2.TagRulesFromMatchResult(ctx, api, matchResult, config.Tagging)
+	if err != nil {
+		t.Fatalf("TagRulesFromMatchResult() error = %v", err)
+	}
+
+	if result.SuccessfulTags != 1 || result.FailedTags != 1 {
+		t.Errorf("got %d successful and %d failed tags, want 1 and 1", result.SuccessfulTags, result.FailedTags)
+	}
+	// A conflict is not retried: the rule changed, so the plan must be made again
+	if got := server.CountRequests(OperationUpdate); got != 2 {
+		t.Errorf("got %d update requests, want 2", got)
+	}
+	if failed := result.Results[0]; failed.Success || !_strings.Contains(failed.Error, "409") {
+		t.Errorf("first rule: success = %v, error = %q, want a 409 failure", failed.Success, failed.Error)
+	}
+
+	for i, wantVersion := range []int64{1, 2} {
+		rule, _ := server.Rule(rules[i].ID)
+		if rule.Version != wantVersion {
+			t.Errorf("rule %s has version %d, want %d", rule.Name, rule.Version, wantVersion)
+		}
+	}
+	if rule, _ := server.Rule(rules[1].ID); len(rule.Tags) != 1 || rule.Tags[0] != "team:b" {
+		t.Errorf("rule %s has tags %v, want [team:b]", rule.Name, rule.Tags)
+	}
+}
@@ -0,0 +1,395 @@
+package extV2
+
+import (
+	_context "context"
+	_errors "errors"
+	_fmt "fmt"
+	_io "io"
+	_iofs "io/fs"
+	_os "os"
+	_strings "strings"
+)
+
+// DefaultEnvFilename is loaded from the working directory when no env file is given
+const DefaultEnvFilename = ".env"
+
+// ParseEnv parses dotenv-formatted data and returns the values in file order.
+//
+// Supported syntax: "export " prefixes, single-quoted literal values, double-quoted values with
+// \n, \r, \t, \", \\ and \$ escapes spanning several lines, unquoted values with inline " #"
+// comments and trailing-backslash line continuation, and ${VAR}, ${VAR:-default} and $VAR
+// expansion in double-quoted and unquoted values. Variables resolve against earlier keys of the
+// same data first and lookup second. Lines without "KEY=" are skipped, as the original parser
+// skipped them, and LoadEnvFile warns about them; unterminated quotes and other malformed values are errors.
+func ParseEnv(data string, lookup func(string) (string, bool)) (map[string]string, []string, error) {
+	values, keys, _, err := parseEnv(data, lookup)
+	return values, keys, err
+}
+
+// parseEnv is ParseEnv also returning the numbers of the skipped lines
+func parseEnv(data string, lookup func(string) (string, bool)) (map[string]string, []string, []int, error) {
+	p := &envParser{data: _strings.ReplaceAll(data, "\r\n", "\n"), line: 1, lookup: lookup}
+	values := make(map[string]string)
+	var keys []string
+	var skipped []int
+
+	for {
+		p.skipBlankAndComments()
+		if p.eof() {
+			return values, keys, skipped, nil
+		}
+
+		line := p.line
+		key, value, err := p.parseAssignment(values)
+		if _errors.Is(err, errNotAssignment) {
+			skipped = append(skipped, line)
+			p.skipToEOL()
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+}
+
+// errNotAssignment marks a line without a variable name followed by '='
+var errNotAssignment = _errors.New("not an assignment")
+
+// envParser is a cursor over dotenv data
+type envParser struct {
+	data   string
+	pos    int
+	line   int
+	lookup func(string) (string, bool)
+}
+
+func (p *envParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *envParser) peek() byte {
+	return p.data[p.pos]
+}
+
+func (p *envParser) next() byte {
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *envParser) errorf(format string, args ...any) error {
+	return _fmt.Errorf("line %d: %s", p.line, _fmt.Sprintf(format, args...))
+}
+
+// skipSpaces skips spaces and tabs on the current line
+func (p *envParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlankAndComments skips empty lines and whole-line comments
+func (p *envParser) skipBlankAndComments() {
+	for !p.eof() {
+		p.skipSpaces()
+		if p.eof() {
+			return
+		}
+		switch p.peek() {
+		case '\n':
+			p.next()
+		case '#':
+			p.skipToEOL()
+		default:
+			return
+		}
+	}
+}
+
+// skipToEOL skips the rest of the current line including the newline
+func (p *envParser) skipToEOL() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+// parseAssignment parses one KEY=VALUE entry
+func (p *envParser) parseAssignment(values map[string]string) (string, string, error) {
+	if _strings.HasPrefix(p.data[p.pos:], "export ") || _strings.HasPrefix(p.data[p.pos:], "export\t") {
+		p.pos += len("export")
+		p.skipSpaces()
+	}
+
+	start := p.pos
+	for !p.eof() && isEnvKeyChar(p.peek()) {
+		p.pos++
+	}
+	key := p.data[start:p.pos]
+	if key == "" {
+		return "", "", errNotAssignment
+	}
+
+	p.skipSpaces()
+	if p.eof() || p.peek() != '=' {
+		return "", "", errNotAssignment
+	}
+	p.pos++
+	p.skipSpaces()
+
+	var value string
+	var err error
+	switch {
+	case p.eof() || p.peek() == '\n':
+		value = ""
+	case p.peek() == '\'':
+		value, err = p.parseSingleQuoted()
+	case p.peek() == '"':
+		value, err = p.parseDoubleQuoted(values)
+	default:
+		value, err = p.parseUnquoted(values)
+	}
+	if err != nil {
+		return "", "", _fmt.Errorf("%s: %w", key, err)
+	}
+
+	// Only whitespace or a comment may follow a value on the same line
+	p.skipSpaces()
+	if !p.eof() {
+		switch p.peek() {
+		case '\n':
+			p.next()
+		case '#':
+			p.skipToEOL()
+		default:
+			return "", "", p.errorf("unexpected characters after value of %s", key)
+		}
+	}
+
+	return key, value, nil
+}
+
+func isEnvKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseSingleQuoted reads a literal value up to the closing quote
+func (p *envParser) parseSingleQuoted() (string, error) {
+	p.next()
+	var sb _strings.Builder
+	for !p.eof() {
+		c := p.next()
+		if c == '\'' {
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+	}
+	return "", p.errorf("unterminated single-quoted value")
+}
+
+// parseDoubleQuoted reads a value with escapes and variable expansion up to the closing quote
+func (p *envParser) parseDoubleQuoted(values map[string]string) (string, error) {
+	p.next()
+	var sb _strings.Builder
+	for !p.eof() {
+		c := p.next()
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated escape sequence")
+			}
+			switch escaped := p.next(); escaped {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '\n':
+				// Backslash-newline joins lines
+			default:
+				sb.WriteByte(escaped)
+			}
+		case '$':
+			expanded, err := p.expandVariable(values)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated double-quoted value")
+}
+
+// parseUnquoted reads a value up to the end of line or an inline comment
+func (p *envParser) parseUnquoted(values map[string]string) (string, error) {
+	var sb _strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '\n':
+			return _strings.TrimRight(sb.String(), " \t"), nil
+		case c == '#' && (p.data[p.pos-1] == ' ' || p.data[p.pos-1] == '\t'):
+			// " #" starts an inline comment
+			return _strings.TrimRight(sb.String(), " \t"), nil
+		case c == '\\' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '\n':
+			// Trailing backslash continues the value on the next line
+			p.next()
+			p.next()
+		case c == '$':
+			p.next()
+			expanded, err := p.expandVariable(values)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+		default:
+			sb.WriteByte(p.next())
+		}
+	}
+	return _strings.TrimRight(sb.String(), " \t"), nil
+}
+
+// expandVariable expands $VAR, ${VAR} or ${VAR:-default}; the leading '$' is already consumed
+func (p *envParser) expandVariable(values map[string]string) (string, error) {
+	resolve := func(name string) (string, bool) {
+		if value, ok := values[name]; ok {
+			return value, true
+		}
+		if p.lookup != nil {
+			return p.lookup(name)
+		}
+		return "", false
+	}
+
+	if p.eof() {
+		return "$", nil
+	}
+
+	if p.peek() == '{' {
+		end := _strings.IndexByte(p.data[p.pos:], '}')
+		if end < 0 {
+			return "", p.errorf("unterminated ${ expansion")
+		}
+		expr := p.data[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+
+		name, fallback, hasFallback := _strings.Cut(expr, ":-")
+		if value, ok := resolve(name); ok && (value != "" || !hasFallback) {
+			return value, nil
+		}
+		return fallback, nil
+	}
+
+	start := p.pos
+	for !p.eof() && (p.peek() == '_' || (p.peek() >= 'a' && p.peek() <= 'z') ||
+		(p.peek() >= 'A' && p.peek() <= 'Z') || (p.pos > start && p.peek() >= '0' && p.peek() <= '9')) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "$", nil
+	}
+	value, _ := resolve(p.data[start:p.pos])
+	return value, nil
+}
+
+// LoadEnvFile loads environment variables from a .env file without overriding variables that are already set
+func LoadEnvFile(ctx _context.Context, filename string) error {
+	_, err := loadEnvFile(ctx, filename, nil)
+	return err
+}
+
+// loadEnvFile applies a .env file to the process environment and returns the keys it set.
+// Variables that are already set are kept unless replaceable lists them.
+func loadEnvFile(ctx _context.Context, filename string, replaceable map[string]string) ([]string, error) {
+	file, err := _os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := _io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	values, keys, skipped, err := parseEnv(string(data), _os.LookupEnv)
+	if err != nil {
+		return nil, _fmt.Errorf("%s: %w", filename, err)
+	}
+	for _, line := range skipped {
+		LoggerFrom(ctx).Warn("Skipping env line that is not a KEY=VALUE assignment", "file", filename, "line", line)
+	}
+
+	var set []string
+	for _, key := range keys {
+		// Only set if environment variable is not already set
+		if _, ok := replaceable[key]; ok || _os.Getenv(key) == "" {
+			_os.Setenv(key, values[key])
+			set = append(set, key)
+		}
+	}
+	return set, nil
+}
+
+// LoadEnvFiles loads several .env files; earlier files win because existing variables are never overridden.
+// Files in required must exist, files in optional are skipped when missing.
+func LoadEnvFiles(ctx _context.Context, required []string, optional []string) (map[string]string, error) {
+	origins := make(map[string]string)
+	load := func(filename string, mustExist bool) error {
+		keys, err := loadEnvFile(ctx, filename, nil)
+		if err != nil {
+			if !mustExist && _errors.Is(err, _iofs.ErrNotExist) {
+				LoggerFrom(ctx).Debug("env file not found", "file", filename)
+				return nil
+			}
+			return _fmt.Errorf("failed to load env file: %w", err)
+		}
+		for _, key := range keys {
+			origins[key] = filename
+		}
+		LoggerFrom(ctx).Debug("Loaded env file", "file", filename, "variables", len(keys))
+		return nil
+	}
+
+	for _, filename := range required {
+		if err := load(filename, true); err != nil {
+			return origins, err
+		}
+	}
+	for _, filename := range optional {
+		if err := load(filename, false); err != nil {
+			return origins, err
+		}
+	}
+	return origins, nil
+}
+
+// loadProfileEnvFile loads .env.<profile> over the variables an earlier .env file set, as when that .env
+// selected the profile with PROFILE. Variables set outside env files are kept; a missing file is skipped.
+func loadProfileEnvFile(ctx _context.Context, profile string, origins map[string]string) error {
+	filename := DefaultEnvFilename + "." + profile
+	keys, err := loadEnvFile(ctx, filename, origins)
+	if _errors.Is(err, _iofs.ErrNotExist) {
+		LoggerFrom(ctx).Debug("env file not found", "file", filename)
+		return nil
+	}
+	if err != nil {
+		return _fmt.Errorf("failed to load env file: %w", err)
+	}
+	for _, key := range keys {
+		origins[key] = filename
+	}
+	LoggerFrom(ctx).Debug("Loaded env file", "file", filename, "variables", len(keys))
+	return nil
+}
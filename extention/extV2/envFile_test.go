@@ -0,0 +1,285 @@
+package extV2
+
+import (
+	_context "context"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+)
+
+func TestParseEnv(t *_testing.T) {
+	environment := map[string]string{"HOME": "/home/dd", "EMPTY": ""}
+	lookup := func(key string) (string, bool) {
+		value, ok := environment[key]
+		return value, ok
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "unquoted values are trimmed",
+			data: "DD_SITE = datadoghq.eu  \nPAGE_SIZE=50\n",
+			want: map[string]string{"DD_SITE": "datadoghq.eu", "PAGE_SIZE": "50"},
+		},
+		{
+			name: "empty value",
+			data: "TAG_FILTERS=\n",
+			want: map[string]string{"TAG_FILTERS": ""},
+		},
+		{
+			name: "export prefix",
+			data: "export DD_API_KEY=abc\nexport\tDD_APP_KEY=def\n",
+			want: map[string]string{"DD_API_KEY": "abc", "DD_APP_KEY": "def"},
+		},
+		{
+			name: "single quotes are literal",
+			data: `PATTERN='$HOME #not a comment \n'`,
+			want: map[string]string{"PATTERN": `$HOME #not a comment \n`},
+		},
+		{
+			name: "double quotes expand escapes",
+			data: `MESSAGE="line one\nline \"two\"\t\\"`,
+			want: map[string]string{"MESSAGE": "line one\nline \"two\"\t\\"},
+		},
+		{
+			name: "double quotes span lines",
+			data: "CERT=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT=1\n",
+			want: map[string]string{"CERT": "-----BEGIN-----\nabc\n-----END-----", "NEXT": "1"},
+		},
+		{
+			name: "inline comments",
+			data: "DRYRUN=true # keep dry\nQUOTED=\"a # b\" # comment\nHASH=a#b\n",
+			want: map[string]string{"DRYRUN": "true", "QUOTED": "a # b", "HASH": "a#b"},
+		},
+		{
+			name: "whole-line comments and blank lines",
+			data: "# comment\n\n   # indented comment\nKEY=value\n",
+			want: map[string]string{"KEY": "value"},
+		},
+		{
+			name: "escaped newline continues an unquoted value",
+			data: "INCLUDED_TAGS=source:cloudtrail,\\\nsource:okta\n",
+			want: map[string]string{"INCLUDED_TAGS": "source:cloudtrail,source:okta"},
+		},
+		{
+			name: "escaped newline inside double quotes joins lines",
+			data: "NOTE=\"first \\\nsecond\"\n",
+			want: map[string]string{"NOTE": "first second"},
+		},
+		{
+			name: "braced expansion from earlier keys and lookup",
+			data: "BASE=output\nOUTPUT_DIR=${BASE}/runs\nCACHE=${HOME}/.cache\n",
+			want: map[string]string{"BASE": "output", "OUTPUT_DIR": "output/runs", "CACHE": "/home/dd/.cache"},
+		},
+		{
+			name: "bare expansion",
+			data: "DIR=\"$HOME/dd\"\nRAW=$HOME\n",
+			want: map[string]string{"DIR": "/home/dd/dd", "RAW": "/home/dd"},
+		},
+		{
+			name: "expansion defaults",
+			data: "A=${MISSING:-fallback}\nB=${EMPTY:-fallback}\nC=${MISSING}\n",
+			want: map[string]string{"A": "fallback", "B": "fallback", "C": ""},
+		},
+		{
+			name: "no expansion in single quotes or for a lone dollar",
+			data: "PRICE='${HOME}'\nDOLLAR=5$\n",
+			want: map[string]string{"PRICE": "${HOME}", "DOLLAR": "5$"},
+		},
+		{
+			name: "CRLF line endings",
+			data: "A=1\r\nB=\"2\"\r\n",
+			want: map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name: "later keys override earlier ones",
+			data: "A=1\nA=2\n",
+			want: map[string]string{"A": "2"},
+		},
+		{
+			name: "lines without '=' are skipped",
+			data: "A=1\nnot an assignment\n=orphan\nB=2\n",
+			want: map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name:    "unterminated double quote",
+			data:    "A=\"open\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated single quote",
+			data:    "A='open\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated expansion",
+			data:    "A=${HOME\n",
+			wantErr: true,
+		},
+		{
+			name:    "text after a quoted value",
+			data:    "A=\"quoted\" trailing\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			got, _, err := ParseEnv(tt.data, lookup)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseEnv() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEnv() error = %v", err)
+			}
+			if !_reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvKeysInFileOrder(t *_testing.T) {
+	_, keys, err := ParseEnv("B=1\nA=2\nB=3\nC=4\n", nil)
+	if err != nil {
+		t.Fatalf("ParseEnv() error = %v", err)
+	}
+	if want := []string{"B", "A", "C"}; !_reflect.DeepEqual(keys, want) {
+		t.Errorf("ParseEnv() keys = %v, want %v", keys, want)
+	}
+}
+
+func TestLoadConfigEnvFileLayering(t *_testing.T) {
+	tests := []struct {
+		name        string
+		envFiles    map[string]string // Files written to the working directory
+		options     LoadOptions
+		environment map[string]string // Variables set before loading
+		wantProfile string
+		wantSite    string
+		wantPages   int64
+		wantSource  ConfigSource // Source of MAX_PAGES
+	}{
+		{
+			name:       ".env alone",
+			envFiles:   map[string]string{".env": "DD_SITE=datadoghq.eu\nMAX_PAGES=1\n"},
+			wantSite:   "datadoghq.eu",
+			wantPages:  1,
+			wantSource: SourceEnvFile,
+		},
+		{
+			name: "profile option layers .env.<profile> over .env",
+			envFiles: map[string]string{
+				".env":         "DD_SITE=datadoghq.eu\nMAX_PAGES=1\n",
+				".env.staging": "MAX_PAGES=2\n",
+			},
+			options:     LoadOptions{Profile: "staging"},
+			wantProfile: "staging",
+			wantSite:    "datadoghq.eu",
+			wantPages:   2,
+			wantSource:  SourceEnvFile,
+		},
+		{
+			name: "PROFILE set in .env layers .env.<profile> over .env",
+			envFiles: map[string]string{
+				".env":         "PROFILE=staging\nDD_SITE=datadoghq.eu\nMAX_PAGES=1\n",
+				".env.staging": "MAX_PAGES=2\n",
+			},
+			wantProfile: "staging",
+			wantSite:    "datadoghq.eu",
+			wantPages:   2,
+			wantSource:  SourceEnvFile,
+		},
+		{
+			name: "environment wins over both files",
+			envFiles: map[string]string{
+				".env":         "PROFILE=staging\nMAX_PAGES=1\n",
+				".env.staging": "MAX_PAGES=2\n",
+			},
+			environment: map[string]string{"MAX_PAGES": "3"},
+			wantProfile: "staging",
+			wantSite:    "datadoghq.com",
+			wantPages:   3,
+			wantSource:  SourceEnv,
+		},
+		{
+			name: "explicit env files replace the defaults",
+			envFiles: map[string]string{
+				".env":       "MAX_PAGES=1\n",
+				"custom.env": "MAX_PAGES=4\n",
+			},
+			options:    LoadOptions{EnvFiles: []string{"custom.env"}},
+			wantSite:   "datadoghq.com",
+			wantPages:  4,
+			wantSource: SourceEnvFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir)
+
+			// Clear every variable the files set so t.Setenv restores the environment afterwards
+			for _, key := range []string{"PROFILE", "DD_SITE", "MAX_PAGES", "ENV_FILE", "CONFIG_FILE"} {
+				t.Setenv(key, "")
+			}
+			for key, value := range tt.environment {
+				t.Setenv(key, value)
+			}
+			for name, data := range tt.envFiles {
+				writeFile(t, _pathfilepath.Join(dir, name), data)
+			}
+			writeFile(t, _pathfilepath.Join(dir, "input.json"), "[]")
+			writeFile(t, _pathfilepath.Join(dir, DefaultConfigFilename), "profiles:\n  staging: {}\n")
+
+			options := tt.options
+			options.Flags = map[string]string{"DD_API_KEY": "test-api-key", "DD_APP_KEY": "test-app-key"}
+			config, err := LoadConfigWithOptions(_context.Background(), options)
+			if err != nil {
+				t.Fatalf("LoadConfigWithOptions() error = %v", err)
+			}
+
+			if config.Profile != tt.wantProfile {
+				t.Errorf("Profile = %q, want %q", config.Profile, tt.wantProfile)
+			}
+			if config.DDSite != tt.wantSite {
+				t.Errorf("DDSite = %q, want %q", config.DDSite, tt.wantSite)
+			}
+			if config.Pagination.MaxPages != tt.wantPages {
+				t.Errorf("MaxPages = %d, want %d", config.Pagination.MaxPages, tt.wantPages)
+			}
+			if source := config.Sources["MAX_PAGES"].Source; source != tt.wantSource {
+				t.Errorf("MAX_PAGES source = %q, want %q", source, tt.wantSource)
+			}
+		})
+	}
+}
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *_testing.T, dir string) {
+	t.Helper()
+	previous, err := _os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := _os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _os.Chdir(previous) })
+}
+
+func writeFile(t *_testing.T, filename string, data string) {
+	t.Helper()
+	if err := _os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
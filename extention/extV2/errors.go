@@ -0,0 +1,321 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_fmt "fmt"
+	_logslog "log/slog"
+	_nethttp "net/http"
+	_strings "strings"
+	_utf8 "unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+)
+
+// ErrorKind classifies a failure so callers can react without parsing messages
+type ErrorKind string
+
+const (
+	ErrorKindAuth        ErrorKind = "auth"         // API or application key rejected (401)
+	ErrorKindPermission  ErrorKind = "permission"   // Key lacks a scope or permission (403)
+	ErrorKindNotFound    ErrorKind = "not-found"    // Rule or resource does not exist (404)
+	ErrorKindRateLimited ErrorKind = "rate-limited" // Too many requests (429)
+	ErrorKindValidation  ErrorKind = "validation"   // Request rejected as invalid (400, 422)
+	ErrorKindConflict    ErrorKind = "conflict"     // Resource changed concurrently (409)
+	ErrorKindNetwork     ErrorKind = "network"      // No response: DNS, TLS, proxy, timeout
+	ErrorKindInputFormat ErrorKind = "input-format" // Malformed input, inventory or snapshot file
+	ErrorKindServer      ErrorKind = "server"       // Datadog failed to handle the request (5xx)
+	ErrorKindUnknown     ErrorKind = "unknown"
+)
+
+// Sentinels matched by errors.Is for each kind, for example errors.Is(err, ErrNotFound)
+var (
+	ErrAuth        = _errors.New("authentication failed")
+	ErrPermission  = _errors.New("permission denied")
+	ErrNotFound    = _errors.New("not found")
+	ErrRateLimited = _errors.New("rate limited")
+	ErrValidation  = _errors.New("validation failed")
+	ErrConflict    = _errors.New("conflict")
+	ErrNetwork     = _errors.New("network error")
+	ErrInputFormat = _errors.New("invalid input format")
+	ErrServer      = _errors.New("server error")
+)
+
+var kindSentinels = map[ErrorKind]error{
+	ErrorKindAuth:        ErrAuth,
+	ErrorKindPermission:  ErrPermission,
+	ErrorKindNotFound:    ErrNotFound,
+	ErrorKindRateLimited: ErrRateLimited,
+	ErrorKindValidation:  ErrValidation,
+	ErrorKindConflict:    ErrConflict,
+	ErrorKindNetwork:     ErrNetwork,
+	ErrorKindInputFormat: ErrInputFormat,
+	ErrorKindServer:      ErrServer,
+}
+
+// requestIDHeaders are response headers that may carry the Datadog request ID, in order of preference
+var requestIDHeaders = []string{"X-Datadog-Request-Id", "Dd-Request-Id", "X-Request-Id"}
+
+// MaxErrorBodyBytes caps how much of an error response body is kept in diagnostics
+const MaxErrorBodyBytes = 2048
+
+// Error is a classified failure wrapping the original error
+type Error struct {
+	Kind       ErrorKind
+	Op         string   // API method such as "SecurityMonitoringApi.UpdateSecurityMonitoringRule", or the file operation
+	StatusCode int      // HTTP status, 0 when no response was received
+	RequestID  string   // Datadog request ID from the response headers, when present; quote it in support tickets
+	Messages   []string // Messages of the Datadog "errors" array of the response body
+	Body       string   // Response body trimmed to MaxErrorBodyBytes, kept when it held no "errors" array
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := _fmt.Sprintf("%s: %s error", e.Op, e.Kind)
+	if e.StatusCode != 0 {
+		msg += _fmt.Sprintf(" (HTTP %d", e.StatusCode)
+		if e.RequestID != "" {
+			msg += ", request ID " + e.RequestID
+		}
+		msg += ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if len(e.Messages) > 0 {
+		msg += ": " + _strings.Join(e.Messages, "; ")
+	}
+	return msg
+}
+
+// LogValue renders the diagnostics as a structured log group
+func (e *Error) LogValue() _logslog.Value {
+	attrs := []_logslog.Attr{
+		_logslog.String("kind", string(e.Kind)),
+		_logslog.String("op", e.Op),
+	}
+	if e.StatusCode != 0 {
+		attrs = append(attrs, _logslog.Int("status", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		attrs = append(attrs, _logslog.String("requestId", e.RequestID))
+	}
+	if len(e.Messages) > 0 {
+		attrs = append(attrs, _logslog.Any("messages", e.Messages))
+	}
+	if e.Body != "" {
+		attrs = append(attrs, _logslog.String("body", e.Body))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, _logslog.String("error", e.Err.Error()))
+	}
+	return _logslog.GroupValue(attrs...)
+}
+
+// Unwrap returns the original error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel of the error's kind
+func (e *Error) Is(target error) bool {
+	sentinel, ok := kindSentinels[e.Kind]
+	return ok && target == sentinel
+}
+
+// NewInputFormatError marks err as a malformed input file
+func NewInputFormatError(op string, err error) error {
+	return &Error{Kind: ErrorKindInputFormat, Op: op, Err: err}
+}
+
+// classifyAPIError wraps the error of an API call in an Error carrying its kind, status and request ID.
+// Cancellation and errors already classified by an inner call are returned unchanged.
+func classifyAPIError(call APICall, r *_nethttp.Response, err error) error {
+	var classified *Error
+	if _errors.As(err, &classified) || _errors.Is(err, _context.Canceled) {
+		return err
+	}
+
+	apiErr := &Error{
+		Kind: ErrorKindUnknown,
+		Op:   call.APIName + "." + call.MethodName,
+		Err:  err,
+	}
+	if r == nil {
+		apiErr.Kind = ErrorKindNetwork
+		return apiErr
+	}
+
+	apiErr.StatusCode = r.StatusCode
+	apiErr.RequestID = requestIDOf(r)
+	apiErr.Kind = ErrorKindForStatus(r.StatusCode)
+
+	var openAPIErr datadog.GenericOpenAPIError
+	if _errors.As(err, &openAPIErr) {
+		apiErr.Messages = ParseErrorMessages(openAPIErr.Body())
+		if len(apiErr.Messages) == 0 {
+			apiErr.Body = TrimBody(openAPIErr.Body(), MaxErrorBodyBytes)
+		}
+	}
+	return apiErr
+}
+
+// requestIDOf returns the request ID of a response, or "" when it carries none
+func requestIDOf(r *_nethttp.Response) string {
+	for _, header := range requestIDHeaders {
+		if requestID := r.Header.Get(header); requestID != "" {
+			return requestID
+		}
+	}
+	return ""
+}
+
+// ParseErrorMessages reads the "errors" array of a Datadog error body. Entries are either plain strings
+// or JSON:API error objects, which are rendered as "title: detail". It returns nil for any other body.
+func ParseErrorMessages(body []byte) []string {
+	var payload struct {
+		Errors []_encodingjson.RawMessage `json:"errors"`
+	}
+	if err := _encodingjson.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	var messages []string
+	for _, raw := range payload.Errors {
+		var text string
+		if _encodingjson.Unmarshal(raw, &text) == nil {
+			messages = append(messages, text)
+			continue
+		}
+		var object struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+		if _encodingjson.Unmarshal(raw, &object) != nil {
+			continue
+		}
+		parts := make([]string, 0, 2)
+		for _, part := range []string{object.Title, object.Detail} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) > 0 {
+			messages = append(messages, _strings.Join(parts, ": "))
+		}
+	}
+	return messages
+}
+
+// TrimBody returns body as text cut to at most limit bytes on a rune boundary, noting how much was dropped
+func TrimBody(body []byte, limit int) string {
+	if len(body) <= limit {
+		return string(body)
+	}
+	cut := limit
+	for cut > 0 && !_utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return _fmt.Sprintf("%s... (%d more bytes)", body[:cut], len(body)-cut)
+}
+
+// ErrorKindForStatus maps an HTTP status to an ErrorKind
+func ErrorKindForStatus(status int) ErrorKind {
+	switch {
+	case status == _nethttp.StatusUnauthorized:
+		return ErrorKindAuth
+	case status == _nethttp.StatusForbidden:
+		return ErrorKindPermission
+	case status == _nethttp.StatusNotFound:
+		return ErrorKindNotFound
+	case status == _nethttp.StatusTooManyRequests:
+		return ErrorKindRateLimited
+	case status == _nethttp.StatusBadRequest || status == _nethttp.StatusUnprocessableEntity:
+		return ErrorKindValidation
+	case status == _nethttp.StatusConflict:
+		return ErrorKindConflict
+	case status >= 500:
+		return ErrorKindServer
+	}
+	return ErrorKindUnknown
+}
+
+// ErrorKindOf returns the kind of the first classified error in err's chain, or ErrorKindUnknown
+func ErrorKindOf(err error) ErrorKind {
+	var classified *Error
+	if _errors.As(err, &classified) {
+		return classified.Kind
+	}
+	return ErrorKindUnknown
+}
+
+// Exit codes returned by ExitCode. They are stable: scripts may rely on them.
+//
+//	0  success
+//	1  unclassified failure
+//	2  invalid configuration (ConfigErrors)
+//	3  preflight check failed (ErrPreflightFailed)
+//	4  live writes aborted by a safety guard (ErrWritesNotConfirmed, ErrSafetyLimit)
+//	5  some orgs of a multi-org run failed (ErrOrgsFailed)
+//	10 authentication failed (ErrAuth)
+//	11 permission denied (ErrPermission)
+//	12 not found (ErrNotFound)
+//	13 rate limited (ErrRateLimited)
+//	14 request validation failed (ErrValidation)
+//	15 conflict (ErrConflict)
+//	16 network error (ErrNetwork)
+//	17 invalid input format (ErrInputFormat)
+//	18 Datadog server error (ErrServer)
+const (
+	ExitCodeOK              = 0
+	ExitCodeFailure         = 1
+	ExitCodeConfig          = 2
+	ExitCodePreflightFailed = 3
+	ExitCodeAborted         = 4
+	ExitCodeOrgsFailed      = 5
+	ExitCodeAuth            = 10
+	ExitCodePermission      = 11
+	ExitCodeNotFound        = 12
+	ExitCodeRateLimited     = 13
+	ExitCodeValidation      = 14
+	ExitCodeConflict        = 15
+	ExitCodeNetwork         = 16
+	ExitCodeInputFormat     = 17
+	ExitCodeServer          = 18
+)
+
+var kindExitCodes = map[ErrorKind]int{
+	ErrorKindAuth:        ExitCodeAuth,
+	ErrorKindPermission:  ExitCodePermission,
+	ErrorKindNotFound:    ExitCodeNotFound,
+	ErrorKindRateLimited: ExitCodeRateLimited,
+	ErrorKindValidation:  ExitCodeValidation,
+	ErrorKindConflict:    ExitCodeConflict,
+	ErrorKindNetwork:     ExitCodeNetwork,
+	ErrorKindInputFormat: ExitCodeInputFormat,
+	ErrorKindServer:      ExitCodeServer,
+}
+
+// ExitCode maps an error returned by the package to its documented process exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+
+	var configErrs ConfigErrors
+	switch {
+	case _errors.As(err, &configErrs):
+		return ExitCodeConfig
+	case _errors.Is(err, ErrPreflightFailed):
+		return ExitCodePreflightFailed
+	case _errors.Is(err, ErrWritesNotConfirmed), _errors.Is(err, ErrSafetyLimit):
+		return ExitCodeAborted
+	case _errors.Is(err, ErrOrgsFailed):
+		return ExitCodeOrgsFailed
+	}
+	if code, ok := kindExitCodes[ErrorKindOf(err)]; ok {
+		return code
+	}
+	return ExitCodeFailure
+}
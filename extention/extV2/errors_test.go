@@ -0,0 +1,217 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_errors "errors"
+	_fmt "fmt"
+	_logslog "log/slog"
+	_nethttp "net/http"
+	_reflect "reflect"
+	_strings "strings"
+	_testing "testing"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+)
+
+func TestClassifyAPIError(t *_testing.T) {
+	call := APICall{APIName: "SecurityMonitoringApi", MethodName: "GetSecurityMonitoringRule"}
+	failure := _errors.New("failed")
+
+	tests := []struct {
+		name          string
+		status        int // 0 for no response
+		header        _nethttp.Header
+		err           error
+		wantKind      ErrorKind
+		wantSentinel  error
+		wantRequestID string
+	}{
+		{name: "no response", err: failure, wantKind: ErrorKindNetwork, wantSentinel: ErrNetwork},
+		{name: "401", status: 401, err: failure, wantKind: ErrorKindAuth, wantSentinel: ErrAuth},
+		{name: "403", status: 403, err: failure, wantKind: ErrorKindPermission, wantSentinel: ErrPermission},
+		{name: "404", status: 404, err: failure, wantKind: ErrorKindNotFound, wantSentinel: ErrNotFound},
+		{name: "409", status: 409, err: failure, wantKind: ErrorKindConflict, wantSentinel: ErrConflict},
+		{name: "422", status: 422, err: failure, wantKind: ErrorKindValidation, wantSentinel: ErrValidation},
+		{name: "429", status: 429, err: failure, wantKind: ErrorKindRateLimited, wantSentinel: ErrRateLimited},
+		{name: "502", status: 502, err: failure, wantKind: ErrorKindServer, wantSentinel: ErrServer},
+		{name: "418", status: 418, err: failure, wantKind: ErrorKindUnknown},
+		{name: "request ID", status: 500, header: _nethttp.Header{"Dd-Request-Id": {"abc"}}, err: failure, wantKind: ErrorKindServer, wantSentinel: ErrServer, wantRequestID: "abc"},
+		{
+			name:         "already classified",
+			status:       500,
+			err:          _fmt.Errorf("wrapped: %w", &Error{Kind: ErrorKindNotFound, Err: failure}),
+			wantKind:     ErrorKindNotFound,
+			wantSentinel: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			var r *_nethttp.Response
+			if tt.status != 0 {
+				r = statusResponse(tt.status, tt.header)
+			}
+			err := classifyAPIError(call, r, tt.err)
+
+			if got := ErrorKindOf(err); got != tt.wantKind {
+				t.Errorf("ErrorKindOf() = %q, want %q", got, tt.wantKind)
+			}
+			if tt.wantSentinel != nil && !_errors.Is(err, tt.wantSentinel) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantSentinel)
+			}
+			if !_errors.Is(err, failure) {
+				t.Errorf("%v does not wrap the original error", err)
+			}
+			var classified *Error
+			if _errors.As(err, &classified) && classified.RequestID != tt.wantRequestID {
+				t.Errorf("RequestID = %q, want %q", classified.RequestID, tt.wantRequestID)
+			}
+		})
+	}
+
+	// Cancellation is left unclassified
+	if err := classifyAPIError(call, nil, _context.Canceled); err != _context.Canceled {
+		t.Errorf("classifyAPIError(context.Canceled) = %v", err)
+	}
+}
+
+func TestParseErrorMessages(t *_testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{body: `{"errors": ["Rule not found"]}`, want: []string{"Rule not found"}},
+		{body: `{"errors": [{"title": "Bad Request", "detail": "invalid tag"}, {"detail": "only detail"}, {}]}`, want: []string{"Bad Request: invalid tag", "only detail"}},
+		{body: `{"errors": []}`},
+		{body: `<html>Bad Gateway</html>`},
+	}
+	for _, tt := range tests {
+		if got := ParseErrorMessages([]byte(tt.body)); !_reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseErrorMessages(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTrimBody(t *_testing.T) {
+	tests := []struct {
+		body  string
+		limit int
+		want  string
+	}{
+		{body: "short", limit: 10, want: "short"},
+		{body: "0123456789", limit: 4, want: "0123... (6 more bytes)"},
+		{body: "ab€cd", limit: 3, want: "ab... (5 more bytes)"}, // The 3-byte euro sign is not split
+	}
+	for _, tt := range tests {
+		if got := TrimBody([]byte(tt.body), tt.limit); got != tt.want {
+			t.Errorf("TrimBody(%q, %d) = %q, want %q", tt.body, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestErrorMessage(t *_testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{
+			name: "full diagnostics",
+			err:  &Error{Kind: ErrorKindNotFound, Op: "Api.Get", StatusCode: 404, RequestID: "abc", Messages: []string{"gone", "really"}, Err: _errors.New("404 Not Found")},
+			want: "Api.Get: not-found error (HTTP 404, request ID abc): 404 Not Found: gone; really",
+		},
+		{
+			name: "no response",
+			err:  &Error{Kind: ErrorKindNetwork, Op: "Api.Get", Err: _errors.New("dial tcp")},
+			want: "Api.Get: network error: dial tcp",
+		},
+		{
+			name: "no wrapped error",
+			err:  &Error{Kind: ErrorKindServer, Op: "Api.Get", StatusCode: 500},
+			want: "Api.Get: server error (HTTP 500)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+			// Logging the diagnostics must not fail either
+			var buf _bytes.Buffer
+			_logslog.New(_logslog.NewTextHandler(&buf, nil)).Error("failed", "diagnostics", tt.err)
+			if !_strings.Contains(buf.String(), "diagnostics.kind="+string(tt.err.Kind)) {
+				t.Errorf("logged %q, want the kind", buf.String())
+			}
+		})
+	}
+}
+
+func TestExitCode(t *_testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", want: ExitCodeOK},
+		{name: "unclassified", err: _errors.New("boom"), want: ExitCodeFailure},
+		{name: "config", err: _fmt.Errorf("configuration error: %w", ConfigErrors{{Key: "PAGE_SIZE"}}), want: ExitCodeConfig},
+		{name: "preflight", err: _fmt.Errorf("%w: key rejected", ErrPreflightFailed), want: ExitCodePreflightFailed},
+		{name: "writes not confirmed", err: ErrWritesNotConfirmed, want: ExitCodeAborted},
+		{name: "safety limit", err: _fmt.Errorf("%w: too many changes", ErrSafetyLimit), want: ExitCodeAborted},
+		{name: "orgs failed", err: _fmt.Errorf("%w: 1 of 2", ErrOrgsFailed), want: ExitCodeOrgsFailed},
+		{name: "auth", err: &Error{Kind: ErrorKindAuth}, want: ExitCodeAuth},
+		{name: "wrapped permission", err: _fmt.Errorf("listing error: %w", &Error{Kind: ErrorKindPermission}), want: ExitCodePermission},
+		{name: "not found", err: &Error{Kind: ErrorKindNotFound}, want: ExitCodeNotFound},
+		{name: "rate limited", err: &Error{Kind: ErrorKindRateLimited}, want: ExitCodeRateLimited},
+		{name: "validation", err: &Error{Kind: ErrorKindValidation}, want: ExitCodeValidation},
+		{name: "conflict", err: &Error{Kind: ErrorKindConflict}, want: ExitCodeConflict},
+		{name: "network", err: &Error{Kind: ErrorKindNetwork}, want: ExitCodeNetwork},
+		{name: "input format", err: NewInputFormatError("LoadInputJSON", _errors.New("bad json")), want: ExitCodeInputFormat},
+		{name: "server", err: &Error{Kind: ErrorKindServer}, want: ExitCodeServer},
+		{name: "unknown kind", err: &Error{Kind: ErrorKindUnknown}, want: ExitCodeFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyAPIErrorBody(t *_testing.T) {
+	call := APICall{APIName: "SecurityMonitoringApi", MethodName: "UpdateSecurityMonitoringRule"}
+	longBody := "<html>" + _strings.Repeat("x", MaxErrorBodyBytes) + "</html>"
+
+	tests := []struct {
+		name         string
+		body         string
+		wantMessages []string
+		wantBody     string
+	}{
+		{name: "errors array", body: `{"errors": ["Invalid tag: team"]}`, wantMessages: []string{"Invalid tag: team"}},
+		{name: "short body kept", body: "upstream timeout", wantBody: "upstream timeout"},
+		{name: "long body trimmed", body: longBody, wantBody: TrimBody([]byte(longBody), MaxErrorBodyBytes)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			openAPIErr := datadog.GenericOpenAPIError{ErrorBody: []byte(tt.body), ErrorMessage: "400 Bad Request"}
+			err := classifyAPIError(call, statusResponse(400, nil), openAPIErr)
+
+			var classified *Error
+			if !_errors.As(err, &classified) {
+				t.Fatalf("classifyAPIError() = %v, want an *Error", err)
+			}
+			if !_reflect.DeepEqual(classified.Messages, tt.wantMessages) || classified.Body != tt.wantBody {
+				t.Errorf("messages = %q, body = %q; want %q, %q", classified.Messages, classified.Body, tt.wantMessages, tt.wantBody)
+			}
+			if len(classified.Body) > MaxErrorBodyBytes+len("... (999 more bytes)") {
+				t.Errorf("body of %d bytes is not trimmed", len(classified.Body))
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package extV2
+
+import (
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_io "io"
+	_os "os"
+	_sync "sync"
+	_time "time"
+)
+
+// EventType identifies a progress event
+type EventType string
+
+const (
+	EventPageFetched   EventType = "page_fetched"
+	EventRuleMatched   EventType = "rule_matched"
+	EventRuleSkipped   EventType = "rule_skipped"
+	EventTagPlanned    EventType = "tag_planned"
+	EventTagApplied    EventType = "tag_applied"
+	EventTagFailed     EventType = "tag_failed"
+	EventStageFinished EventType = "stage_finished"
+)
+
+// Event is a single machine-readable progress event
+type Event struct {
+	Type       EventType  `json:"type"`
+	RunID      string     `json:"runId"`
+	Org        string     `json:"org,omitempty"`
+	Time       _time.Time `json:"time"`
+	Stage      string     `json:"stage,omitempty"`
+	Page       int64      `json:"page,omitempty"`
+	Count      int        `json:"count,omitempty"`
+	RuleID     string     `json:"ruleId,omitempty"`
+	RuleName   string     `json:"ruleName,omitempty"`
+	OldTags    []string   `json:"oldTags,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	DryRun     bool       `json:"dryRun,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	DurationMs int64      `json:"durationMs,omitempty"`
+}
+
+// EventHandler receives progress events; it may be called from several goroutines
+type EventHandler func(Event)
+
+// NewNDJSONEventHandler returns an EventHandler that writes one JSON object per line to w
+func NewNDJSONEventHandler(w _io.Writer) EventHandler {
+	var mu _sync.Mutex
+	encoder := _encodingjson.NewEncoder(w)
+	return func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(event); err != nil {
+			Logger().Debug("failed to write event", "type", event.Type, "error", err)
+		}
+	}
+}
+
+// NewFDEventHandler returns an NDJSON EventHandler writing to an already open file descriptor
+func NewFDEventHandler(fd uintptr) (EventHandler, error) {
+	file := _os.NewFile(fd, _fmt.Sprintf("fd%d", fd))
+	if file == nil {
+		return nil, _fmt.Errorf("invalid event file descriptor %d", fd)
+	}
+	if _, err := file.Stat(); err != nil {
+		return nil, _fmt.Errorf("event file descriptor %d is not open: %w", fd, err)
+	}
+	return NewNDJSONEventHandler(file), nil
+}
+
+// MultiEventHandler fans events out to several handlers in order
+func MultiEventHandler(handlers ...EventHandler) EventHandler {
+	return func(event Event) {
+		for _, handler := range handlers {
+			if handler != nil {
+				handler(event)
+			}
+		}
+	}
+}
+
+// emit stamps an event with the run ID and time and passes it to the configured handler
+func (o OutputConfig) emit(event Event) {
+	if o.Events == nil {
+		return
+	}
+	event.RunID = o.RunID
+	event.Org = o.Org
+	if event.Time.IsZero() {
+		event.Time = _time.Now().UTC()
+	}
+	o.Events(event)
+}
+
+// startStage records the stage in the manifest and returns a function that also emits stage_finished
+func (o OutputConfig) startStage(stage string) func(err error) {
+	started := _time.Now()
+	finishManifestStage := o.Manifest.StartStage(stage)
+	return func(err error) {
+		finishManifestStage(err)
+		event := Event{
+			Type:       EventStageFinished,
+			Stage:      stage,
+			DurationMs: _time.Since(started).Milliseconds(),
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		o.emit(event)
+	}
+}
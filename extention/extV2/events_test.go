@@ -0,0 +1,60 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestNDJSONEventHandler(t *_testing.T) {
+	var buf _bytes.Buffer
+	var collected []Event
+	output := OutputConfig{
+		RunID: "run-1",
+		Org:   "prod",
+		Events: MultiEventHandler(
+			NewNDJSONEventHandler(&buf),
+			nil, // Skipped
+			func(event Event) { collected = append(collected, event) },
+		),
+	}
+
+	output.emit(Event{Type: EventPageFetched, Page: 1, Count: 100})
+	output.startStage(StageListing)(_errors.New("listing failed"))
+
+	lines := _strings.Split(_strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || len(collected) != 2 {
+		t.Fatalf("got %d lines and %d events, want 2 of each:\n%s", len(lines), len(collected), buf.String())
+	}
+
+	tests := []struct {
+		line      string
+		wantType  EventType
+		wantStage string
+		wantError string
+	}{
+		{line: lines[0], wantType: EventPageFetched},
+		{line: lines[1], wantType: EventStageFinished, wantStage: StageListing, wantError: "listing failed"},
+	}
+	for _, tt := range tests {
+		var event Event
+		if err := _encodingjson.Unmarshal([]byte(tt.line), &event); err != nil {
+			t.Fatalf("line %q is not JSON: %v", tt.line, err)
+		}
+		if event.Type != tt.wantType || event.Stage != tt.wantStage || event.Error != tt.wantError {
+			t.Errorf("event = %+v, want type %s, stage %q, error %q", event, tt.wantType, tt.wantStage, tt.wantError)
+		}
+		if event.RunID != "run-1" || event.Org != "prod" || event.Time.IsZero() {
+			t.Errorf("event %s is not stamped with the run, org and time: %+v", event.Type, event)
+		}
+	}
+}
+
+func TestEmitWithoutHandler(t *_testing.T) {
+	// Neither call may panic when no handler or manifest is configured
+	output := OutputConfig{}
+	output.emit(Event{Type: EventRuleMatched})
+	output.startStage(StageMatching)(nil)
+}
@@ -0,0 +1,535 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_encodingcsv "encoding/csv"
+	_fmt "fmt"
+	_htmltemplate "html/template"
+	_sort "sort"
+	_strconv "strconv"
+	_strings "strings"
+	_sync "sync"
+)
+
+// OutputFormat identifies a result file format
+type OutputFormat string
+
+const (
+	OutputFormatJSON     OutputFormat = "json"
+	OutputFormatCSV      OutputFormat = "csv"
+	OutputFormatMarkdown OutputFormat = "markdown"
+	OutputFormatHTML     OutputFormat = "html"
+)
+
+// ResultFormatter renders a result value for one output format
+type ResultFormatter struct {
+	Extension string                    // File extension without the leading dot
+	Format    func(any) (string, error) // Renders listing, match or tagging results
+}
+
+var (
+	formatterMu       _sync.RWMutex
+	formatterRegistry = map[OutputFormat]ResultFormatter{
+		OutputFormatJSON:     {Extension: "json", Format: FormatSimplifiedResultAny},
+		OutputFormatCSV:      {Extension: "csv", Format: FormatResultCSV},
+		OutputFormatMarkdown: {Extension: "md", Format: FormatResultMarkdown},
+		OutputFormatHTML:     {Extension: "html", Format: FormatResultHTML},
+	}
+)
+
+// RegisterFormatter adds or replaces the formatter used for an output format
+func RegisterFormatter(format OutputFormat, formatter ResultFormatter) {
+	formatterMu.Lock()
+	defer formatterMu.Unlock()
+	formatterRegistry[format] = formatter
+}
+
+// GetFormatter returns the registered formatter for an output format
+func GetFormatter(format OutputFormat) (ResultFormatter, error) {
+	formatterMu.RLock()
+	defer formatterMu.RUnlock()
+	formatter, ok := formatterRegistry[format]
+	if !ok {
+		return ResultFormatter{}, _fmt.Errorf("unknown output format %q", format)
+	}
+	return formatter, nil
+}
+
+// RegisteredFormats returns the names of all registered output formats, sorted
+func RegisteredFormats() []OutputFormat {
+	formatterMu.RLock()
+	defer formatterMu.RUnlock()
+	formats := make([]OutputFormat, 0, len(formatterRegistry))
+	for format := range formatterRegistry {
+		formats = append(formats, format)
+	}
+	_sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+	return formats
+}
+
+// ParseOutputFormats parses a comma-separated list of output formats ("md" is accepted for markdown)
+func ParseOutputFormats(value string) ([]OutputFormat, error) {
+	var formats []OutputFormat
+	seen := make(map[OutputFormat]bool)
+	for _, part := range _strings.Split(value, ",") {
+		name := _strings.ToLower(_strings.TrimSpace(part))
+		if name == "" {
+			continue
+		}
+		if name == "md" {
+			name = string(OutputFormatMarkdown)
+		}
+		format := OutputFormat(name)
+		if _, err := GetFormatter(format); err != nil {
+			return nil, err
+		}
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// resultTable is the tabular view of a result shared by the CSV, Markdown and HTML formatters
+type resultTable struct {
+	Title   string
+	Summary [][2]string
+	Headers []string
+	Rows    [][]string
+	Diffs   []tagDiff // Only set for tagging, comparison and snapshot diff results
+	Skipped []string  // Only set for tagging results
+}
+
+// tagDiff describes the before/after tags of a single tagging result
+type tagDiff struct {
+	RuleID   string
+	RuleName string
+	Added    []string
+	Removed  []string
+	Kept     []string
+}
+
+// diffTags compares old and new tag lists, preserving the order of each list
+func diffTags(oldTags []string, newTags []string) tagDiff {
+	oldSet := make(map[string]bool, len(oldTags))
+	for _, tag := range oldTags {
+		oldSet[tag] = true
+	}
+	newSet := make(map[string]bool, len(newTags))
+	for _, tag := range newTags {
+		newSet[tag] = true
+	}
+
+	diff := tagDiff{}
+	for _, tag := range newTags {
+		if oldSet[tag] {
+			diff.Kept = append(diff.Kept, tag)
+		} else {
+			diff.Added = append(diff.Added, tag)
+		}
+	}
+	for _, tag := range oldTags {
+		if !newSet[tag] {
+			diff.Removed = append(diff.Removed, tag)
+		}
+	}
+	return diff
+}
+
+// buildResultTable converts a known result type into a resultTable
+func buildResultTable(result any) (*resultTable, error) {
+	switch r := result.(type) {
+	case *PaginatedResult:
+		table := &resultTable{
+			Title: "Security Monitoring Rules",
+			Summary: [][2]string{
+				{"Total Rules", _strconv.Itoa(r.TotalRules)},
+				{"Total Pages", _strconv.Itoa(r.TotalPages)},
+			},
+			Headers: []string{"ID", "Name", "Default", "Tags"},
+		}
+		if r.Cache != nil {
+			table.Summary = append(table.Summary,
+				[2]string{"Cache Hits", _strconv.Itoa(r.Cache.Hits)},
+				[2]string{"Cache Misses", _strconv.Itoa(r.Cache.Misses)},
+				[2]string{"Cache Evicted", _strconv.Itoa(r.Cache.Evicted)},
+				[2]string{"Listing Skipped", _strconv.FormatBool(r.Cache.ListingSkipped)},
+			)
+		}
+		for _, rule := range r.Rules {
+			table.Rows = append(table.Rows, []string{
+				rule.ID, rule.Name, _strconv.FormatBool(rule.IsDefault), _strings.Join(rule.Tags, ", "),
+			})
+		}
+		return table, nil
+	case *MatchResult:
+		table := &resultTable{
+			Title: "Rule Matching Result",
+			Summary: [][2]string{
+				{"Total Input Rules", _strconv.Itoa(r.TotalInputRules)},
+				{"Total Result Rules", _strconv.Itoa(r.TotalResultRules)},
+				{"Total Matches", _strconv.Itoa(r.TotalMatches)},
+			},
+			Headers: []string{"ID", "Name", "Default", "Tags"},
+		}
+		for _, rule := range r.MatchedRules {
+			table.Rows = append(table.Rows, []string{
+				rule.ID, rule.Name, _strconv.FormatBool(rule.IsDefault), _strings.Join(rule.Tags, ", "),
+			})
+		}
+		return table, nil
+	case *BatchTaggingResult:
+		table := &resultTable{
+			Title: "Rule Tagging Result",
+			Summary: [][2]string{
+				{"Total Rules", _strconv.Itoa(r.TotalRules)},
+				{"Successfully Tagged", _strconv.Itoa(r.SuccessfulTags)},
+				{"Failed to Tag", _strconv.Itoa(r.FailedTags)},
+				{"Skipped Rules", _strconv.Itoa(len(r.SkippedRules))},
+			},
+			Headers: []string{"Rule ID", "Rule Name", "Success", "Old Tags", "New Tags", "Added", "Removed", "Error"},
+			Skipped: r.SkippedRules,
+		}
+		for _, res := range r.Results {
+			diff := diffTags(res.OldTags, res.NewTags)
+			diff.RuleID = res.RuleID
+			diff.RuleName = res.RuleName
+			table.Diffs = append(table.Diffs, diff)
+			table.Rows = append(table.Rows, []string{
+				res.RuleID,
+				res.RuleName,
+				_strconv.FormatBool(res.Success),
+				_strings.Join(res.OldTags, ", "),
+				_strings.Join(res.NewTags, ", "),
+				_strings.Join(diff.Added, ", "),
+				_strings.Join(diff.Removed, ", "),
+				res.Error,
+			})
+		}
+		return table, nil
+	case *PreflightResult:
+		table := &resultTable{
+			Title: "Preflight Checks",
+			Summary: [][2]string{
+				{"Site", r.Site},
+				{"Dry Run", _strconv.FormatBool(r.DryRun)},
+				{"Failed Checks", _strconv.Itoa(len(r.Failed()))},
+			},
+			Headers: []string{"Check", "Status", "Scope", "HTTP Status", "Message", "Remedy"},
+		}
+		for _, check := range r.Checks {
+			statusCode := ""
+			if check.StatusCode != 0 {
+				statusCode = _strconv.Itoa(check.StatusCode)
+			}
+			table.Rows = append(table.Rows, []string{
+				check.Name, string(check.Status), check.Scope, statusCode, check.Message, check.Remedy,
+			})
+		}
+		return table, nil
+	case *MultiOrgResult:
+		table := &resultTable{
+			Title: "Multi-Org Summary",
+			Summary: [][2]string{
+				{"Run ID", r.RunID},
+				{"Dry Run", _strconv.FormatBool(r.DryRun)},
+				{"Succeeded Orgs", _strconv.Itoa(r.Succeeded)},
+				{"Failed Orgs", _strconv.Itoa(r.Failed)},
+			},
+			Headers: []string{"Org", "Site", "Success", "Total Rules", "Matches", "Tagged", "Failed Tags", "Skipped", "Failed Stage", "Error"},
+		}
+		for _, org := range r.Orgs {
+			table.Rows = append(table.Rows, []string{
+				org.Org,
+				org.Site,
+				_strconv.FormatBool(org.Success),
+				_strconv.Itoa(org.TotalRules),
+				_strconv.Itoa(org.Matches),
+				_strconv.Itoa(org.SuccessfulTags),
+				_strconv.Itoa(org.FailedTags),
+				_strconv.Itoa(org.SkippedRules),
+				org.Stage,
+				org.Error,
+			})
+		}
+		return table, nil
+	case *CompareResult:
+		table := &resultTable{
+			Title: _fmt.Sprintf("Org Comparison: %s -> %s", r.SourceOrg, r.TargetOrg),
+			Summary: [][2]string{
+				{"Source Rules", _strconv.Itoa(r.SourceRules)},
+				{"Target Rules", _strconv.Itoa(r.TargetRules)},
+				{"Common Rules", _strconv.Itoa(r.CommonRules)},
+				{"Only in " + r.SourceOrg, _strconv.Itoa(len(r.OnlyInSource))},
+				{"Only in " + r.TargetOrg, _strconv.Itoa(len(r.OnlyInTarget))},
+				{"Tag Differences", _strconv.Itoa(len(r.TagDifferences))},
+			},
+			Headers: []string{"Name", "Default", "Status", "Source ID", "Target ID", "Source Tags", "Target Tags", "Missing in Target", "Extra in Target"},
+		}
+		for _, rule := range r.OnlyInSource {
+			table.Rows = append(table.Rows, []string{
+				rule.Name, _strconv.FormatBool(rule.IsDefault), "only in " + r.SourceOrg, rule.ID, "", _strings.Join(rule.Tags, ", "), "", "", "",
+			})
+		}
+		for _, rule := range r.OnlyInTarget {
+			table.Rows = append(table.Rows, []string{
+				rule.Name, _strconv.FormatBool(rule.IsDefault), "only in " + r.TargetOrg, "", rule.ID, "", _strings.Join(rule.Tags, ", "), "", "",
+			})
+		}
+		for _, difference := range r.TagDifferences {
+			table.Diffs = append(table.Diffs, tagDiff{
+				RuleID:   difference.TargetID,
+				RuleName: difference.Name,
+				Added:    difference.MissingInTarget,
+				Removed:  difference.ExtraInTarget,
+			})
+			table.Rows = append(table.Rows, []string{
+				difference.Name,
+				_strconv.FormatBool(difference.IsDefault),
+				"tags differ",
+				difference.SourceID,
+				difference.TargetID,
+				_strings.Join(difference.SourceTags, ", "),
+				_strings.Join(difference.TargetTags, ", "),
+				_strings.Join(difference.MissingInTarget, ", "),
+				_strings.Join(difference.ExtraInTarget, ", "),
+			})
+		}
+		return table, nil
+	case *SnapshotDiff:
+		table := &resultTable{
+			Title: "Rule Snapshot Diff",
+			Summary: [][2]string{
+				{"Old Snapshot", _fmt.Sprintf("%s (%d rules)", r.OldSnapshot, r.OldRules)},
+				{"New Snapshot", _fmt.Sprintf("%s (%d rules)", r.NewSnapshot, r.NewRules)},
+				{"Added", _strconv.Itoa(len(r.Added))},
+				{"Removed", _strconv.Itoa(len(r.Removed))},
+				{"Renamed", _strconv.Itoa(len(r.Renamed))},
+				{"Changed", _strconv.Itoa(len(r.Changed))},
+			},
+			Headers: []string{"Change", "Name", "Default", "ID", "Details"},
+		}
+		for _, rule := range r.Added {
+			table.Rows = append(table.Rows, []string{"added", rule.Name, _strconv.FormatBool(rule.IsDefault), rule.ID, _strings.Join(rule.Tags, ", ")})
+		}
+		for _, rule := range r.Removed {
+			table.Rows = append(table.Rows, []string{"removed", rule.Name, _strconv.FormatBool(rule.IsDefault), rule.ID, _strings.Join(rule.Tags, ", ")})
+		}
+		for _, rename := range r.Renamed {
+			table.Rows = append(table.Rows, []string{"renamed", rename.NewName, _strconv.FormatBool(rename.IsDefault), rename.ID, "was " + rename.OldName})
+		}
+		for _, change := range r.Changed {
+			table.Rows = append(table.Rows, []string{"changed", change.Name, _strconv.FormatBool(change.IsDefault), change.ID, change.Summary()})
+			if len(change.AddedTags) > 0 || len(change.RemovedTags) > 0 {
+				table.Diffs = append(table.Diffs, tagDiff{
+					RuleID:   change.ID,
+					RuleName: change.Name,
+					Added:    change.AddedTags,
+					Removed:  change.RemovedTags,
+				})
+			}
+		}
+		return table, nil
+	}
+	return nil, _fmt.Errorf("unsupported result type %T", result)
+}
+
+// FormatResultCSV formats a listing, match, tagging, preflight, multi-org, comparison or snapshot diff result as CSV
+func FormatResultCSV(result any) (string, error) {
+	table, err := buildResultTable(result)
+	if err != nil {
+		return "", err
+	}
+
+	var buf _bytes.Buffer
+	writer := _encodingcsv.NewWriter(&buf)
+	if err := writer.Write(table.Headers); err != nil {
+		return "", _fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	if err := writer.WriteAll(table.Rows); err != nil {
+		return "", _fmt.Errorf("failed to write CSV rows: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// escapeMarkdownCell escapes characters that would break a GitHub-flavoured Markdown table cell
+func escapeMarkdownCell(value string) string {
+	value = _strings.ReplaceAll(value, "\\", "\\\\")
+	value = _strings.ReplaceAll(value, "|", "\\|")
+	value = _strings.ReplaceAll(value, "\r\n", "<br>")
+	value = _strings.ReplaceAll(value, "\r", "<br>")
+	return _strings.ReplaceAll(value, "\n", "<br>")
+}
+
+// markdownLine keeps a value on one line so it cannot end a heading, list item or code block
+func markdownLine(value string) string {
+	return _strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+}
+
+// markdownCode wraps a value in a code span delimited by more backticks than it contains
+func markdownCode(value string) string {
+	value = markdownLine(value)
+	fence := "`"
+	for _strings.Contains(value, fence) {
+		fence += "`"
+	}
+	if _strings.HasPrefix(value, "`") || _strings.HasSuffix(value, "`") {
+		value = " " + value + " "
+	}
+	return fence + value + fence
+}
+
+// FormatResultMarkdown formats a listing, match, tagging, preflight, multi-org, comparison or snapshot diff result as GitHub-flavoured Markdown
+func FormatResultMarkdown(result any) (string, error) {
+	table, err := buildResultTable(result)
+	if err != nil {
+		return "", err
+	}
+
+	var sb _strings.Builder
+	_fmt.Fprintf(&sb, "# %s\n\n", markdownLine(table.Title))
+	for _, item := range table.Summary {
+		_fmt.Fprintf(&sb, "- **%s:** %s\n", markdownLine(item[0]), markdownLine(item[1]))
+	}
+	sb.WriteString("\n")
+
+	headers := make([]string, len(table.Headers))
+	for i, header := range table.Headers {
+		headers[i] = escapeMarkdownCell(header)
+	}
+	sb.WriteString("| " + _strings.Join(headers, " | ") + " |\n")
+	sb.WriteString("|" + _strings.Repeat(" --- |", len(table.Headers)) + "\n")
+	for _, row := range table.Rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escapeMarkdownCell(cell)
+		}
+		sb.WriteString("| " + _strings.Join(cells, " | ") + " |\n")
+	}
+
+	if len(table.Diffs) > 0 {
+		sb.WriteString("\n## Tag Changes\n")
+		for _, diff := range table.Diffs {
+			if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+				continue
+			}
+			// The fence is longer than any backtick run in the tags, so no tag can close it
+			fence := "```"
+			for _, tag := range append(append([]string{}, diff.Removed...), diff.Added...) {
+				for _strings.Contains(tag, fence) {
+					fence += "`"
+				}
+			}
+			_fmt.Fprintf(&sb, "\n### %s (%s)\n\n%sdiff\n", markdownLine(diff.RuleName), markdownCode(diff.RuleID), fence)
+			for _, tag := range diff.Removed {
+				_fmt.Fprintf(&sb, "- %s\n", markdownLine(tag))
+			}
+			for _, tag := range diff.Added {
+				_fmt.Fprintf(&sb, "+ %s\n", markdownLine(tag))
+			}
+			sb.WriteString(fence + "\n")
+		}
+	}
+
+	if len(table.Skipped) > 0 {
+		sb.WriteString("\n## Skipped Rules\n\n")
+		for _, ruleID := range table.Skipped {
+			_fmt.Fprintf(&sb, "- %s\n", markdownCode(ruleID))
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// htmlReportTemplate is a self-contained report page with click-to-sort tables
+var htmlReportTemplate = _htmltemplate.Must(_htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2rem; color: #1f2328; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2rem; }
+th, td { border: 1px solid #d0d7de; padding: 6px 10px; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; cursor: pointer; user-select: none; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+tr:nth-child(even) td { background: #fbfcfd; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 4px 16px; }
+dt { font-weight: 600; }
+.added { color: #1a7f37; }
+.removed { color: #cf222e; text-decoration: line-through; }
+.kept { color: #57606a; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<dl>
+{{- range .Summary}}
+<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{- end}}
+</dl>
+<table class="sortable">
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Diffs}}
+<h2>Tag Changes</h2>
+<table class="sortable">
+<thead><tr><th>Rule ID</th><th>Rule Name</th><th>Tags (before &rarr; after)</th></tr></thead>
+<tbody>
+{{- range .Diffs}}
+<tr><td>{{.RuleID}}</td><td>{{.RuleName}}</td><td>
+{{- range .Removed}}<div class="removed">- {{.}}</div>{{end}}
+{{- range .Added}}<div class="added">+ {{.}}</div>{{end}}
+{{- range .Kept}}<div class="kept">&nbsp; {{.}}</div>{{end}}
+</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+{{- if .Skipped}}
+<h2>Skipped Rules</h2>
+<ul>{{range .Skipped}}<li><code>{{.}}</code></li>{{end}}</ul>
+{{- end}}
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th").forEach(function (th, col) {
+    th.addEventListener("click", function () {
+      var asc = !th.classList.contains("asc");
+      table.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+      th.classList.add(asc ? "asc" : "desc");
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[col].textContent.trim(), y = b.cells[col].textContent.trim();
+        var cmp = x.localeCompare(y, undefined, { numeric: true, sensitivity: "base" });
+        return asc ? cmp : -cmp;
+      });
+      rows.forEach(function (row) { body.appendChild(row); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// FormatResultHTML formats a listing, match, tagging, preflight, multi-org, comparison or snapshot diff result as a single-file HTML report
+func FormatResultHTML(result any) (string, error) {
+	table, err := buildResultTable(result)
+	if err != nil {
+		return "", err
+	}
+
+	var buf _bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, table); err != nil {
+		return "", _fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	return buf.String(), nil
+}
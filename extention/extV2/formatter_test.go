@@ -0,0 +1,183 @@
+package extV2
+
+import (
+	_reflect "reflect"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestParseOutputFormats(t *_testing.T) {
+	tests := []struct {
+		value   string
+		want    []OutputFormat
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "json", want: []OutputFormat{OutputFormatJSON}},
+		{value: " CSV , md,html ", want: []OutputFormat{OutputFormatCSV, OutputFormatMarkdown, OutputFormatHTML}},
+		{value: "markdown,md,json,markdown", want: []OutputFormat{OutputFormatMarkdown, OutputFormatJSON}},
+		{value: "json,,csv", want: []OutputFormat{OutputFormatJSON, OutputFormatCSV}},
+		{value: "json,xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseOutputFormats(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseOutputFormats(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseOutputFormats(%q) error = %v", tt.value, err)
+			continue
+		}
+		if !_reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOutputFormats(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterFormatter(t *_testing.T) {
+	const format OutputFormat = "test-upper"
+	RegisterFormatter(format, ResultFormatter{Extension: "txt", Format: func(result any) (string, error) {
+		return _strings.ToUpper(result.(*PaginatedResult).Rules[0].Name), nil
+	}})
+	t.Cleanup(func() {
+		formatterMu.Lock()
+		defer formatterMu.Unlock()
+		delete(formatterRegistry, format)
+	})
+
+	formats, err := ParseOutputFormats("json,test-upper")
+	if err != nil || len(formats) != 2 {
+		t.Fatalf("ParseOutputFormats() = %v, %v; want the registered format accepted", formats, err)
+	}
+	formatter, err := GetFormatter(format)
+	if err != nil {
+		t.Fatalf("GetFormatter() error = %v", err)
+	}
+	got, _ := formatter.Format(&PaginatedResult{Rules: []SimplifiedRule{{Name: "rule a"}}})
+	if got != "RULE A" || formatter.Extension != "txt" {
+		t.Errorf("registered formatter gave %q with extension %q", got, formatter.Extension)
+	}
+}
+
+func TestFormatResults(t *_testing.T) {
+	listing := &PaginatedResult{
+		TotalRules: 2,
+		TotalPages: 1,
+		Rules: []SimplifiedRule{
+			{ID: "abc-123", Name: "Pipe | and\nnewline", IsDefault: true, Tags: []string{"source:okta", "team:a|b"}},
+			{ID: "def-456", Name: "<script>alert(1)</script>", Tags: []string{}},
+		},
+	}
+	tagging := &BatchTaggingResult{
+		TotalRules:     2,
+		SuccessfulTags: 1,
+		FailedTags:     1,
+		SkippedRules:   []string{"skip`ped"},
+		Results: []TaggingResult{
+			{RuleID: "abc-123", RuleName: "Rule\nA", Success: true, OldTags: []string{"a:1", "b:2"}, NewTags: []string{"a:1", "c:3"}},
+			{RuleID: "def-456", RuleName: "Rule B", Error: "Failed to update rule: 409"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		format    func(any) (string, error)
+		result    any
+		want      []string // Substrings of the output
+		wantLines int      // Number of lines, when the layout is fixed
+	}{
+		{
+			name:      "CSV listing quotes fields with separators and newlines",
+			format:    FormatResultCSV,
+			result:    listing,
+			want:      []string{"ID,Name,Default,Tags\n", "abc-123,\"Pipe | and\nnewline\",true,\"source:okta, team:a|b\"\n"},
+			wantLines: 4,
+		},
+		{
+			name:   "CSV tagging has added and removed tags",
+			format: FormatResultCSV,
+			result: tagging,
+			want:   []string{"abc-123,\"Rule\nA\",true,\"a:1, b:2\",\"a:1, c:3\",c:3,b:2,\n", "def-456,Rule B,false,,,,,Failed to update rule: 409\n"},
+		},
+		{
+			name:   "Markdown listing escapes table cells",
+			format: FormatResultMarkdown,
+			result: listing,
+			want: []string{
+				"# Security Monitoring Rules\n",
+				"- **Total Rules:** 2\n",
+				"| ID | Name | Default | Tags |\n| --- | --- | --- | --- |\n",
+				"| abc-123 | Pipe \\| and<br>newline | true | source:okta, team:a\\|b |\n",
+			},
+		},
+		{
+			name:   "Markdown tagging keeps names and IDs on one line",
+			format: FormatResultMarkdown,
+			result: tagging,
+			want: []string{
+				"### Rule A (`abc-123`)\n\n```diff\n- b:2\n+ c:3\n```\n",
+				"## Skipped Rules\n\n- ``skip`ped``\n",
+			},
+		},
+		{
+			name:   "HTML escapes rule names",
+			format: FormatResultHTML,
+			result: listing,
+			want:   []string{"<td>&lt;script&gt;alert(1)&lt;/script&gt;</td>", "<title>Security Monitoring Rules</title>"},
+		},
+		{
+			name:   "HTML tagging shows the tag diff",
+			format: FormatResultHTML,
+			result: tagging,
+			want:   []string{`<div class="removed">- b:2</div>`, `<div class="added">+ c:3</div>`, `<div class="kept">&nbsp; a:1</div>`, "<code>skip`ped</code>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			got, err := tt.format(tt.result)
+			if err != nil {
+				t.Fatalf("format error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !_strings.Contains(got, want) {
+					t.Errorf("output does not contain %q:\n%s", want, got)
+				}
+			}
+			if tt.wantLines > 0 {
+				if lines := _strings.Count(got, "\n"); lines != tt.wantLines {
+					t.Errorf("output has %d lines, want %d:\n%s", lines, tt.wantLines, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatResultUnsupportedType(t *_testing.T) {
+	for name, format := range map[string]func(any) (string, error){"csv": FormatResultCSV, "markdown": FormatResultMarkdown, "html": FormatResultHTML} {
+		if _, err := format(struct{}{}); err == nil {
+			t.Errorf("%s formatter accepted an unsupported result type", name)
+		}
+	}
+}
+
+func TestMarkdownCode(t *_testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "abc", want: "`abc`"},
+		{value: "a`b", want: "``a`b``"},
+		{value: "`a``", want: "``` `a`` ```"},
+		{value: "a\nb", want: "`a b`"},
+	}
+	for _, tt := range tests {
+		if got := markdownCode(tt.value); got != tt.want {
+			t.Errorf("markdownCode(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
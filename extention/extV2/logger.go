@@ -0,0 +1,218 @@
+package extV2
+
+import (
+	_context "context"
+	_fmt "fmt"
+	_io "io"
+	_logslog "log/slog"
+	_os "os"
+	_strings "strings"
+	_sync "sync"
+)
+
+const (
+	LogFormatHuman = "human"
+	LogFormatText  = "text"
+	LogFormatJSON  = "json"
+
+	// humanKey carries a pre-rendered block that the human handler prints instead of the message
+	humanKey = "human"
+)
+
+// LogConfig holds logging settings
+type LogConfig struct {
+	Level  _logslog.Level // Minimum level to log
+	Format string         // "human" (default), "text" or "json"
+}
+
+var (
+	loggerMu _sync.RWMutex
+	logger   = _logslog.New(NewHumanHandler(_os.Stdout, nil))
+)
+
+// SetLogger replaces the logger used by every function in this package
+func SetLogger(l *_logslog.Logger) {
+	if l == nil {
+		l = _logslog.New(discardHandler{})
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// Logger returns the logger used by this package
+func Logger() *_logslog.Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// loggerKey is the context key of the logger set by ContextWithLogger
+type loggerKey struct{}
+
+// ContextWithLogger returns a context whose API calls and stages log to l instead of Logger()
+func ContextWithLogger(ctx _context.Context, l *_logslog.Logger) _context.Context {
+	return _context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger of ctx, or Logger() if none was set
+func LoggerFrom(ctx _context.Context) *_logslog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*_logslog.Logger); ok && l != nil {
+		return l
+	}
+	return Logger()
+}
+
+// NewLogger builds a logger for the given settings that writes to w
+func NewLogger(config LogConfig, w _io.Writer) (*_logslog.Logger, error) {
+	opts := &_logslog.HandlerOptions{
+		Level: config.Level,
+		// Structured handlers get the individual attributes, not the human-readable block
+		ReplaceAttr: func(groups []string, a _logslog.Attr) _logslog.Attr {
+			if a.Key == humanKey {
+				return _logslog.Attr{}
+			}
+			return a
+		},
+	}
+
+	format, err := ParseLogFormat(config.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case LogFormatText:
+		return _logslog.New(_logslog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return _logslog.New(_logslog.NewJSONHandler(w, opts)), nil
+	}
+	return _logslog.New(NewHumanHandler(w, &_logslog.HandlerOptions{Level: config.Level})), nil
+}
+
+// ParseLogFormat parses human, text or json (case-insensitive); an empty value means human
+func ParseLogFormat(value string) (string, error) {
+	switch format := _strings.ToLower(_strings.TrimSpace(value)); format {
+	case "", LogFormatHuman:
+		return LogFormatHuman, nil
+	case LogFormatText, LogFormatJSON:
+		return format, nil
+	}
+	return "", _fmt.Errorf("unknown log format %q", value)
+}
+
+// ParseLogLevel parses debug, info, warn or error (case-insensitive)
+func ParseLogLevel(value string) (_logslog.Level, error) {
+	var level _logslog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return _logslog.LevelInfo, _fmt.Errorf("unknown log level %q", value)
+	}
+	return level, nil
+}
+
+// HumanHandler is a slog.Handler that prints plain console lines like the original CLI output
+type HumanHandler struct {
+	mu    *_sync.Mutex
+	w     _io.Writer
+	level _logslog.Leveler
+	attrs []_logslog.Attr
+	group string
+}
+
+// NewHumanHandler creates a HumanHandler that writes to w
+func NewHumanHandler(w _io.Writer, opts *_logslog.HandlerOptions) *HumanHandler {
+	handler := &HumanHandler{mu: &_sync.Mutex{}, w: w, level: _logslog.LevelInfo}
+	if opts != nil && opts.Level != nil {
+		handler.level = opts.Level
+	}
+	return handler
+}
+
+// Enabled reports whether the handler logs records at the given level
+func (h *HumanHandler) Enabled(_ _context.Context, level _logslog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle prints a record as "<prefix><message> (key: value, ...)"
+func (h *HumanHandler) Handle(_ _context.Context, record _logslog.Record) error {
+	var sb _strings.Builder
+
+	switch {
+	case record.Level >= _logslog.LevelError:
+		sb.WriteString("Error: ")
+	case record.Level >= _logslog.LevelWarn:
+		sb.WriteString("Warning: ")
+	case record.Level < _logslog.LevelInfo:
+		sb.WriteString("Debug: ")
+	}
+
+	var human string
+	var parts []string
+	appendAttr := func(a _logslog.Attr) bool {
+		a.Value = a.Value.Resolve()
+		if a.Key == humanKey {
+			human = a.Value.String()
+			return true
+		}
+		if a.Equal(_logslog.Attr{}) {
+			return true
+		}
+		parts = append(parts, _fmt.Sprintf("%s: %v", a.Key, a.Value.Any()))
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	record.Attrs(func(a _logslog.Attr) bool {
+		if h.group != "" && a.Key != humanKey {
+			a.Key = h.group + "." + a.Key
+		}
+		return appendAttr(a)
+	})
+
+	if human != "" {
+		sb.WriteString(human)
+	} else {
+		sb.WriteString(record.Message)
+		if len(parts) > 0 {
+			sb.WriteString(" (" + _strings.Join(parts, ", ") + ")")
+		}
+	}
+	sb.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := _io.WriteString(h.w, sb.String())
+	return err
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *HumanHandler) WithAttrs(attrs []_logslog.Attr) _logslog.Handler {
+	clone := *h
+	clone.attrs = append([]_logslog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" && a.Key != humanKey {
+			a.Key = h.group + "." + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+// WithGroup returns a handler that prefixes attribute keys with name
+func (h *HumanHandler) WithGroup(name string) _logslog.Handler {
+	clone := *h
+	if clone.group != "" {
+		clone.group += "." + name
+	} else {
+		clone.group = name
+	}
+	return &clone
+}
+
+// discardHandler drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(_context.Context, _logslog.Level) bool  { return false }
+func (discardHandler) Handle(_context.Context, _logslog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]_logslog.Attr) _logslog.Handler   { return d }
+func (d discardHandler) WithGroup(string) _logslog.Handler            { return d }
@@ -0,0 +1,109 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_context "context"
+	_logslog "log/slog"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestParseLogFormat(t *_testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: LogFormatHuman},
+		{value: "human", want: LogFormatHuman},
+		{value: " JSON ", want: LogFormatJSON},
+		{value: "text", want: LogFormatText},
+		{value: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLogFormat(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLogFormat(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewLogger(t *_testing.T) {
+	tests := []struct {
+		name    string
+		config  LogConfig
+		log     func(l *_logslog.Logger)
+		want    string // Exact output, or a substring when contains is set
+		contain bool
+	}{
+		{
+			name:   "human prints the human block instead of the message",
+			config: LogConfig{Format: LogFormatHuman},
+			log: func(l *_logslog.Logger) {
+				l.Info("Processing rule", "index", 1, humanKey, "Processing rule 1/2: Rule A")
+			},
+			want: "Processing rule 1/2: Rule A\n",
+		},
+		{
+			name:   "human appends attributes without a human block",
+			config: LogConfig{Format: LogFormatHuman},
+			log:    func(l *_logslog.Logger) { l.Warn("Retrying", "attempt", 2) },
+			want:   "Warning: Retrying (attempt: 2)\n",
+		},
+		{
+			name:   "human honours the level",
+			config: LogConfig{Format: LogFormatHuman, Level: _logslog.LevelWarn},
+			log:    func(l *_logslog.Logger) { l.Info("hidden") },
+			want:   "",
+		},
+		{
+			name:   "json keeps the attributes and drops the human block",
+			config: LogConfig{Format: LogFormatJSON},
+			log: func(l *_logslog.Logger) {
+				l.Info("Processing rule", "index", 1, "total", 2, humanKey, "Processing rule 1/2")
+			},
+			want:    `"msg":"Processing rule","index":1,"total":2}`,
+			contain: true,
+		},
+		{
+			name:    "text drops the human block",
+			config:  LogConfig{Format: LogFormatText},
+			log:     func(l *_logslog.Logger) { l.Info("done", "name", "Rule A", humanKey, "✅ done") },
+			want:    `msg=done name="Rule A"` + "\n",
+			contain: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			var buf _bytes.Buffer
+			logger, err := NewLogger(tt.config, &buf)
+			if err != nil {
+				t.Fatalf("NewLogger() error = %v", err)
+			}
+			tt.log(logger)
+			got := buf.String()
+			if tt.contain && !_strings.Contains(got, tt.want) || !tt.contain && got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewLogger(LogConfig{Format: "xml"}, &_bytes.Buffer{}); err == nil {
+		t.Error("NewLogger() accepted an unknown format")
+	}
+}
+
+func TestLoggerFrom(t *_testing.T) {
+	var buf _bytes.Buffer
+	logger, _ := NewLogger(LogConfig{}, &buf)
+
+	if LoggerFrom(_context.Background()) != Logger() {
+		t.Error("LoggerFrom() without a context logger is not Logger()")
+	}
+	ctx := ContextWithLogger(_context.Background(), logger.With("org", "prod"))
+	LoggerFrom(ctx).Info("listed")
+	if got := buf.String(); got != "listed (org: prod)\n" {
+		t.Errorf("context logger wrote %q", got)
+	}
+}
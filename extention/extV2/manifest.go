@@ -0,0 +1,315 @@
+package extV2
+
+import (
+	_cryptorand "crypto/rand"
+	_cryptosha256 "crypto/sha256"
+	_encodinghex "encoding/hex"
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_io "io"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_strings "strings"
+	_sync "sync"
+	_time "time"
+
+	extension "github.com/kkumtree/dd-security-rule-extension-go/v2"
+)
+
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+
+	// ManifestStage is the stage name used for manifest files
+	ManifestStage = "RunManifest"
+
+	redactedValue = "[REDACTED]"
+)
+
+// ArtifactRecord describes a file written during a run
+type ArtifactRecord struct {
+	Stage  string       `json:"stage"`
+	Format OutputFormat `json:"format"`
+	Path   string       `json:"path"`
+	Size   int64        `json:"size"`
+	SHA256 string       `json:"sha256"`
+}
+
+// StageRecord holds timing and outcome of a single pipeline stage
+type StageRecord struct {
+	Name       string     `json:"name"`
+	StartedAt  _time.Time `json:"startedAt"`
+	FinishedAt _time.Time `json:"finishedAt"`
+	DurationMs int64      `json:"durationMs"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// RunManifest ties together the configuration, timings and artifacts of one run
+type RunManifest struct {
+	RunID       string           `json:"runId"`
+	ToolVersion string           `json:"toolVersion"`
+	DDSite      string           `json:"ddSite"`
+	StartedAt   _time.Time       `json:"startedAt"`
+	FinishedAt  _time.Time       `json:"finishedAt,omitempty"`
+	DurationMs  int64            `json:"durationMs"`
+	Status      string           `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	Config      Config           `json:"config"`
+	Stages      []StageRecord    `json:"stages"`
+	Artifacts   []ArtifactRecord `json:"artifacts"`
+
+	mu     _sync.Mutex
+	output OutputConfig
+}
+
+// NewRunID generates a sortable, unique run identifier
+func NewRunID() string {
+	suffix := make([]byte, 4)
+	if _, err := _cryptorand.Read(suffix); err != nil {
+		return _time.Now().UTC().Format("20060102T150405.000000000Z")
+	}
+	return _time.Now().UTC().Format("20060102T150405Z") + "-" + _encodinghex.EncodeToString(suffix)
+}
+
+// Redacted returns a copy of the configuration with credentials replaced
+func (c Config) Redacted() Config {
+	if c.DDAPIKey != "" {
+		c.DDAPIKey = redactedValue
+	}
+	if c.DDAppKey != "" {
+		c.DDAppKey = redactedValue
+	}
+	c.HTTP.BaseURL = redactURL(c.HTTP.BaseURL)
+	c.HTTP.ProxyURL = redactURL(c.HTTP.ProxyURL)
+	if c.Sources != nil {
+		sources := make(map[string]ResolvedValue, len(c.Sources))
+		for key, resolved := range c.Sources {
+			resolved.Value = RedactValue(key, resolved.Value)
+			sources[key] = resolved
+		}
+		c.Sources = sources
+	}
+	c.Output.Manifest = nil
+	c.Output.Events = nil
+	return c
+}
+
+// NewRunManifest starts a manifest for a run and attaches it to config.Output so stages record into it
+func NewRunManifest(config *Config) *RunManifest {
+	if config.Output.RunID == "" {
+		config.Output.RunID = NewRunID()
+	}
+
+	manifest := &RunManifest{
+		RunID:       config.Output.RunID,
+		ToolVersion: extension.Version,
+		DDSite:      config.DDSite,
+		StartedAt:   _time.Now(),
+		Status:      RunStatusRunning,
+		Config:      config.Redacted(),
+		Stages:      []StageRecord{},
+		Artifacts:   []ArtifactRecord{},
+		output:      config.Output,
+	}
+	config.Output.Manifest = manifest
+	return manifest
+}
+
+// StartStage records the start of a stage and returns a function that records its end
+func (m *RunManifest) StartStage(name string) func(err error) {
+	if m == nil {
+		return func(error) {}
+	}
+	started := _time.Now()
+	return func(err error) {
+		finished := _time.Now()
+		record := StageRecord{
+			Name:       name,
+			StartedAt:  started,
+			FinishedAt: finished,
+			DurationMs: finished.Sub(started).Milliseconds(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.Stages = append(m.Stages, record)
+	}
+}
+
+// RecordArtifact adds a written file to the manifest along with its SHA-256 checksum
+func (m *RunManifest) RecordArtifact(stage string, format OutputFormat, path string) error {
+	if m == nil {
+		return nil
+	}
+
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return _fmt.Errorf("failed to checksum artifact %s: %w", path, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Artifacts = append(m.Artifacts, ArtifactRecord{
+		Stage:  stage,
+		Format: format,
+		Path:   path,
+		Size:   size,
+		SHA256: sum,
+	})
+	return nil
+}
+
+// Finish marks the run as completed, failed when err is not nil
+func (m *RunManifest) Finish(err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.FinishedAt = _time.Now()
+	m.DurationMs = m.FinishedAt.Sub(m.StartedAt).Milliseconds()
+	m.Status = RunStatusSucceeded
+	if err != nil {
+		m.Status = RunStatusFailed
+		m.Error = err.Error()
+	}
+}
+
+// Save writes the manifest next to the run's other artifacts and returns its path
+func (m *RunManifest) Save() (string, error) {
+	if m.output.NoFiles {
+		return "", nil
+	}
+	output := m.output.withDefaults()
+
+	m.mu.Lock()
+	data, err := _encodingjson.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return "", _fmt.Errorf("failed to format run manifest: %w", err)
+	}
+
+	filename := output.ResolveFilename(ManifestStage, "json", m.StartedAt)
+	if err := writeOutputFile(string(data), filename, output.FileMode, output.DirMode); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// Artifact returns the first artifact recorded for a stage in the given format
+func (m *RunManifest) Artifact(stage string, format OutputFormat) (ArtifactRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, artifact := range m.Artifacts {
+		if artifact.Stage == stage && artifact.Format == format {
+			return artifact, true
+		}
+	}
+	return ArtifactRecord{}, false
+}
+
+// VerifyArtifact checks that an artifact on disk still matches its recorded checksum
+func VerifyArtifact(artifact ArtifactRecord) error {
+	sum, _, err := fileSHA256(artifact.Path)
+	if err != nil {
+		return _fmt.Errorf("failed to checksum artifact %s: %w", artifact.Path, err)
+	}
+	if sum != artifact.SHA256 {
+		return _fmt.Errorf("artifact %s was modified: expected sha256 %s, got %s", artifact.Path, artifact.SHA256, sum)
+	}
+	return nil
+}
+
+// LoadRunManifest reads a manifest file written by RunManifest.Save
+func LoadRunManifest(filename string) (*RunManifest, error) {
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read run manifest %s: %w", filename, err)
+	}
+
+	manifest := &RunManifest{}
+	if err := _encodingjson.Unmarshal(data, manifest); err != nil {
+		return nil, NewInputFormatError("LoadRunManifest", _fmt.Errorf("failed to parse run manifest %s: %w", filename, err))
+	}
+	return manifest, nil
+}
+
+// FindRunManifest searches an output directory for the manifest of a run ID
+func FindRunManifest(outputDir string, runID string) (*RunManifest, error) {
+	if outputDir == "" {
+		outputDir = DefaultOutputDir
+	}
+
+	var found *RunManifest
+	err := _pathfilepath.WalkDir(outputDir, func(path string, entry _os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !_strings.Contains(entry.Name(), ManifestStage) || _pathfilepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		manifest, err := LoadRunManifest(path)
+		if err != nil {
+			return nil // Not every matching file has to be a manifest
+		}
+		if manifest.RunID == runID {
+			found = manifest
+			return _pathfilepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, _fmt.Errorf("failed to search %s for run %s: %w", outputDir, runID, err)
+	}
+	if found == nil {
+		return nil, _fmt.Errorf("no manifest found for run %s in %s", runID, outputDir)
+	}
+	return found, nil
+}
+
+// LoadRunArtifact decodes the JSON artifact of a stage from the run with the given ID
+func LoadRunArtifact(outputDir string, runID string, stage string, v any) error {
+	manifest, err := FindRunManifest(outputDir, runID)
+	if err != nil {
+		return err
+	}
+
+	artifact, ok := manifest.Artifact(stage, OutputFormatJSON)
+	if !ok {
+		return _fmt.Errorf("run %s has no JSON artifact for stage %s", runID, stage)
+	}
+	if err := VerifyArtifact(artifact); err != nil {
+		return err
+	}
+
+	data, err := _os.ReadFile(artifact.Path)
+	if err != nil {
+		return _fmt.Errorf("failed to read artifact %s: %w", artifact.Path, err)
+	}
+	if err := _encodingjson.Unmarshal(data, v); err != nil {
+		return _fmt.Errorf("failed to parse artifact %s: %w", artifact.Path, err)
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 checksum and size of a file
+func fileSHA256(path string) (string, int64, error) {
+	file, err := _os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := _cryptosha256.New()
+	size, err := _io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return _encodinghex.EncodeToString(hash.Sum(nil)), size, nil
+}
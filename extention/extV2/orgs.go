@@ -0,0 +1,365 @@
+package extV2
+
+import (
+	_context "context"
+	_errors "errors"
+	_fmt "fmt"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_strconv "strconv"
+	_strings "strings"
+	_sync "sync"
+	_time "time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StageMultiOrg names the aggregated multi-org summary in output files and the run manifest
+const StageMultiOrg = "MultiOrgSummary"
+
+// ErrOrgsFailed is wrapped by the error RunOrgs returns when at least one org failed
+var ErrOrgsFailed = _errors.New("orgs failed")
+
+// DefaultMaxParallelOrgs bounds how many orgs run at once when MAX_PARALLEL_ORGS is not set
+const DefaultMaxParallelOrgs = 4
+
+// SourceOrgsFile marks values taken from an orgs inventory entry in Config.Sources
+const SourceOrgsFile ConfigSource = "orgs-file"
+
+// OrgsInventory lists the Datadog orgs a multi-org run applies the same input to
+type OrgsInventory struct {
+	Orgs []OrgConfig `yaml:"orgs"`
+}
+
+// OrgConfig is one org of the inventory with its site and a reference to its credentials
+type OrgConfig struct {
+	Name             string `yaml:"name"`
+	Site             string `yaml:"site"`
+	APIKeyEnv        string `yaml:"api_key_env"`       // Environment variable holding the API key
+	AppKeyEnv        string `yaml:"app_key_env"`       // Environment variable holding the application key
+	APIKeyFile       string `yaml:"api_key_file"`      // File holding the API key
+	AppKeyFile       string `yaml:"app_key_file"`      // File holding the application key
+	CredentialHelper string `yaml:"credential_helper"` // Helper called as "<helper> get DD_API_KEY"; DD_ORG is set to the org name
+	BaseURL          string `yaml:"base_url"`          // Optional API base URL override
+	Input            string `yaml:"input"`             // Optional input file; the shared INPUT when empty
+	MaxConcurrency   int    `yaml:"max_concurrency"`   // Optional tagging concurrency for this org
+}
+
+// OrgRunResult summarises the list, match and tag stages of one org
+type OrgRunResult struct {
+	Org            string `json:"org"`
+	Site           string `json:"site"`
+	Success        bool   `json:"success"`
+	Stage          string `json:"stage,omitempty"` // Stage that failed
+	Error          string `json:"error,omitempty"`
+	TotalRules     int    `json:"totalRules"`
+	Matches        int    `json:"matches"`
+	SuccessfulTags int    `json:"successfulTags"`
+	FailedTags     int    `json:"failedTags"`
+	SkippedRules   int    `json:"skippedRules"`
+	OutputDir      string `json:"outputDir"`
+	DurationMs     int64  `json:"durationMs"`
+}
+
+// MultiOrgResult aggregates the per-org results of a multi-org run
+type MultiOrgResult struct {
+	RunID     string         `json:"runId"`
+	DryRun    bool           `json:"dryRun"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Orgs      []OrgRunResult `json:"orgs"`
+}
+
+// LoadOrgsInventory reads and checks an orgs inventory YAML file
+func LoadOrgsInventory(filename string) (*OrgsInventory, error) {
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read orgs file %s: %w", filename, err)
+	}
+
+	inventory := &OrgsInventory{}
+	if err := yaml.Unmarshal(data, inventory); err != nil {
+		return nil, NewInputFormatError("LoadOrgsInventory", _fmt.Errorf("failed to parse orgs file %s: %w", filename, err))
+	}
+	if len(inventory.Orgs) == 0 {
+		return nil, _fmt.Errorf("orgs file %s lists no orgs", filename)
+	}
+
+	seen := make(map[string]bool)
+	for i, org := range inventory.Orgs {
+		if org.Name == "" {
+			return nil, _fmt.Errorf("orgs file %s: org #%d has no name", filename, i+1)
+		}
+		if err := checkOrgName(org.Name); err != nil {
+			return nil, _fmt.Errorf("orgs file %s: %w", filename, err)
+		}
+		if seen[org.Name] {
+			return nil, _fmt.Errorf("orgs file %s: org %q is listed twice", filename, org.Name)
+		}
+		seen[org.Name] = true
+	}
+	return inventory, nil
+}
+
+// checkOrgName rejects org names that cannot be used as a directory and file name part:
+// output, cassette and cache paths are derived from the name
+func checkOrgName(name string) error {
+	if _strings.ContainsAny(name, `/\`) || _strings.ContainsRune(name, 0) || _strings.Trim(name, ".") == "" {
+		return _fmt.Errorf("org name %q must not contain path separators or be only dots", name)
+	}
+	return nil
+}
+
+// Org returns the inventory entry with the given name
+func (inv *OrgsInventory) Org(name string) (OrgConfig, error) {
+	for _, org := range inv.Orgs {
+		if org.Name == name {
+			return org, nil
+		}
+	}
+	return OrgConfig{}, _fmt.Errorf("org %q is not in the orgs file", name)
+}
+
+// ForOrg derives the config of one org from the shared config: site, credentials, base URL, input
+// and concurrency come from the inventory entry, and output goes to a subdirectory named after the org
+func (c *Config) ForOrg(org OrgConfig) (*Config, error) {
+	if err := checkOrgName(org.Name); err != nil {
+		return nil, err
+	}
+	orgConfig := *c
+	orgConfig.OrgsFile = ""
+	orgConfig.Compare = CompareConfig{}
+	orgConfig.Output.Org = org.Name
+	orgConfig.Output.Dir = _pathfilepath.Join(c.Output.Dir, org.Name)
+	orgConfig.Output.Manifest = nil
+	orgConfig.HTTP.Cassette = nil
+
+	sources := make(map[string]ResolvedValue, len(c.Sources))
+	for key, resolved := range c.Sources {
+		sources[key] = resolved
+	}
+	orgConfig.Sources = sources
+	set := func(key string, value string) {
+		sources[key] = ResolvedValue{Value: value, Source: SourceOrgsFile}
+	}
+
+	set("DD_ORG", org.Name)
+	if org.Site != "" {
+		orgConfig.DDSite = org.Site
+		set("DD_SITE", org.Site)
+	}
+	if org.BaseURL != "" {
+		orgConfig.HTTP.BaseURL = org.BaseURL
+		set("DD_BASE_URL", org.BaseURL)
+	}
+	if c.HTTP.CassetteFile != "" {
+		// Orgs request the same paths, so each org records to and replays from its own cassette
+		extension := _pathfilepath.Ext(c.HTTP.CassetteFile)
+		orgConfig.HTTP.CassetteFile = _strings.TrimSuffix(c.HTTP.CassetteFile, extension) + "." + org.Name + extension
+		set("DD_CASSETTE_FILE", orgConfig.HTTP.CassetteFile)
+	}
+	if c.Pagination.Cache.File != "" {
+		// Each org caches its own catalogue
+		extension := _pathfilepath.Ext(c.Pagination.Cache.File)
+		orgConfig.Pagination.Cache.File = _strings.TrimSuffix(c.Pagination.Cache.File, extension) + "." + org.Name + extension
+		orgConfig.Tagging.RuleCacheFile = orgConfig.Pagination.Cache.File
+		set("RULE_CACHE_FILE", orgConfig.Pagination.Cache.File)
+	}
+	if org.Input != "" {
+		orgConfig.InputRuleFilename = org.Input
+		set("INPUT", org.Input)
+	}
+	if org.MaxConcurrency != 0 {
+		orgConfig.Tagging.MaxConcurrency = org.MaxConcurrency
+		set("MAX_CONCURRENCY", _strconv.Itoa(org.MaxConcurrency))
+	}
+	orgConfig.Tagging.Safety.Site = orgConfig.DDSite
+	orgConfig.Tagging.Safety.Org = org.Name
+
+	helper := org.CredentialHelper
+	if helper == "" {
+		helper = c.Sources["DD_CREDENTIAL_HELPER"].Value
+	}
+	files := map[string]string{"DD_API_KEY_FILE": org.APIKeyFile, "DD_APP_KEY_FILE": org.AppKeyFile}
+	sourcesForOrg := []CredentialSource{
+		FileCredentialSource{Lookup: func(key string) string { return files[key] }},
+		HelperCredentialSource{
+			Command: helper,
+			Env:     []string{"DD_SITE=" + orgConfig.DDSite, "DD_ORG=" + org.Name},
+		},
+	}
+
+	var err error
+	if orgConfig.DDAPIKey, err = resolveOrgCredential("DD_API_KEY", org.APIKeyEnv, sourcesForOrg, sources); err != nil {
+		return nil, _fmt.Errorf("org %s: %w", org.Name, err)
+	}
+	if orgConfig.DDAppKey, err = resolveOrgCredential("DD_APP_KEY", org.AppKeyEnv, sourcesForOrg, sources); err != nil {
+		return nil, _fmt.Errorf("org %s: %w", org.Name, err)
+	}
+
+	// The org config has no compare settings, so the shared config tells whether the org reads INPUT
+	if errs := orgConfig.validate(c.readsInput()); len(errs) > 0 {
+		return nil, _fmt.Errorf("org %s: %w", org.Name, errs)
+	}
+	return &orgConfig, nil
+}
+
+// resolveOrgCredential reads key from the named environment variable or the org's credential sources.
+// An org never falls back to the shared keys, so a missing reference cannot write to the wrong org.
+func resolveOrgCredential(key string, envName string, credentialSources []CredentialSource, sources map[string]ResolvedValue) (string, error) {
+	if envName != "" {
+		if value := _os.Getenv(envName); value != "" {
+			sources[key] = ResolvedValue{Value: value, Source: SourceEnv}
+			return value, nil
+		}
+	}
+
+	secret, source, err := lookupCredential(key, credentialSources)
+	if err != nil {
+		return "", err
+	}
+	sources[key] = ResolvedValue{Value: secret, Source: source}
+	return secret, nil
+}
+
+// RunOrg runs preflight, listing, matching and tagging for one org config and records them in its own manifest
+func RunOrg(ctx _context.Context, config *Config) OrgRunResult {
+	started := _time.Now()
+	result := OrgRunResult{Org: config.Output.Org, Site: config.DDSite, OutputDir: config.Output.Dir}
+
+	manifest := NewRunManifest(config)
+	err := runOrgStages(ctx, config, &result)
+	if closeErr := config.CloseCassette(); closeErr != nil {
+		LoggerFrom(ctx).Warn("Failed to save cassette", "org", result.Org, "error", closeErr)
+	}
+	manifest.Finish(err)
+	if _, saveErr := manifest.Save(); saveErr != nil {
+		LoggerFrom(ctx).Warn("Failed to save run manifest", "org", result.Org, "error", saveErr)
+	}
+
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.DurationMs = _time.Since(started).Milliseconds()
+	return result
+}
+
+// runOrgStages runs the stages of one org, noting the failing stage in result
+func runOrgStages(ctx _context.Context, config *Config, result *OrgRunResult) error {
+	ctx, api, err := NewSecurityMonitoringClient(ctx, config)
+	if err != nil {
+		result.Stage = "client"
+		return err
+	}
+
+	if !config.SkipPreflight {
+		if _, err := RunPreflight(ctx, config, api); err != nil {
+			result.Stage = StagePreflight
+			return err
+		}
+	}
+
+	listResult, err := ProcessRuleListing(ctx, api, config.Pagination, config.Output)
+	if err != nil {
+		result.Stage = StageListing
+		return err
+	}
+	result.TotalRules = listResult.TotalRules
+
+	matchResult, err := ProcessRuleMatching(ctx, config.InputRuleFilename, listResult, config.Output)
+	if err != nil {
+		result.Stage = StageMatching
+		return err
+	}
+	result.Matches = matchResult.TotalMatches
+
+	taggingResult, err := ProcessRuleTagging(ctx, api, matchResult, config.Tagging, config.Output)
+	if err != nil {
+		result.Stage = StageTagging
+		return err
+	}
+	result.SuccessfulTags = taggingResult.SuccessfulTags
+	result.FailedTags = taggingResult.FailedTags
+	result.SkippedRules = len(taggingResult.SkippedRules)
+	return nil
+}
+
+// RunOrgs applies the shared config and input to every org of the inventory, running at most
+// config.MaxParallelOrgs orgs at once. A failing org does not stop the others; the aggregated
+// summary is saved next to the per-org output directories and an error reports how many orgs failed.
+func RunOrgs(ctx _context.Context, config *Config, inventory *OrgsInventory) (_ *MultiOrgResult, err error) {
+	finishStage := config.Output.startStage(StageMultiOrg)
+	defer func() { finishStage(err) }()
+
+	// Serialise interactive confirmations so prompts of concurrent orgs do not interleave
+	shared := *config
+	if confirm := config.Tagging.Safety.Confirm; confirm != nil {
+		var confirmMu _sync.Mutex
+		shared.Tagging.Safety.Confirm = func(request WriteConfirmation) (bool, error) {
+			confirmMu.Lock()
+			defer confirmMu.Unlock()
+			return confirm(request)
+		}
+	}
+
+	parallel := config.MaxParallelOrgs
+	if parallel < 1 {
+		parallel = DefaultMaxParallelOrgs
+	}
+
+	result := &MultiOrgResult{
+		RunID:  config.Output.RunID,
+		DryRun: config.Tagging.DryRun,
+		Orgs:   make([]OrgRunResult, len(inventory.Orgs)),
+	}
+
+	semaphore := make(chan struct{}, parallel)
+	var wg _sync.WaitGroup
+	for i, org := range inventory.Orgs {
+		wg.Add(1)
+		go func(i int, org OrgConfig) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			LoggerFrom(ctx).Info("Starting org", "org", org.Name, "site", org.Site)
+			orgConfig, err := shared.ForOrg(org)
+			if err != nil {
+				site := org.Site
+				if site == "" {
+					site = shared.DDSite
+				}
+				result.Orgs[i] = OrgRunResult{Org: org.Name, Site: site, Stage: "config", Error: err.Error()}
+			} else {
+				result.Orgs[i] = RunOrg(ctx, orgConfig)
+			}
+
+			if orgResult := result.Orgs[i]; orgResult.Success {
+				LoggerFrom(ctx).Info("Finished org", "org", org.Name, "matches", orgResult.Matches, "successfulTags", orgResult.SuccessfulTags)
+			} else {
+				LoggerFrom(ctx).Error("Org failed, continuing with the others", "org", org.Name, "stage", orgResult.Stage, "error", orgResult.Error)
+			}
+		}(i, org)
+	}
+	wg.Wait()
+
+	for _, orgResult := range result.Orgs {
+		if orgResult.Success {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+
+	if _, saveErr := config.Output.SaveResult(result, StageMultiOrg); saveErr != nil {
+		LoggerFrom(ctx).Warn("Failed to save multi-org summary", "error", saveErr)
+	}
+
+	LoggerFrom(ctx).Info("Multi-org summary", "orgs", len(result.Orgs), "succeeded", result.Succeeded, "failed", result.Failed)
+
+	if result.Failed > 0 {
+		return result, _fmt.Errorf("%w: %d of %d orgs failed", ErrOrgsFailed, result.Failed, len(result.Orgs))
+	}
+	return result, nil
+}
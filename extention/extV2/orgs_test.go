@@ -0,0 +1,129 @@
+package extV2
+
+import (
+	_pathfilepath "path/filepath"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestLoadOrgsInventory(t *_testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantOrgs  []string
+		wantInErr string
+	}{
+		{name: "valid", data: "orgs:\n  - name: prod\n  - name: staging\n    site: datadoghq.eu\n", wantOrgs: []string{"prod", "staging"}},
+		{name: "no orgs", data: "orgs: []\n", wantInErr: "lists no orgs"},
+		{name: "not YAML", data: "orgs: [", wantInErr: "failed to parse"},
+		{name: "missing name", data: "orgs:\n  - site: datadoghq.com\n", wantInErr: "has no name"},
+		{name: "duplicate", data: "orgs:\n  - name: prod\n  - name: prod\n", wantInErr: "listed twice"},
+		{name: "path in name", data: "orgs:\n  - name: ../prod\n", wantInErr: "path separators"},
+		{name: "backslash in name", data: "orgs:\n  - name: 'a\\b'\n", wantInErr: "path separators"},
+		{name: "dots as name", data: "orgs:\n  - name: '..'\n", wantInErr: "only dots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			filename := _pathfilepath.Join(t.TempDir(), "orgs.yaml")
+			writeFile(t, filename, tt.data)
+
+			inventory, err := LoadOrgsInventory(filename)
+			if tt.wantInErr != "" {
+				if err == nil || !_strings.Contains(err.Error(), tt.wantInErr) {
+					t.Fatalf("LoadOrgsInventory() error = %v, want one containing %q", err, tt.wantInErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadOrgsInventory() error = %v", err)
+			}
+			if len(inventory.Orgs) != len(tt.wantOrgs) {
+				t.Fatalf("got %d orgs, want %v", len(inventory.Orgs), tt.wantOrgs)
+			}
+			for i, name := range tt.wantOrgs {
+				if _, err := inventory.Org(name); err != nil || inventory.Orgs[i].Name != name {
+					t.Errorf("org %d is %q, want %q", i, inventory.Orgs[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestForOrg(t *_testing.T) {
+	dir := t.TempDir()
+	input := _pathfilepath.Join(dir, "input.json")
+	writeFile(t, input, "[]")
+	t.Setenv("ORG_API_KEY", "org-api-key")
+	t.Setenv("ORG_APP_KEY", "org-app-key")
+
+	shared := &Config{
+		DDSite:            "datadoghq.com",
+		DDAPIKey:          "shared-api-key",
+		DDAppKey:          "shared-app-key",
+		InputRuleFilename: input,
+		Pagination:        PaginationConfig{PageSize: 100, Cache: RuleCacheConfig{File: _pathfilepath.Join(dir, "cache.json")}},
+		Tagging:           TaggingConfig{MaxConcurrency: 5},
+		Output:            OutputConfig{Dir: "out"},
+		HTTP:              HTTPConfig{CassetteMode: CassetteRecord, CassetteFile: "cassette.json"},
+		MaxParallelOrgs:   DefaultMaxParallelOrgs,
+		Sources:           map[string]ResolvedValue{"MAX_CONCURRENCY": {Value: "5", Source: SourceEnv}},
+	}
+	withKeys := func(org OrgConfig) OrgConfig {
+		org.APIKeyEnv, org.AppKeyEnv = "ORG_API_KEY", "ORG_APP_KEY"
+		return org
+	}
+
+	tests := []struct {
+		name            string
+		org             OrgConfig
+		wantSite        string
+		wantConcurrency int
+		wantSource      ConfigSource // Source of MAX_CONCURRENCY
+		wantInErr       string
+	}{
+		{name: "shared settings", org: withKeys(OrgConfig{Name: "prod"}), wantSite: "datadoghq.com", wantConcurrency: 5, wantSource: SourceEnv},
+		{name: "org overrides", org: withKeys(OrgConfig{Name: "eu", Site: "datadoghq.eu", MaxConcurrency: 2}), wantSite: "datadoghq.eu", wantConcurrency: 2, wantSource: SourceOrgsFile},
+		{name: "invalid org concurrency names its source", org: withKeys(OrgConfig{Name: "eu", MaxConcurrency: 99}), wantInErr: `MAX_CONCURRENCY="99" (from orgs-file)`},
+		{name: "no fallback to the shared keys", org: OrgConfig{Name: "prod"}, wantInErr: "DD_API_KEY"},
+		{name: "unsafe name", org: withKeys(OrgConfig{Name: "../prod"}), wantInErr: "path separators"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			orgConfig, err := shared.ForOrg(tt.org)
+			if tt.wantInErr != "" {
+				if err == nil || !_strings.Contains(err.Error(), tt.wantInErr) {
+					t.Fatalf("ForOrg() error = %v, want one containing %q", err, tt.wantInErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ForOrg() error = %v", err)
+			}
+
+			if orgConfig.DDSite != tt.wantSite || orgConfig.Tagging.Safety.Site != tt.wantSite {
+				t.Errorf("site = %q, safety site = %q; want %q", orgConfig.DDSite, orgConfig.Tagging.Safety.Site, tt.wantSite)
+			}
+			if orgConfig.Tagging.MaxConcurrency != tt.wantConcurrency || orgConfig.Sources["MAX_CONCURRENCY"].Source != tt.wantSource {
+				t.Errorf("MAX_CONCURRENCY = %d from %q, want %d from %q",
+					orgConfig.Tagging.MaxConcurrency, orgConfig.Sources["MAX_CONCURRENCY"].Source, tt.wantConcurrency, tt.wantSource)
+			}
+			if orgConfig.DDAPIKey != "org-api-key" || orgConfig.DDAppKey != "org-app-key" {
+				t.Errorf("keys = %q, %q; want the org's keys", orgConfig.DDAPIKey, orgConfig.DDAppKey)
+			}
+
+			name := tt.org.Name
+			if orgConfig.Output.Dir != _pathfilepath.Join("out", name) || orgConfig.Output.Org != name {
+				t.Errorf("output dir = %q, org = %q", orgConfig.Output.Dir, orgConfig.Output.Org)
+			}
+			if orgConfig.HTTP.CassetteFile != "cassette."+name+".json" || orgConfig.Pagination.Cache.File != _pathfilepath.Join(dir, "cache."+name+".json") || orgConfig.Tagging.RuleCacheFile != orgConfig.Pagination.Cache.File {
+				t.Errorf("cassette = %q, cache = %q; want per-org files", orgConfig.HTTP.CassetteFile, orgConfig.Pagination.Cache.File)
+			}
+		})
+	}
+
+	if shared.Sources["MAX_CONCURRENCY"].Source != SourceEnv || shared.Output.Dir != "out" {
+		t.Error("ForOrg() modified the shared config")
+	}
+}
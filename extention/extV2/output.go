@@ -5,22 +5,40 @@ import (
 	_fmt "fmt"
 	_os "os"
 	_pathfilepath "path/filepath"
+	_strings "strings"
 	_time "time"
 )
 
+const (
+	DefaultOutputDir        = "output"
+	DefaultFilenameTemplate = "{time}_{stage}"
+)
+
+// Stage names used for result files and run manifest entries
+const (
+	StageListing  = "ListRulesResult"
+	StageMatching = "MatchResult"
+	StageTagging  = "TaggingResult"
+)
+
 // SaveToJSONFile saves the formatted result to a JSON file
 func SaveToJSONFile(data string, filename string) error {
+	return writeOutputFile(data, filename, 0644, 0755)
+}
+
+// writeOutputFile writes data to filename, creating parent directories with the given permissions
+func writeOutputFile(data string, filename string, fileMode _os.FileMode, dirMode _os.FileMode) error {
 	// Create directory if it doesn't exist
 	dir := _pathfilepath.Dir(filename)
 	if dir != "." && dir != "" {
-		if err := _os.MkdirAll(dir, 0755); err != nil {
-			return _fmt.Errorf("failed to create directory %s: %v", dir, err)
+		if err := _os.MkdirAll(dir, dirMode); err != nil {
+			return _fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
 
 	// Write data to file using _os.WriteFile (Go 1.16+)
-	if err := _os.WriteFile(filename, []byte(data), 0644); err != nil {
-		return _fmt.Errorf("failed to write file %s: %v", filename, err)
+	if err := _os.WriteFile(filename, []byte(data), fileMode); err != nil {
+		return _fmt.Errorf("failed to write file %s: %w", filename, err)
 	}
 
 	return nil
@@ -57,7 +75,7 @@ func SaveResultToFile(
 	// Format the batch result
 	formattedResult, err := formatter(batchResult)
 	if err != nil {
-		return "", _fmt.Errorf("failed to format batch result: %v", err)
+		return "", _fmt.Errorf("failed to format batch result: %w", err)
 	}
 
 	// Save to file
@@ -67,3 +85,112 @@ func SaveResultToFile(
 
 	return filename, nil
 }
+
+// SaveResultToFiles saves a result once per requested output format and returns the written filenames
+func SaveResultToFiles(
+	batchResult any,
+	prefix string,
+	outputDir string,
+	formats []OutputFormat,
+) ([]string, error) {
+	output := OutputConfig{Dir: outputDir, Formats: formats}
+	if outputDir == "" {
+		output.Dir = "."
+	}
+	return output.SaveResult(batchResult, prefix)
+}
+
+// withDefaults fills unset output settings with the historical defaults
+func (o OutputConfig) withDefaults() OutputConfig {
+	if len(o.Formats) == 0 {
+		o.Formats = []OutputFormat{OutputFormatJSON}
+	}
+	if o.Dir == "" {
+		o.Dir = DefaultOutputDir
+	}
+	if o.FilenameTemplate == "" {
+		o.FilenameTemplate = DefaultFilenameTemplate
+	}
+	if o.FileMode == 0 {
+		o.FileMode = 0644
+	}
+	if o.DirMode == 0 {
+		o.DirMode = 0755
+	}
+	return o
+}
+
+// ResolveFilename expands the filename template for a stage and extension, including the output directory
+func (o OutputConfig) ResolveFilename(stage string, extension string, at _time.Time) string {
+	o = o.withDefaults()
+	if o.UTC {
+		at = at.UTC()
+	} else {
+		at = at.Local()
+	}
+
+	name := _strings.NewReplacer(
+		"{run_id}", filenameComponent(o.RunID),
+		"{org}", filenameComponent(o.Org),
+		"{stage}", filenameComponent(stage),
+		"{time}", at.Format("2006-01-02_15-04-05"),
+		"{date}", at.Format("2006-01-02"),
+	).Replace(o.FilenameTemplate)
+
+	// Empty placeholders must not leave doubled or dangling separators behind
+	for _strings.Contains(name, "__") {
+		name = _strings.ReplaceAll(name, "__", "_")
+	}
+	name = _strings.Trim(name, "_-.")
+	if name == "" {
+		name = stage
+	}
+
+	return _pathfilepath.Join(o.Dir, name+"."+extension)
+}
+
+// filenameComponent makes a placeholder value safe inside a filename: path separators become "_",
+// and a value of only dots, which would name the current or parent directory, becomes "_" too
+func filenameComponent(value string) string {
+	value = _strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(value)
+	if value != "" && _strings.Trim(value, ".") == "" {
+		return "_"
+	}
+	return value
+}
+
+// SaveResult writes a stage result in every configured format and returns the written filenames
+func (o OutputConfig) SaveResult(result any, stage string) ([]string, error) {
+	if o.NoFiles {
+		return nil, nil
+	}
+	o = o.withDefaults()
+
+	// Use one timestamp so every format of the same result shares a basename
+	now := _time.Now()
+
+	var filenames []string
+	for _, format := range o.Formats {
+		formatter, err := GetFormatter(format)
+		if err != nil {
+			return filenames, err
+		}
+
+		formattedResult, err := formatter.Format(result)
+		if err != nil {
+			return filenames, _fmt.Errorf("failed to format result as %s: %w", format, err)
+		}
+
+		filename := o.ResolveFilename(stage, formatter.Extension, now)
+		if err := writeOutputFile(formattedResult, filename, o.FileMode, o.DirMode); err != nil {
+			return filenames, err
+		}
+		filenames = append(filenames, filename)
+
+		if err := o.Manifest.RecordArtifact(stage, format, filename); err != nil {
+			return filenames, err
+		}
+	}
+
+	return filenames, nil
+}
@@ -0,0 +1,119 @@
+package extV2
+
+import (
+	_os "os"
+	_pathfilepath "path/filepath"
+	_testing "testing"
+	_time "time"
+)
+
+func TestResolveFilename(t *_testing.T) {
+	at := _time.Date(2024, 3, 9, 14, 5, 6, 0, _time.UTC)
+
+	tests := []struct {
+		name   string
+		output OutputConfig
+		stage  string
+		want   string
+	}{
+		{
+			name:   "defaults",
+			output: OutputConfig{UTC: true},
+			stage:  StageListing,
+			want:   _pathfilepath.Join(DefaultOutputDir, "2024-03-09_14-05-06_ListRulesResult.json"),
+		},
+		{
+			name:   "every placeholder",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{date}-{org}-{run_id}-{stage}", UTC: true, RunID: "run1", Org: "prod"},
+			stage:  StageTagging,
+			want:   _pathfilepath.Join("out", "2024-03-09-prod-run1-TaggingResult.json"),
+		},
+		{
+			name:   "empty placeholders leave no doubled separators",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{org}_{run_id}_{stage}"},
+			stage:  StageMatching,
+			want:   _pathfilepath.Join("out", "MatchResult.json"),
+		},
+		{
+			name:   "empty basename falls back to the stage",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{org}"},
+			stage:  StageMatching,
+			want:   _pathfilepath.Join("out", "MatchResult.json"),
+		},
+		{
+			name:   "path separators in the org are replaced",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{org}_{stage}", Org: "../../etc/passwd"},
+			stage:  StageListing,
+			want:   _pathfilepath.Join("out", "etc_passwd_ListRulesResult.json"),
+		},
+		{
+			name:   "backslashes in the run ID are replaced",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{run_id}_{stage}", RunID: `..\..\x`},
+			stage:  StageListing,
+			want:   _pathfilepath.Join("out", "x_ListRulesResult.json"),
+		},
+		{
+			name:   "a dot-only org cannot name the parent directory",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{org}/{stage}", Org: ".."},
+			stage:  StageListing,
+			want:   _pathfilepath.Join("out", "ListRulesResult.json"),
+		},
+		{
+			name:   "a stage with separators stays in the directory",
+			output: OutputConfig{Dir: "out", FilenameTemplate: "{stage}"},
+			stage:  "../escape",
+			want:   _pathfilepath.Join("out", "escape.json"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			got := tt.output.ResolveFilename(tt.stage, "json", at)
+			if got != tt.want {
+				t.Errorf("ResolveFilename() = %q, want %q", got, tt.want)
+			}
+			dir := tt.output.withDefaults().Dir
+			if rel, err := _pathfilepath.Rel(dir, got); err != nil || rel != _pathfilepath.Base(got) {
+				t.Errorf("ResolveFilename() = %q is not directly inside %q", got, dir)
+			}
+		})
+	}
+}
+
+func TestSaveResult(t *_testing.T) {
+	result := &PaginatedResult{TotalRules: 1, Rules: []SimplifiedRule{{ID: "abc-123", Name: "Rule A"}}}
+
+	tests := []struct {
+		name      string
+		output    OutputConfig
+		wantFiles int
+		wantMode  _os.FileMode
+	}{
+		{name: "no files", output: OutputConfig{NoFiles: true, Formats: []OutputFormat{OutputFormatJSON}}},
+		{name: "default format and mode", output: OutputConfig{}, wantFiles: 1, wantMode: 0644},
+		{name: "every format", output: OutputConfig{Formats: []OutputFormat{OutputFormatJSON, OutputFormatCSV, OutputFormatMarkdown, OutputFormatHTML}}, wantFiles: 4, wantMode: 0644},
+		{name: "private mode", output: OutputConfig{FileMode: 0600, DirMode: 0700}, wantFiles: 1, wantMode: 0600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			tt.output.Dir = _pathfilepath.Join(t.TempDir(), "results")
+			filenames, err := tt.output.SaveResult(result, StageListing)
+			if err != nil {
+				t.Fatalf("SaveResult() error = %v", err)
+			}
+			if len(filenames) != tt.wantFiles {
+				t.Fatalf("SaveResult() wrote %v, want %d files", filenames, tt.wantFiles)
+			}
+			for _, filename := range filenames {
+				info, err := _os.Stat(filename)
+				if err != nil {
+					t.Fatalf("stat %s: %v", filename, err)
+				}
+				if info.Mode().Perm() != tt.wantMode {
+					t.Errorf("%s has mode %v, want %v", filename, info.Mode().Perm(), tt.wantMode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package extV2
+
+import (
+	_context "context"
+	_errors "errors"
+	_fmt "fmt"
+	_nethttp "net/http"
+	_strings "strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// StagePreflight names the preflight stage in output files and the run manifest
+const StagePreflight = "PreflightResult"
+
+// Scopes the application key needs
+const (
+	ScopeRulesRead  = "security_monitoring_rules_read"
+	ScopeRulesWrite = "security_monitoring_rules_write"
+)
+
+// preflightProbeRuleID names a rule that does not exist; updating it is refused before anything can change
+const preflightProbeRuleID = "dd-security-rule-extension-preflight-probe"
+
+// preflightProbeTag is the only change the write probe asks for. The payload is valid so that body
+// validation cannot answer before the permission check does.
+const preflightProbeTag = "dd-security-rule-extension:preflight-probe"
+
+// ErrPreflightFailed is wrapped by the error RunPreflight returns when a check fails
+var ErrPreflightFailed = _errors.New("preflight check failed")
+
+// PreflightStatus is the outcome of one preflight check
+type PreflightStatus string
+
+const (
+	PreflightPassed  PreflightStatus = "passed"
+	PreflightFailed  PreflightStatus = "failed"
+	PreflightSkipped PreflightStatus = "skipped"
+	// PreflightUnverified means the probe got an answer that neither grants nor refuses the scope
+	PreflightUnverified PreflightStatus = "unverified"
+)
+
+// PreflightCheck is the result of one preflight check
+type PreflightCheck struct {
+	Name       string          `json:"name"`
+	Status     PreflightStatus `json:"status"`
+	Scope      string          `json:"scope,omitempty"`      // Scope or key the check exercises
+	StatusCode int             `json:"statusCode,omitempty"` // HTTP status of the probe request
+	Message    string          `json:"message,omitempty"`
+	Remedy     string          `json:"remedy,omitempty"` // What to change when the check failed
+}
+
+// PreflightResult holds every preflight check of a run
+type PreflightResult struct {
+	Site   string           `json:"site"`
+	DryRun bool             `json:"dryRun"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Failed returns the checks that did not pass
+func (r *PreflightResult) Failed() []PreflightCheck {
+	var failed []PreflightCheck
+	for _, check := range r.Checks {
+		if check.Status == PreflightFailed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// RunPreflight validates the API key, confirms the application key can read rules and, unless the
+// tagging config is a dry run, probes for write permission by updating a rule that does not exist.
+// A 404 for that rule passes the write probe, and so does a success, which is reported because it changed
+// a rule; other answers leave it unverified without failing the run.
+// It returns an error wrapping ErrPreflightFailed with an actionable message for every failed check.
+func RunPreflight(ctx _context.Context, config *Config, api RuleStore) (_ *PreflightResult, err error) {
+	finishStage := config.Output.startStage(StagePreflight)
+	defer func() { finishStage(err) }()
+
+	result := &PreflightResult{Site: config.DDSite, DryRun: config.Tagging.DryRun}
+
+	// Probes call the client directly: their failures are reported as checks, and the write
+	// probe's expected 404 must not be logged or retried by the middleware chain
+
+	configuration, err := config.NewDatadogConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	_, r, err := datadogV1.NewAuthenticationApi(datadog.NewAPIClient(configuration)).Validate(ctx)
+	apiKeyCheck := preflightCheck("API key", "DD_API_KEY", r, err)
+	if apiKeyCheck.Status == PreflightFailed && apiKeyCheck.StatusCode == _nethttp.StatusForbidden {
+		apiKeyCheck.Message = _fmt.Sprintf("DD_API_KEY was rejected by %s", config.DDSite)
+		apiKeyCheck.Remedy = "check DD_API_KEY and that DD_SITE (or DD_BASE_URL) points at the site of its org"
+	}
+	result.Checks = append(result.Checks, apiKeyCheck)
+
+	_, r, err = api.ListSecurityMonitoringRules(ctx, *datadogV2.NewListSecurityMonitoringRulesOptionalParameters().WithPageSize(1))
+	readCheck := preflightCheck("Read rules", ScopeRulesRead, r, err)
+	if readCheck.Status == PreflightFailed && isAuthStatus(readCheck.StatusCode) {
+		readCheck.Message = "DD_APP_KEY cannot read security monitoring rules"
+		readCheck.Remedy = _fmt.Sprintf("grant the %s scope to the application key, or check that DD_APP_KEY belongs to the same org as DD_API_KEY", ScopeRulesRead)
+	}
+	result.Checks = append(result.Checks, readCheck)
+
+	if config.Tagging.DryRun {
+		result.Checks = append(result.Checks, PreflightCheck{
+			Name:    "Write rules",
+			Status:  PreflightSkipped,
+			Scope:   ScopeRulesWrite,
+			Message: "dry run, no rule will be updated",
+		})
+	} else {
+		payload := datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{preflightProbeTag}}
+		_, r, err = api.UpdateSecurityMonitoringRule(ctx, preflightProbeRuleID, payload)
+		writeCheck := preflightCheck("Write rules", ScopeRulesWrite, r, err)
+		switch {
+		case r != nil && r.StatusCode == _nethttp.StatusNotFound:
+			// The probe rule does not exist: the request got past the permission check
+			writeCheck.Status = PreflightPassed
+			writeCheck.Message = ""
+		case writeCheck.Status == PreflightFailed && isAuthStatus(writeCheck.StatusCode):
+			writeCheck.Message = "DD_APP_KEY cannot update security monitoring rules"
+			writeCheck.Remedy = _fmt.Sprintf("grant the %s scope to the application key, or run with DRYRUN=true", ScopeRulesWrite)
+		case err == nil && r != nil:
+			// A rule with the probe ID exists, so the probe tagged it: the scope is granted, but say what changed
+			writeCheck.Status = PreflightPassed
+			writeCheck.Message = _fmt.Sprintf("write probe updated an existing rule %s and set its tags to %s", preflightProbeRuleID, preflightProbeTag)
+			writeCheck.Remedy = _fmt.Sprintf("restore the tags of rule %s", preflightProbeRuleID)
+			LoggerFrom(ctx).Warn("Preflight write probe updated a rule", "ruleId", preflightProbeRuleID, "tag", preflightProbeTag, "status", r.StatusCode)
+		case r != nil:
+			// Any other answer, 400 included, says nothing about the scope
+			writeCheck.Status = PreflightUnverified
+			writeCheck.Message = _fmt.Sprintf("write probe got %s instead of 404 Not Found; %s could not be verified", r.Status, ScopeRulesWrite)
+			writeCheck.Remedy = ""
+		}
+		result.Checks = append(result.Checks, writeCheck)
+	}
+
+	for _, check := range result.Checks {
+		switch check.Status {
+		case PreflightFailed:
+			LoggerFrom(ctx).Error("Preflight check failed", "check", check.Name, "scope", check.Scope, "status", check.StatusCode, "message", check.Message, "remedy", check.Remedy)
+		case PreflightSkipped:
+			LoggerFrom(ctx).Info("Preflight check skipped", "check", check.Name, "reason", check.Message)
+		case PreflightUnverified:
+			LoggerFrom(ctx).Warn("Preflight check unverified", "check", check.Name, "scope", check.Scope, "status", check.StatusCode, "message", check.Message)
+		default:
+			LoggerFrom(ctx).Info("Preflight check passed", "check", check.Name)
+		}
+	}
+
+	if _, saveErr := config.Output.SaveResult(result, StagePreflight); saveErr != nil {
+		LoggerFrom(ctx).Warn("Failed to save preflight result", "error", saveErr)
+	}
+
+	if failed := result.Failed(); len(failed) > 0 {
+		messages := make([]string, len(failed))
+		for i, check := range failed {
+			messages[i] = _fmt.Sprintf("%s: %s", check.Name, check.Message)
+			if check.Remedy != "" {
+				messages[i] += " (" + check.Remedy + ")"
+			}
+		}
+		return result, _fmt.Errorf("%w:\n  - %s", ErrPreflightFailed, _strings.Join(messages, "\n  - "))
+	}
+	return result, nil
+}
+
+// preflightCheck turns a probe response into a check with a generic message
+func preflightCheck(name string, scope string, r *_nethttp.Response, err error) PreflightCheck {
+	check := PreflightCheck{Name: name, Status: PreflightPassed, Scope: scope}
+	if r != nil {
+		check.StatusCode = r.StatusCode
+	}
+	if err == nil {
+		return check
+	}
+
+	check.Status = PreflightFailed
+	switch {
+	case r == nil:
+		check.Message = _fmt.Sprintf("request failed: %v", err)
+		check.Remedy = "check network access, DD_PROXY_URL and DD_CA_BUNDLE"
+	case r.StatusCode == _nethttp.StatusTooManyRequests:
+		check.Message = "rate limited"
+		check.Remedy = "retry later"
+	default:
+		check.Message = _fmt.Sprintf("unexpected response %s", r.Status)
+	}
+	return check
+}
+
+// isAuthStatus reports whether status means the credentials were refused
+func isAuthStatus(status int) bool {
+	return status == _nethttp.StatusUnauthorized || status == _nethttp.StatusForbidden
+}
@@ -0,0 +1,240 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_fmt "fmt"
+	_iofs "io/fs"
+	_os "os"
+	_time "time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// RuleCacheFormatVersion is the layout version of rule cache files; other versions are ignored
+const RuleCacheFormatVersion = 1
+
+// RuleCacheConfig controls the on-disk rule cache used by ProcessRuleListing
+type RuleCacheConfig struct {
+	File    string         // Cache file; empty disables the cache
+	TTL     _time.Duration // Within this age of the last complete listing, listing is skipped; 0 always refreshes
+	Refresh bool           // If true, list even when the cache is fresh
+}
+
+// RuleCacheStats reports how a listing used the rule cache
+type RuleCacheStats struct {
+	File           string     `json:"file"`
+	Hits           int        `json:"hits"`    // Listed rules whose version and update time matched the cache
+	Misses         int        `json:"misses"`  // Listed rules that were new or changed and were stored again
+	Evicted        int        `json:"evicted"` // Cached rules no longer listed
+	ListingSkipped bool       `json:"listingSkipped"`
+	FetchedAt      _time.Time `json:"fetchedAt"` // When the served rules were listed
+}
+
+// CachedRule is a full rule record as returned by the API, with the fields used to detect changes
+type CachedRule struct {
+	Version   int64                    `json:"version"`
+	UpdatedAt int64                    `json:"updatedAt"`
+	Rule      SimplifiedRule           `json:"rule"`
+	Record    _encodingjson.RawMessage `json:"record"`
+}
+
+// RuleCache holds the rules of the last listing keyed by ID, in list order
+type RuleCache struct {
+	FormatVersion int                   `json:"formatVersion"`
+	Org           string                `json:"org,omitempty"`
+	FetchedAt     _time.Time            `json:"fetchedAt"`
+	Complete      bool                  `json:"complete"` // The listing reached the last page
+	Order         []string              `json:"order"`
+	Rules         map[string]CachedRule `json:"rules"`
+
+	filename string
+	seen     []string
+	stats    RuleCacheStats
+}
+
+// NewRuleCache returns an empty cache saved to filename
+func NewRuleCache(filename string, org string) *RuleCache {
+	return &RuleCache{
+		FormatVersion: RuleCacheFormatVersion,
+		Org:           org,
+		Order:         []string{},
+		Rules:         make(map[string]CachedRule),
+		filename:      filename,
+		stats:         RuleCacheStats{File: filename},
+	}
+}
+
+// LoadRuleCache reads a rule cache; a missing file, another format version or another org gives an empty cache
+func LoadRuleCache(ctx _context.Context, filename string, org string) (*RuleCache, error) {
+	data, err := _os.ReadFile(filename)
+	if _errors.Is(err, _iofs.ErrNotExist) {
+		return NewRuleCache(filename, org), nil
+	}
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read rule cache %s: %w", filename, err)
+	}
+
+	cache := NewRuleCache(filename, org)
+	if err := _encodingjson.Unmarshal(data, cache); err != nil {
+		return nil, NewInputFormatError("LoadRuleCache", _fmt.Errorf("failed to parse rule cache %s: %w", filename, err))
+	}
+	if cache.FormatVersion != RuleCacheFormatVersion || cache.Org != org {
+		LoggerFrom(ctx).Warn("Ignoring rule cache of another format or org", "file", filename, "formatVersion", cache.FormatVersion, "org", cache.Org)
+		return NewRuleCache(filename, org), nil
+	}
+	if cache.Rules == nil {
+		cache.Rules = make(map[string]CachedRule)
+	}
+	cache.filename = filename
+	cache.stats = RuleCacheStats{File: filename}
+	return cache, nil
+}
+
+// Fresh reports whether the last complete listing is younger than ttl
+func (c *RuleCache) Fresh(ttl _time.Duration, now _time.Time) bool {
+	return ttl > 0 && c.Complete && len(c.Order) > 0 && now.Sub(c.FetchedAt) < ttl
+}
+
+// SimplifiedRules returns the cached rules in list order
+func (c *RuleCache) SimplifiedRules() []SimplifiedRule {
+	rules := make([]SimplifiedRule, 0, len(c.Order))
+	for _, id := range c.Order {
+		if cached, ok := c.Rules[id]; ok {
+			rules = append(rules, cached.Rule)
+		}
+	}
+	return rules
+}
+
+// Stats returns the hit and miss counts of the current listing
+func (c *RuleCache) Stats() RuleCacheStats {
+	return c.stats
+}
+
+// ruleRevision returns the ID, version and update time of a decoded rule. The client's signal
+// rule model has no updatedAt field, so it is read from the additional properties.
+func ruleRevision(ruleData datadogV2.SecurityMonitoringRuleResponse) (string, int64, int64, bool) {
+	if standard := ruleData.SecurityMonitoringStandardRuleResponse; standard != nil {
+		return standard.GetId(), standard.GetVersion(), standard.GetUpdatedAt(), true
+	}
+	if signal := ruleData.SecurityMonitoringSignalRuleResponse; signal != nil {
+		var updatedAt int64
+		if value, ok := signal.AdditionalProperties["updatedAt"].(float64); ok {
+			updatedAt = int64(value)
+		}
+		return signal.GetId(), signal.GetVersion(), updatedAt, true
+	}
+	return "", 0, 0, false
+}
+
+// lookup returns the cached rule when its version and update time are unchanged; otherwise it
+// simplifies the rule and stores the full record. Rules the client could not decode are always stored again.
+func (c *RuleCache) lookup(ruleData datadogV2.SecurityMonitoringRuleResponse) (*SimplifiedRule, error) {
+	id, version, updatedAt, ok := ruleRevision(ruleData)
+	if ok {
+		if cached, found := c.Rules[id]; found && cached.Version == version && cached.UpdatedAt == updatedAt {
+			c.stats.Hits++
+			c.seen = append(c.seen, id)
+			rule := cached.Rule
+			return &rule, nil
+		}
+	}
+
+	rule, err := simplifyRuleResponse(ruleData)
+	if err != nil {
+		return nil, err
+	}
+	record, err := datadog.Marshal(ruleData)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to encode rule %s for the cache: %w", rule.ID, err)
+	}
+	if !ok {
+		id, version = rule.ID, rule.Version
+	}
+
+	c.stats.Misses++
+	c.Rules[id] = CachedRule{Version: version, UpdatedAt: updatedAt, Rule: *rule, Record: record}
+	c.seen = append(c.seen, id)
+	return rule, nil
+}
+
+// finish records the order of the listed rules. After a complete listing, rules no longer listed are evicted;
+// a partial listing (MAX_PAGES) keeps them but leaves the cache stale.
+func (c *RuleCache) finish(complete bool, fetchedAt _time.Time) {
+	listed := make(map[string]bool, len(c.seen))
+	for _, id := range c.seen {
+		listed[id] = true
+	}
+	order := append([]string{}, c.seen...)
+	for _, id := range c.Order {
+		if listed[id] {
+			continue
+		}
+		if complete {
+			delete(c.Rules, id)
+			c.stats.Evicted++
+		} else {
+			order = append(order, id)
+		}
+	}
+
+	c.Order = order
+	c.Complete = complete
+	c.FetchedAt = fetchedAt
+	c.stats.FetchedAt = fetchedAt
+	c.seen = nil
+}
+
+// Save writes the cache to its file
+func (c *RuleCache) Save(fileMode _os.FileMode, dirMode _os.FileMode) error {
+	data, err := _encodingjson.Marshal(c)
+	if err != nil {
+		return _fmt.Errorf("failed to marshal rule cache: %w", err)
+	}
+	if err := writeOutputFile(string(data), c.filename, fileMode, dirMode); err != nil {
+		return _fmt.Errorf("failed to write rule cache %s: %w", c.filename, err)
+	}
+	return nil
+}
+
+// InvalidateRuleCache drops the cached records of ruleIDs and marks the cache incomplete, so the next
+// listing is not skipped and fetches those rules again. A missing or empty cache is left alone.
+func InvalidateRuleCache(ctx _context.Context, filename string, org string, ruleIDs []string, output OutputConfig) error {
+	cache, err := LoadRuleCache(ctx, filename, org)
+	if err != nil {
+		return err
+	}
+	if len(cache.Order) == 0 {
+		return nil
+	}
+	for _, id := range ruleIDs {
+		delete(cache.Rules, id)
+	}
+	cache.Complete = false
+	output = output.withDefaults()
+	return cache.Save(output.FileMode, output.DirMode)
+}
+
+// cachedListingResult serves a listing from a fresh cache without calling the API
+func cachedListingResult(cache *RuleCache, config PaginationConfig) *PaginatedResult {
+	result := &PaginatedResult{Rules: make([]SimplifiedRule, 0)}
+	for _, rule := range cache.SimplifiedRules() {
+		if matchesTagFilters(rule.Tags, config.TagFilters) {
+			result.Rules = append(result.Rules, rule)
+		}
+	}
+	result.TotalRules = len(cache.Order)
+	if len(config.TagFilters) > 0 {
+		result.TotalRules = len(result.Rules)
+	}
+
+	cache.stats.Hits = len(cache.Order)
+	cache.stats.ListingSkipped = true
+	cache.stats.FetchedAt = cache.FetchedAt
+	stats := cache.Stats()
+	result.Cache = &stats
+	return result
+}
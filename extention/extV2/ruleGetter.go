@@ -2,25 +2,28 @@ package extV2
 
 import (
 	_context "context"
+	_sha256 "crypto/sha256"
+	_hex "encoding/hex"
 	_encodingjson "encoding/json"
 	_fmt "fmt"
 	_nethttp "net/http"
 	_strings "strings"
+	_time "time"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
 )
 
-// extractSimplifiedRule extracts only id, isDefault, and name from a rule object
+// extractSimplifiedRule extracts id, isDefault, name and the fields snapshot diffs compare from a rule object
 func extractSimplifiedRule(ruleData interface{}) (*SimplifiedRule, error) {
 	// Convert to JSON and back to map for easier field extraction
 	jsonBytes, err := _encodingjson.Marshal(ruleData)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to marshal rule data: %v", err)
+		return nil, _fmt.Errorf("failed to marshal rule data: %w", err)
 	}
 
 	var ruleMap map[string]interface{}
 	if err := _encodingjson.Unmarshal(jsonBytes, &ruleMap); err != nil {
-		return nil, _fmt.Errorf("failed to unmarshal rule data: %v", err)
+		return nil, _fmt.Errorf("failed to unmarshal rule data: %w", err)
 	}
 
 	rule := &SimplifiedRule{}
@@ -40,19 +43,91 @@ func extractSimplifiedRule(ruleData interface{}) (*SimplifiedRule, error) {
 		rule.Name = name
 	}
 
+	// Extract the fields snapshot diffs compare
+	if isEnabled, ok := ruleMap["isEnabled"].(bool); ok {
+		rule.IsEnabled = &isEnabled
+	}
+	if version, ok := ruleMap["version"].(float64); ok {
+		rule.Version = int64(version)
+	}
+	if queries, ok := ruleMap["queries"]; ok {
+		rule.QueriesHash = contentDigest(queries)
+	}
+	if cases, ok := ruleMap["cases"]; ok {
+		rule.CasesHash = contentDigest(cases)
+	}
+
+	return rule, nil
+}
+
+// simplifyRuleResponse reads id, isDefault, name and tags from a standard or signal rule,
+// falling back to the raw JSON when the client could not decode the rule
+func simplifyRuleResponse(ruleData datadogV2.SecurityMonitoringRuleResponse) (*SimplifiedRule, error) {
+	if standard := ruleData.SecurityMonitoringStandardRuleResponse; standard != nil {
+		return &SimplifiedRule{
+			ID:          standard.GetId(),
+			IsDefault:   standard.GetIsDefault(),
+			Name:        standard.GetName(),
+			Tags:        standard.GetTags(),
+			IsEnabled:   standard.IsEnabled,
+			Version:     standard.GetVersion(),
+			QueriesHash: contentDigest(standard.Queries),
+			CasesHash:   contentDigest(standard.Cases),
+		}, nil
+	}
+	if signal := ruleData.SecurityMonitoringSignalRuleResponse; signal != nil {
+		return &SimplifiedRule{
+			ID:          signal.GetId(),
+			IsDefault:   signal.GetIsDefault(),
+			Name:        signal.GetName(),
+			Tags:        signal.GetTags(),
+			IsEnabled:   signal.IsEnabled,
+			Version:     signal.GetVersion(),
+			QueriesHash: contentDigest(signal.Queries),
+			CasesHash:   contentDigest(signal.Cases),
+		}, nil
+	}
+
+	if ruleData.UnparsedObject == nil {
+		return nil, _fmt.Errorf("empty rule in response")
+	}
+	rule, err := extractSimplifiedRule(ruleData.UnparsedObject)
+	if err != nil {
+		return nil, err
+	}
+	if rule.ID == "" {
+		return nil, _fmt.Errorf("rule without id in response")
+	}
+	if rule.Tags, err = extractTagsFromRule(ruleData.UnparsedObject); err != nil {
+		return nil, err
+	}
 	return rule, nil
 }
 
+// contentDigest returns a short SHA-256 digest of a value's JSON, or "" for nil, so snapshots
+// can tell that queries or cases changed without storing them
+func contentDigest(value any) string {
+	if value == nil {
+		return ""
+	}
+	data, err := _encodingjson.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	sum := _sha256.Sum256(data)
+	return _hex.EncodeToString(sum[:8])
+}
+
 // extractTagsFromRule extracts tags from a rule object
 func extractTagsFromRule(ruleData interface{}) ([]string, error) {
 	jsonBytes, err := _encodingjson.Marshal(ruleData)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to marshal rule data: %v", err)
+		return nil, _fmt.Errorf("failed to marshal rule data: %w", err)
 	}
 
 	var ruleMap map[string]interface{}
 	if err := _encodingjson.Unmarshal(jsonBytes, &ruleMap); err != nil {
-		return nil, _fmt.Errorf("failed to unmarshal rule data: %v", err)
+		return nil, _fmt.Errorf("failed to unmarshal rule data: %w", err)
 	}
 
 	var tags []string
@@ -92,28 +167,62 @@ func matchesTagFilters(ruleTags []string, tagFilters []string) bool {
 func FormatSimplifiedResult(result *PaginatedResult) (string, error) {
 	jsonBytes, err := _encodingjson.MarshalIndent(result, "", "  ")
 	if err != nil {
-		return "", _fmt.Errorf("failed to format simplified result: %v", err)
+		return "", _fmt.Errorf("failed to format simplified result: %w", err)
 	}
 	return string(jsonBytes), nil
 }
 
 // GetExistingStandardRuleTags fetches existing tags for a security monitoring rule
-func GetExistingStandardRuleTags(ctx _context.Context, api *datadogV2.SecurityMonitoringApi, ruleID string) ([]string, error) {
-	rule, _, err := api.GetSecurityMonitoringRule(ctx, ruleID)
+func GetExistingStandardRuleTags(ctx _context.Context, api RuleStore, ruleID string) ([]string, error) {
+	apiCall := NewAPICall("SecurityMonitoringApi", api.GetSecurityMonitoringRule)
+	rule, _, err := CallAPI(ctx, apiCall, func(ctx _context.Context) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+		return api.GetSecurityMonitoringRule(ctx, ruleID)
+	})
 	if err != nil {
-		return nil, _fmt.Errorf("failed to get rule %s: %v", ruleID, err)
+		return nil, _fmt.Errorf("failed to get rule %s: %w", ruleID, err)
 	}
 
 	// Extract tags from the rule
-	if rule.SecurityMonitoringStandardRuleResponse.Tags != nil {
-		return rule.SecurityMonitoringStandardRuleResponse.Tags, nil
+	if standard := rule.SecurityMonitoringStandardRuleResponse; standard != nil {
+		if standard.Tags != nil {
+			return standard.Tags, nil
+		}
+		return []string{}, nil
+	}
+	if signal := rule.SecurityMonitoringSignalRuleResponse; signal != nil {
+		if signal.Tags != nil {
+			return signal.Tags, nil
+		}
+		return []string{}, nil
 	}
 
-	return []string{}, nil
+	return nil, _fmt.Errorf("rule %s has an unrecognised response type", ruleID)
 }
 
-// ProcessRuleListing fetches all rules with pagination
-func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringApi, config PaginationConfig) (*PaginatedResult, error) {
+// ProcessRuleListing fetches all rules with pagination. With config.Cache.File set, a fresh cache is served
+// without listing, and otherwise only new or changed rules are simplified and stored again.
+func ProcessRuleListing(ctx _context.Context, api RuleStore, config PaginationConfig, output OutputConfig) (_ *PaginatedResult, err error) {
+	finishStage := output.startStage(StageListing)
+	defer func() { finishStage(err) }()
+
+	var cache *RuleCache
+	if config.Cache.File != "" {
+		if cache, err = LoadRuleCache(ctx, config.Cache.File, output.Org); err != nil {
+			LoggerFrom(ctx).Warn("Ignoring unreadable rule cache", "error", err)
+			cache, err = NewRuleCache(config.Cache.File, output.Org), nil
+		}
+		if !config.Cache.Refresh && cache.Fresh(config.Cache.TTL, _time.Now()) {
+			result := cachedListingResult(cache, config)
+			LoggerFrom(ctx).Info("Rule cache is fresh; skipping listing", "file", cache.filename, "rules", len(cache.Order), "age", _time.Since(cache.FetchedAt).Round(_time.Second))
+			if _, err := output.SaveResult(result, StageListing); err != nil {
+				LoggerFrom(ctx).Warn("failed to save listing result", "error", err)
+			}
+			return result, nil
+		}
+	}
+	listedAt := _time.Now()
+	complete := true
+
 	result := &PaginatedResult{
 		Rules: make([]SimplifiedRule, 0),
 		// Rules: make([]interface{}, 0),
@@ -125,7 +234,7 @@ func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringA
 	totalProcessedRules := 0
 
 	for {
-		_fmt.Printf("Fetching page %d (size: %d)...\n", pageNumber+1, config.PageSize)
+		LoggerFrom(ctx).Info("Fetching page", "page", pageNumber+1, "size", config.PageSize)
 
 		// Create pagination parameters
 		params := datadogV2.NewListSecurityMonitoringRulesOptionalParameters()
@@ -134,29 +243,19 @@ func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringA
 
 		// Make API call
 		apiCall := NewAPICall("SecurityMonitoringApi", api.ListSecurityMonitoringRules)
-		resp, _, err := apiCall.CallWithErrorHandling(func() (interface{}, *_nethttp.Response, error) {
+		resp, _, err := CallAPI(ctx, apiCall, func(ctx _context.Context) (datadogV2.SecurityMonitoringListRulesResponse, *_nethttp.Response, error) {
 			return api.ListSecurityMonitoringRules(ctx, *params)
 		})
 
 		if err != nil {
-			return nil, _fmt.Errorf("failed to fetch page %d: %v", pageNumber, err)
-		}
-
-		// Parse response to extract rules
-		respBytes, err := _encodingjson.Marshal(resp)
-		if err != nil {
-			return nil, _fmt.Errorf("failed to marshal response: %v", err)
-		}
-
-		var responseData map[string]interface{}
-		if err := _encodingjson.Unmarshal(respBytes, &responseData); err != nil {
-			return nil, _fmt.Errorf("failed to unmarshal response: %v", err)
+			return nil, _fmt.Errorf("failed to fetch page %d: %w", pageNumber, err)
 		}
 
 		// Extract data array
-		if data, ok := responseData["data"].([]interface{}); ok {
+		if resp.Data != nil {
+			data := resp.Data
 			if len(data) == 0 {
-				_fmt.Println("No more data found. Stopping pagination.")
+				LoggerFrom(ctx).Info("No more data found. Stopping pagination.")
 				break
 			}
 
@@ -167,33 +266,37 @@ func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringA
 			for _, ruleData := range data {
 				totalProcessedRules++
 
-				ruleTags, err := extractTagsFromRule(ruleData)
-				if err != nil || !matchesTagFilters(ruleTags, config.TagFilters) {
-					continue
+				var simplifiedRule *SimplifiedRule
+				if cache != nil {
+					simplifiedRule, err = cache.lookup(ruleData)
+				} else {
+					simplifiedRule, err = simplifyRuleResponse(ruleData)
 				}
-
-				filteredCount++
-
-				simplifiedRule, err := extractSimplifiedRule(ruleData)
 				if err != nil {
-					_fmt.Printf("Warning: failed to extract rule data: %v\n", err)
+					LoggerFrom(ctx).Warn("failed to extract rule data", "error", err)
+					continue
+				}
+				if !matchesTagFilters(simplifiedRule.Tags, config.TagFilters) {
 					continue
 				}
+
+				filteredCount++
 				pageRules = append(pageRules, *simplifiedRule)
 			}
 
 			result.Rules = append(result.Rules, pageRules...)
 			result.TotalRules += len(data)
 			ruleCounter += filteredCount
-			_fmt.Printf("Fetched %d rules from page %d\n", len(data), pageNumber+1)
+			LoggerFrom(ctx).Info("Fetched rules", "page", pageNumber+1, "count", len(data))
+			output.emit(Event{Type: EventPageFetched, Stage: StageListing, Page: pageNumber + 1, Count: len(data)})
 
 			// Check if we got fewer rules than requested (last page)
 			if int64(len(data)) < config.PageSize {
-				_fmt.Println("Last page reached (fewer rules than page size).")
+				LoggerFrom(ctx).Info("Last page reached (fewer rules than page size).")
 				break
 			}
 		} else {
-			_fmt.Println("No data array found in response. Stopping pagination.")
+			LoggerFrom(ctx).Warn("No data array found in response. Stopping pagination.")
 			break
 		}
 
@@ -202,7 +305,8 @@ func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringA
 
 		// Check max pages limit
 		if config.MaxPages > 0 && pageNumber >= config.MaxPages {
-			_fmt.Printf("Reached maximum pages limit (%d). Stopping.\n", config.MaxPages)
+			LoggerFrom(ctx).Info("Reached maximum pages limit. Stopping.", "maxPages", config.MaxPages)
+			complete = false
 			break
 		}
 	}
@@ -210,9 +314,20 @@ func ProcessRuleListing(ctx _context.Context, api *datadogV2.SecurityMonitoringA
 		result.TotalRules = ruleCounter
 	}
 
+	if cache != nil {
+		cache.finish(complete, listedAt)
+		stats := cache.Stats()
+		result.Cache = &stats
+		LoggerFrom(ctx).Info("Rule cache updated", "file", stats.File, "hits", stats.Hits, "misses", stats.Misses, "evicted", stats.Evicted)
+		defaults := output.withDefaults()
+		if err := cache.Save(defaults.FileMode, defaults.DirMode); err != nil {
+			LoggerFrom(ctx).Warn("failed to save rule cache", "error", err)
+		}
+	}
+
 	// Save result
-	if _, err := SaveResultToFile(result, "ListRulesResult", "output", FormatSimplifiedResultAny); err != nil {
-		_fmt.Printf("Warning: failed to save listing result: %v\n", err)
+	if _, err := output.SaveResult(result, StageListing); err != nil {
+		LoggerFrom(ctx).Warn("failed to save listing result", "error", err)
 	}
 
 	return result, nil
@@ -1,6 +1,7 @@
 package extV2
 
 import (
+	_context "context"
 	_encodingjson "encoding/json"
 	_fmt "fmt"
 	_os "os"
@@ -39,7 +40,7 @@ type MatchResult struct {
 }
 
 // LoadInputJSON loads and parses the input JSON file with better error handling
-func LoadInputJSON(filename string) (*InputData, error) {
+func LoadInputJSON(ctx _context.Context, filename string) (*InputData, error) {
 	// Check if file exists
 	if _, err := _os.Stat(filename); _os.IsNotExist(err) {
 		return nil, _fmt.Errorf("file %s does not exist", filename)
@@ -48,39 +49,36 @@ func LoadInputJSON(filename string) (*InputData, error) {
 	// Read file
 	data, err := _os.ReadFile(filename)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to read file %s: %v", filename, err)
+		return nil, _fmt.Errorf("failed to read file %s: %w", filename, err)
 	}
 
 	// Check if file is empty
 	if len(data) == 0 {
-		return nil, _fmt.Errorf("file %s is empty", filename)
+		return nil, NewInputFormatError("LoadInputJSON", _fmt.Errorf("file %s is empty", filename))
 	}
 
-	// Debug: Print first 200 characters of the file
-	_fmt.Printf("First 200 chars of %s: %s\n", filename, string(data[:min(200, len(data))]))
+	LoggerFrom(ctx).Debug("Read input file", "file", filename, "bytes", len(data))
 
 	var inputData InputData
 	if err := _encodingjson.Unmarshal(data, &inputData); err != nil {
-		return nil, _fmt.Errorf("failed to parse JSON from %s: %v", filename, err)
+		return nil, NewInputFormatError("LoadInputJSON", _fmt.Errorf("failed to parse JSON from %s: %w", filename, err))
 	}
 
-	// Debug: Print parsed data summary
-	_fmt.Printf("Parsed input data: totalRules=%d, processedRules=%d, rules count=%d\n",
-		inputData.TotalRules, inputData.ProcessedRules, len(inputData.Rules))
+	LoggerFrom(ctx).Debug("Parsed input data",
+		"totalRules", inputData.TotalRules,
+		"processedRules", inputData.ProcessedRules,
+		"rules", len(inputData.Rules))
 
 	return &inputData, nil
 }
 
-// min helper function
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+// RuleMatchKey is the key rules are joined on: IDs differ between orgs, name and isDefault do not
+func RuleMatchKey(name string, isDefault bool) string {
+	return _fmt.Sprintf("%s_%t", name, isDefault)
 }
 
 // MatchRules compares input.json rules with ProcessRuleListing result
-func MatchRules(inputData *InputData, resultData *PaginatedResult) (*MatchResult, error) {
+func MatchRules(ctx _context.Context, inputData *InputData, resultData *PaginatedResult) (*MatchResult, error) {
 	matchResult := &MatchResult{
 		TotalInputRules:  len(inputData.Rules),  // input.json uses "rules"
 		TotalResultRules: len(resultData.Rules), // result uses "rules"
@@ -93,18 +91,15 @@ func MatchRules(inputData *InputData, resultData *PaginatedResult) (*MatchResult
 
 	// Index input rules by name+isDefault combination (from "results" array)
 	for _, inputRule := range inputData.Rules {
-		key := _fmt.Sprintf("%s_%t", inputRule.Name, inputRule.IsDefault)
-		inputRuleMap[key] = inputRule
+		inputRuleMap[RuleMatchKey(inputRule.Name, inputRule.IsDefault)] = inputRule
 	}
 
 	// Index result rules by name+isDefault combination (from "rules" array)
 	for _, resultRule := range resultData.Rules {
-		key := _fmt.Sprintf("%s_%t", resultRule.Name, resultRule.IsDefault)
-		resultRuleMap[key] = resultRule
+		resultRuleMap[RuleMatchKey(resultRule.Name, resultRule.IsDefault)] = resultRule
 	}
 
-	_fmt.Printf("Input rules indexed: %d\n", len(inputRuleMap))
-	_fmt.Printf("Result rules indexed: %d\n", len(resultRuleMap))
+	LoggerFrom(ctx).Debug("Rules indexed", "inputRules", len(inputRuleMap), "resultRules", len(resultRuleMap))
 
 	// Find matches
 	matchedInputKeys := make(map[string]bool)
@@ -131,28 +126,45 @@ func MatchRules(inputData *InputData, resultData *PaginatedResult) (*MatchResult
 }
 
 // ProcessRuleMatching processes the complete rule matching workflow with better error handling
-func ProcessRuleMatching(inputFilename string, resultData *PaginatedResult) (*MatchResult, error) {
+func ProcessRuleMatching(ctx _context.Context, inputFilename string, resultData *PaginatedResult, output OutputConfig) (_ *MatchResult, err error) {
+	finishStage := output.startStage(StageMatching)
+	defer func() { finishStage(err) }()
+
 	// Load input JSON
-	_fmt.Printf("Loading input file: %s\n", inputFilename)
-	inputData, err := LoadInputJSON(inputFilename)
+	LoggerFrom(ctx).Info("Loading input file", "file", inputFilename)
+	inputData, err := LoadInputJSON(ctx, inputFilename)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to load input JSON: %v", err)
+		return nil, _fmt.Errorf("failed to load input JSON: %w", err)
 	}
 
 	// Perform matching
-	_fmt.Println("Performing rule matching...")
-	matchResult, err := MatchRules(inputData, resultData)
+	LoggerFrom(ctx).Info("Performing rule matching...")
+	matchResult, err := MatchRules(ctx, inputData, resultData)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to match rules: %v", err)
+		return nil, _fmt.Errorf("failed to match rules: %w", err)
+	}
+
+	for _, matchedRule := range matchResult.MatchedRules {
+		output.emit(Event{
+			Type:     EventRuleMatched,
+			Stage:    StageMatching,
+			RuleID:   matchedRule.ID,
+			RuleName: matchedRule.Name,
+			Tags:     matchedRule.Tags,
+		})
 	}
 
 	// Save result
-	if _, err := SaveResultToFile(matchResult, "MatchResult", "output", FormatSimplifiedResultAny); err != nil {
-		return nil, _fmt.Errorf("failed to save match result: %v", err)
+	if _, err := output.SaveResult(matchResult, StageMatching); err != nil {
+		return nil, _fmt.Errorf("failed to save match result: %w", err)
 	}
 
 	// Display summary
-	_fmt.Println(FormatMatchSummary(matchResult))
+	LoggerFrom(ctx).Info("Rule matching summary",
+		"totalInputRules", matchResult.TotalInputRules,
+		"totalResultRules", matchResult.TotalResultRules,
+		"totalMatches", matchResult.TotalMatches,
+		humanKey, FormatMatchSummary(matchResult))
 
 	return matchResult, nil
 }
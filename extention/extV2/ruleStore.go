@@ -0,0 +1,253 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_fmt "fmt"
+	_nethttp "net/http"
+	_os "os"
+	_sync "sync"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// RuleStore lists, reads and updates security monitoring rules. The methods mirror
+// *datadogV2.SecurityMonitoringApi, which is the adapter for the real API.
+type RuleStore interface {
+	ListSecurityMonitoringRules(ctx _context.Context, o ...datadogV2.ListSecurityMonitoringRulesOptionalParameters) (datadogV2.SecurityMonitoringListRulesResponse, *_nethttp.Response, error)
+	GetSecurityMonitoringRule(ctx _context.Context, ruleID string) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error)
+	UpdateSecurityMonitoringRule(ctx _context.Context, ruleID string, body datadogV2.SecurityMonitoringRuleUpdatePayload) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error)
+}
+
+var (
+	_ RuleStore = (*datadogV2.SecurityMonitoringApi)(nil)
+	_ RuleStore = (*MemoryRuleStore)(nil)
+	_ RuleStore = (*SnapshotRuleStore)(nil)
+)
+
+// ErrReadOnlyStore is wrapped by the error of an update sent to a read-only rule store
+var ErrReadOnlyStore = _errors.New("rule store is read-only")
+
+// storeResponse returns a minimal HTTP response so in-process stores report statuses like the API:
+// error classification, retry policies, metrics and preflight all read the status code
+func storeResponse(status int) *_nethttp.Response {
+	return &_nethttp.Response{
+		Status:     _fmt.Sprintf("%d %s", status, _nethttp.StatusText(status)),
+		StatusCode: status,
+		Header:     _nethttp.Header{},
+	}
+}
+
+// MemoryRuleStore keeps rules in memory, in insertion order. Updates apply name, message,
+// enabled state and tags and bump the rule version. It is safe for concurrent use.
+type MemoryRuleStore struct {
+	mu    _sync.Mutex
+	rules map[string]datadogV2.SecurityMonitoringRuleResponse
+	order []string
+}
+
+// NewMemoryRuleStore returns a store holding rules; each rule needs an ID
+func NewMemoryRuleStore(rules ...datadogV2.SecurityMonitoringRuleResponse) (*MemoryRuleStore, error) {
+	store := &MemoryRuleStore{rules: make(map[string]datadogV2.SecurityMonitoringRuleResponse)}
+	for _, rule := range rules {
+		if err := store.PutRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// PutRule adds a rule or replaces the rule with the same ID
+func (s *MemoryRuleStore) PutRule(rule datadogV2.SecurityMonitoringRuleResponse) error {
+	simplified, err := simplifyRuleResponse(rule)
+	if err != nil {
+		return err
+	}
+	if simplified.ID == "" {
+		return _fmt.Errorf("rule %q has no id", simplified.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.rules[simplified.ID]; !exists {
+		s.order = append(s.order, simplified.ID)
+	}
+	s.rules[simplified.ID] = copyRuleResponse(rule)
+	return nil
+}
+
+// Len returns the number of rules in the store
+func (s *MemoryRuleStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.order)
+}
+
+// ListSecurityMonitoringRules returns one page of rules; page size defaults to 10 and page number to 0 like the API
+func (s *MemoryRuleStore) ListSecurityMonitoringRules(_ _context.Context, o ...datadogV2.ListSecurityMonitoringRulesOptionalParameters) (datadogV2.SecurityMonitoringListRulesResponse, *_nethttp.Response, error) {
+	pageSize, pageNumber := int64(10), int64(0)
+	if len(o) > 0 {
+		if o[0].PageSize != nil {
+			pageSize = *o[0].PageSize
+		}
+		if o[0].PageNumber != nil {
+			pageNumber = *o[0].PageNumber
+		}
+	}
+	if pageSize < 1 || pageNumber < 0 {
+		return datadogV2.SecurityMonitoringListRulesResponse{}, storeResponse(_nethttp.StatusBadRequest),
+			_fmt.Errorf("invalid page size %d or page number %d", pageSize, pageNumber)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := int64(len(s.order))
+	data := []datadogV2.SecurityMonitoringRuleResponse{}
+	for i := pageNumber * pageSize; i < total && i < (pageNumber+1)*pageSize; i++ {
+		data = append(data, copyRuleResponse(s.rules[s.order[i]]))
+	}
+
+	response := datadogV2.SecurityMonitoringListRulesResponse{Data: data}
+	page := datadogV2.NewResponseMetaAttributesWithDefaults()
+	page.SetPage(datadogV2.Pagination{TotalCount: &total, TotalFilteredCount: &total})
+	response.SetMeta(*page)
+	return response, storeResponse(_nethttp.StatusOK), nil
+}
+
+// GetSecurityMonitoringRule returns the rule with the ID, or a 404 error
+func (s *MemoryRuleStore) GetSecurityMonitoringRule(_ _context.Context, ruleID string) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[ruleID]
+	if !ok {
+		return datadogV2.SecurityMonitoringRuleResponse{}, storeResponse(_nethttp.StatusNotFound), _fmt.Errorf("rule %s not found", ruleID)
+	}
+	return copyRuleResponse(rule), storeResponse(_nethttp.StatusOK), nil
+}
+
+// UpdateSecurityMonitoringRule applies the set fields of body to the rule, checking body.Version when set
+func (s *MemoryRuleStore) UpdateSecurityMonitoringRule(_ _context.Context, ruleID string, body datadogV2.SecurityMonitoringRuleUpdatePayload) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[ruleID]
+	if !ok {
+		return datadogV2.SecurityMonitoringRuleResponse{}, storeResponse(_nethttp.StatusNotFound), _fmt.Errorf("rule %s not found", ruleID)
+	}
+
+	// Standard and signal rules share these fields; update whichever variant the rule is
+	type updatable interface {
+		GetVersion() int64
+		SetVersion(int64)
+		SetName(string)
+		SetMessage(string)
+		SetIsEnabled(bool)
+		SetTags([]string)
+	}
+	var target updatable
+	switch {
+	case rule.SecurityMonitoringStandardRuleResponse != nil:
+		target = rule.SecurityMonitoringStandardRuleResponse
+	case rule.SecurityMonitoringSignalRuleResponse != nil:
+		target = rule.SecurityMonitoringSignalRuleResponse
+	default:
+		return datadogV2.SecurityMonitoringRuleResponse{}, storeResponse(_nethttp.StatusBadRequest), _fmt.Errorf("rule %s cannot be updated in memory", ruleID)
+	}
+
+	if body.Version != nil && int64(*body.Version) != target.GetVersion() {
+		return datadogV2.SecurityMonitoringRuleResponse{}, storeResponse(_nethttp.StatusConflict),
+			_fmt.Errorf("rule %s is at version %d, not %d", ruleID, target.GetVersion(), *body.Version)
+	}
+	if body.Name != nil {
+		target.SetName(*body.Name)
+	}
+	if body.Message != nil {
+		target.SetMessage(*body.Message)
+	}
+	if body.IsEnabled != nil {
+		target.SetIsEnabled(*body.IsEnabled)
+	}
+	if body.Tags != nil {
+		target.SetTags(append([]string{}, body.Tags...))
+	}
+	target.SetVersion(target.GetVersion() + 1)
+
+	return copyRuleResponse(rule), storeResponse(_nethttp.StatusOK), nil
+}
+
+// copyRuleResponse deep-copies a rule through JSON so callers never share state with the store
+func copyRuleResponse(rule datadogV2.SecurityMonitoringRuleResponse) datadogV2.SecurityMonitoringRuleResponse {
+	data, err := datadog.Marshal(rule)
+	if err != nil {
+		return rule
+	}
+	var copied datadogV2.SecurityMonitoringRuleResponse
+	if err := datadog.Unmarshal(data, &copied); err != nil {
+		return rule
+	}
+	return copied
+}
+
+// SnapshotRuleStore serves rules from a local JSON snapshot and refuses updates
+type SnapshotRuleStore struct {
+	rules *MemoryRuleStore
+	file  string
+}
+
+// NewSnapshotRuleStore returns a read-only store of rules
+func NewSnapshotRuleStore(rules ...datadogV2.SecurityMonitoringRuleResponse) (*SnapshotRuleStore, error) {
+	memory, err := NewMemoryRuleStore(rules...)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotRuleStore{rules: memory}, nil
+}
+
+// LoadSnapshotRuleStore reads a snapshot of API rules: a list response ({"data": [...]}) as returned by
+// GET /api/v2/security_monitoring/rules, or a JSON array of rules
+func LoadSnapshotRuleStore(ctx _context.Context, filename string) (*SnapshotRuleStore, error) {
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, _fmt.Errorf("failed to read rule snapshot %s: %w", filename, err)
+	}
+
+	var rules []datadogV2.SecurityMonitoringRuleResponse
+	var list struct {
+		Data *[]datadogV2.SecurityMonitoringRuleResponse `json:"data"`
+	}
+	if err := _encodingjson.Unmarshal(data, &rules); err != nil {
+		if listErr := _encodingjson.Unmarshal(data, &list); listErr != nil || list.Data == nil {
+			return nil, NewInputFormatError("LoadSnapshotRuleStore",
+				_fmt.Errorf("rule snapshot %s is neither a rule list response nor an array of rules: %w", filename, err))
+		}
+		rules = *list.Data
+	}
+
+	store, err := NewSnapshotRuleStore(rules...)
+	if err != nil {
+		return nil, NewInputFormatError("LoadSnapshotRuleStore", _fmt.Errorf("rule snapshot %s: %w", filename, err))
+	}
+	store.file = filename
+	LoggerFrom(ctx).Debug("Loaded rule snapshot", "file", filename, "rules", store.rules.Len())
+	return store, nil
+}
+
+// ListSecurityMonitoringRules returns one page of the snapshot's rules
+func (s *SnapshotRuleStore) ListSecurityMonitoringRules(ctx _context.Context, o ...datadogV2.ListSecurityMonitoringRulesOptionalParameters) (datadogV2.SecurityMonitoringListRulesResponse, *_nethttp.Response, error) {
+	return s.rules.ListSecurityMonitoringRules(ctx, o...)
+}
+
+// GetSecurityMonitoringRule returns a rule of the snapshot
+func (s *SnapshotRuleStore) GetSecurityMonitoringRule(ctx _context.Context, ruleID string) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+	return s.rules.GetSecurityMonitoringRule(ctx, ruleID)
+}
+
+// UpdateSecurityMonitoringRule refuses the update with an error wrapping ErrReadOnlyStore
+func (s *SnapshotRuleStore) UpdateSecurityMonitoringRule(_ _context.Context, ruleID string, _ datadogV2.SecurityMonitoringRuleUpdatePayload) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+	return datadogV2.SecurityMonitoringRuleResponse{}, storeResponse(_nethttp.StatusForbidden),
+		_fmt.Errorf("cannot update rule %s: %w", ruleID, ErrReadOnlyStore)
+}
@@ -0,0 +1,180 @@
+package extV2
+
+import (
+	_context "context"
+	_errors "errors"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// testStoreRules returns three standard rules at version 1
+func testStoreRules() []datadogV2.SecurityMonitoringRuleResponse {
+	return []datadogV2.SecurityMonitoringRuleResponse{
+		RuleFromSimplified(SimplifiedRule{ID: "abc-123", Name: "Rule A", IsDefault: true, Tags: []string{"source:okta"}}),
+		RuleFromSimplified(SimplifiedRule{ID: "def-456", Name: "Rule B"}),
+		RuleFromSimplified(SimplifiedRule{ID: "ghi-789", Name: "Rule C", IsDefault: true}),
+	}
+}
+
+func TestMemoryRuleStoreUpdate(t *_testing.T) {
+	ctx := _context.Background()
+	version := func(v int32) *int32 { return &v }
+
+	tests := []struct {
+		name        string
+		ruleID      string
+		body        datadogV2.SecurityMonitoringRuleUpdatePayload
+		wantErr     error
+		wantStatus  int
+		wantTags    []string
+		wantVersion int64
+	}{
+		{name: "tags at the current version", ruleID: "abc-123", body: datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{"team:a"}, Version: version(1)}, wantStatus: 200, wantTags: []string{"team:a"}, wantVersion: 2},
+		{name: "without a version", ruleID: "abc-123", body: datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{"team:b"}}, wantStatus: 200, wantTags: []string{"team:b"}, wantVersion: 2},
+		{name: "stale version", ruleID: "abc-123", body: datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{"team:a"}, Version: version(0)}, wantErr: ErrConflict, wantStatus: 409, wantTags: []string{"source:okta"}, wantVersion: 1},
+		{name: "unknown rule", ruleID: "missing", body: datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{"team:a"}}, wantErr: ErrNotFound, wantStatus: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			store, err := NewMemoryRuleStore(testStoreRules()...)
+			if err != nil {
+				t.Fatalf("NewMemoryRuleStore() error = %v", err)
+			}
+			_, r, err := store.UpdateSecurityMonitoringRule(ctx, tt.ruleID, tt.body)
+			if r.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", r.StatusCode, tt.wantStatus)
+			}
+			// The store's statuses classify like the API's
+			if err != nil {
+				err = classifyAPIError(APICall{APIName: "MemoryRuleStore", MethodName: "UpdateSecurityMonitoringRule"}, r, err)
+			}
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !_errors.Is(err, tt.wantErr)) {
+				t.Fatalf("UpdateSecurityMonitoringRule() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantStatus == 404 {
+				return
+			}
+
+			rule, _, err := store.GetSecurityMonitoringRule(ctx, tt.ruleID)
+			if err != nil {
+				t.Fatalf("GetSecurityMonitoringRule() error = %v", err)
+			}
+			got := rule.SecurityMonitoringStandardRuleResponse
+			if !_reflect.DeepEqual(got.GetTags(), tt.wantTags) || got.GetVersion() != tt.wantVersion {
+				t.Errorf("rule has tags %v at version %d, want %v at %d", got.GetTags(), got.GetVersion(), tt.wantTags, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestMemoryRuleStoreList(t *_testing.T) {
+	ctx := _context.Background()
+	store, err := NewMemoryRuleStore(testStoreRules()...)
+	if err != nil {
+		t.Fatalf("NewMemoryRuleStore() error = %v", err)
+	}
+	if _, err := NewMemoryRuleStore(RuleFromSimplified(SimplifiedRule{Name: "No ID"})); err == nil {
+		t.Error("NewMemoryRuleStore() accepted a rule without an ID")
+	}
+
+	tests := []struct {
+		pageSize   int64
+		pageNumber int64
+		wantIDs    []string
+		wantErr    bool
+	}{
+		{pageSize: 2, pageNumber: 0, wantIDs: []string{"abc-123", "def-456"}},
+		{pageSize: 2, pageNumber: 1, wantIDs: []string{"ghi-789"}},
+		{pageSize: 2, pageNumber: 2, wantIDs: []string{}},
+		{pageSize: 0, pageNumber: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		params := datadogV2.NewListSecurityMonitoringRulesOptionalParameters().WithPageSize(tt.pageSize).WithPageNumber(tt.pageNumber)
+		response, _, err := store.ListSecurityMonitoringRules(ctx, *params)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("page %d of size %d: want an error", tt.pageNumber, tt.pageSize)
+			}
+			continue
+		}
+		ids := []string{}
+		for _, rule := range response.GetData() {
+			ids = append(ids, rule.SecurityMonitoringStandardRuleResponse.GetId())
+		}
+		if !_reflect.DeepEqual(ids, tt.wantIDs) || response.Meta.Page.GetTotalCount() != 3 {
+			t.Errorf("page %d of size %d = %v of %d, want %v of 3", tt.pageNumber, tt.pageSize, ids, response.Meta.Page.GetTotalCount(), tt.wantIDs)
+		}
+	}
+
+	// Listed rules are copies
+	response, _, _ := store.ListSecurityMonitoringRules(ctx)
+	response.Data[0].SecurityMonitoringStandardRuleResponse.SetTags([]string{"changed"})
+	rule, _, _ := store.GetSecurityMonitoringRule(ctx, "abc-123")
+	if tags := rule.SecurityMonitoringStandardRuleResponse.GetTags(); !_reflect.DeepEqual(tags, []string{"source:okta"}) {
+		t.Errorf("store rule tags = %v after changing a listed copy", tags)
+	}
+}
+
+func TestLoadSnapshotRuleStore(t *_testing.T) {
+	ctx := _context.Background()
+	rules := testStoreRules()
+	array, err := datadog.Marshal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := datadog.Marshal(datadogV2.SecurityMonitoringListRulesResponse{Data: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{name: "array of rules", data: string(array)},
+		{name: "list response", data: string(list)},
+		{name: "neither", data: `{"rules": []}`, wantErr: ErrInputFormat},
+		{name: "rule without an ID", data: `[{"name": "Rule A", "type": "log_detection"}]`, wantErr: ErrInputFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			filename := _pathfilepath.Join(dir, tt.name+".json")
+			if err := _os.WriteFile(filename, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := LoadSnapshotRuleStore(ctx, filename)
+			if tt.wantErr != nil {
+				if !_errors.Is(err, tt.wantErr) {
+					t.Errorf("LoadSnapshotRuleStore() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadSnapshotRuleStore() error = %v", err)
+			}
+
+			result, err := ProcessRuleListing(ctx, store, PaginationConfig{PageSize: 2}, OutputConfig{NoFiles: true})
+			if err != nil || len(result.Rules) != len(rules) {
+				t.Fatalf("ProcessRuleListing() = %v, %v; want %d rules", result, err, len(rules))
+			}
+			// A snapshot is read-only
+			_, _, err = store.UpdateSecurityMonitoringRule(ctx, "abc-123", datadogV2.SecurityMonitoringRuleUpdatePayload{Tags: []string{"team:a"}})
+			if !_errors.Is(err, ErrReadOnlyStore) {
+				t.Errorf("UpdateSecurityMonitoringRule() error = %v, want ErrReadOnlyStore", err)
+			}
+		})
+	}
+
+	if _, err := LoadSnapshotRuleStore(ctx, _pathfilepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadSnapshotRuleStore() read a missing file")
+	}
+}
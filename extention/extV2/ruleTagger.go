@@ -4,6 +4,8 @@ import (
 	_context "context"
 	_encodingjson "encoding/json"
 	_fmt "fmt"
+	_nethttp "net/http"
+	_sync "sync"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
 )
@@ -15,6 +17,7 @@ type TaggingResult struct {
 	Success  bool     `json:"success"`
 	OldTags  []string `json:"oldTags,omitempty"`
 	NewTags  []string `json:"newTags,omitempty"`
+	Changed  bool     `json:"changed"` // True when NewTags differ from OldTags
 	Error    string   `json:"error,omitempty"`
 }
 
@@ -113,8 +116,30 @@ Success Rate: %.2f%%
 	return summary
 }
 
-// TagSingleStandardRule tags a single security monitoring rule
-func TagSingleStandardRule(ctx _context.Context, api *datadogV2.SecurityMonitoringApi, matchedRule MatchedRule, config TaggingConfig) TaggingResult {
+// TagSingleStandardRule tags a single security monitoring rule. A live write goes through
+// CheckWriteSafety like a batch: without confirmation the rule is left unchanged and Error says why.
+// Each call is checked on its own, so a loop over rules asks for one confirmation per rule and
+// MAX_CHANGES never sees their total; tag several rules with TagRulesFromMatchResult instead,
+// which checks the whole change set once.
+func TagSingleStandardRule(ctx _context.Context, api RuleStore, matchedRule MatchedRule, config TaggingConfig) TaggingResult {
+	result := planStandardRuleTags(ctx, api, matchedRule, config, OutputConfig{})
+	if result.Error != "" {
+		return result
+	}
+
+	if !config.DryRun && result.Changed {
+		// One rule has no catalogue to measure MAX_CHANGES_PERCENT against, so only MAX_CHANGES and the confirmation apply
+		if err := CheckWriteSafety(config.Safety, 1, 0); err != nil {
+			result.Error = _fmt.Sprintf("Refused to update rule: %v", err)
+			return result
+		}
+	}
+	applyStandardRuleTags(ctx, api, &result, config)
+	return result
+}
+
+// planStandardRuleTags fetches the current tags of a rule and computes the merged tags without writing
+func planStandardRuleTags(ctx _context.Context, api RuleStore, matchedRule MatchedRule, config TaggingConfig, output OutputConfig) TaggingResult {
 	result := TaggingResult{
 		RuleID:   matchedRule.ID,
 		RuleName: matchedRule.Name,
@@ -133,31 +158,69 @@ func TagSingleStandardRule(ctx _context.Context, api *datadogV2.SecurityMonitori
 	// Merge tags
 	newTags := MergeTags(existingTags, matchedRule.Tags, config)
 	result.NewTags = newTags
+	result.Changed = !equalTags(existingTags, newTags)
 
-	// If dry run, don't make actual API call
-	if config.DryRun {
+	output.emit(Event{
+		Type:     EventTagPlanned,
+		Stage:    StageTagging,
+		RuleID:   matchedRule.ID,
+		RuleName: matchedRule.Name,
+		OldTags:  existingTags,
+		Tags:     newTags,
+		DryRun:   config.DryRun,
+	})
+
+	return result
+}
+
+// applyStandardRuleTags writes the planned tags of a rule unless this is a dry run or nothing changed
+func applyStandardRuleTags(ctx _context.Context, api RuleStore, result *TaggingResult, config TaggingConfig) {
+	// If dry run or the rule already has the planned tags, don't make actual API call
+	if config.DryRun || !result.Changed {
 		result.Success = true
-		return result
+		return
 	}
 
 	// Create update payload with only tags changed
 	updatePayload := datadogV2.SecurityMonitoringRuleUpdatePayload{
-		Tags: newTags,
+		Tags: result.NewTags,
 	}
 
 	// Update the rule
-	_, _, err = api.UpdateSecurityMonitoringRule(ctx, matchedRule.ID, updatePayload)
+	apiCall := NewAPICall("SecurityMonitoringApi", api.UpdateSecurityMonitoringRule)
+	_, _, err := CallAPI(ctx, apiCall, func(ctx _context.Context) (datadogV2.SecurityMonitoringRuleResponse, *_nethttp.Response, error) {
+		return api.UpdateSecurityMonitoringRule(ctx, result.RuleID, updatePayload)
+	})
 	if err != nil {
 		result.Error = _fmt.Sprintf("Failed to update rule: %v", err)
-		return result
+		return
 	}
 
 	result.Success = true
-	return result
 }
 
-// TagRulesFromMatchResult tags all rules from a MatchResult
-func TagRulesFromMatchResult(ctx _context.Context, api *datadogV2.SecurityMonitoringApi, matchResult *MatchResult, config TaggingConfig) (*BatchTaggingResult, error) {
+// equalTags reports whether two tag lists hold the same tags in the same order
+func equalTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TagRulesFromMatchResult tags all rules from a MatchResult. The safety guards and the confirmation
+// apply once, to the number of rules whose tags change in the whole batch.
+func TagRulesFromMatchResult(ctx _context.Context, api RuleStore, matchResult *MatchResult, config TaggingConfig) (*BatchTaggingResult, error) {
+	return tagRulesFromMatchResult(ctx, api, matchResult, config, OutputConfig{})
+}
+
+// tagRulesFromMatchResult plans every rule, applies the safety guards and then writes, emitting progress events.
+// At most config.MaxConcurrency rules are planned or written at once; results keep the order of the matches.
+func tagRulesFromMatchResult(ctx _context.Context, api RuleStore, matchResult *MatchResult, config TaggingConfig, output OutputConfig) (*BatchTaggingResult, error) {
 	batchResult := &BatchTaggingResult{
 		TotalRules:   len(matchResult.MatchedRules),
 		Results:      []TaggingResult{},
@@ -165,60 +228,175 @@ func TagRulesFromMatchResult(ctx _context.Context, api *datadogV2.SecurityMonito
 	}
 
 	if config.DryRun {
-		_fmt.Println("🔍 DRY RUN MODE - No actual changes will be made")
+		LoggerFrom(ctx).Info("Dry run mode, no actual changes will be made", humanKey, "🔍 DRY RUN MODE - No actual changes will be made")
 	}
 
-	_fmt.Printf("Starting to tag %d rules...\n", batchResult.TotalRules)
-
-	// Process each matched rule
-	for i, matchedRule := range matchResult.MatchedRules {
-		_fmt.Printf("Processing rule %d/%d: %s (ID: %s)\n",
-			i+1, batchResult.TotalRules, matchedRule.Name, matchedRule.ID)
+	LoggerFrom(ctx).Info("Starting to tag rules", "count", batchResult.TotalRules, "concurrency", config.concurrency())
 
-		// Skip rules with no tags to add
+	// Skip rules with no tags to add
+	toPlan := make([]MatchedRule, 0, len(matchResult.MatchedRules))
+	for _, matchedRule := range matchResult.MatchedRules {
 		if len(matchedRule.Tags) == 0 {
-			_fmt.Printf("  ⏭️  Skipping rule with no tags: %s\n", matchedRule.ID)
+			LoggerFrom(ctx).Info("Skipping rule with no tags", "ruleId", matchedRule.ID, humanKey, _fmt.Sprintf("  ⏭️  Skipping rule with no tags: %s", matchedRule.ID))
 			batchResult.SkippedRules = append(batchResult.SkippedRules, matchedRule.ID)
+			output.emit(Event{
+				Type:     EventRuleSkipped,
+				Stage:    StageTagging,
+				RuleID:   matchedRule.ID,
+				RuleName: matchedRule.Name,
+				Reason:   "no tags to add",
+			})
 			continue
 		}
+		toPlan = append(toPlan, matchedRule)
+	}
+
+	// Plan each matched rule before anything is written
+	batchResult.Results = make([]TaggingResult, len(toPlan))
+	forEachConcurrently(len(toPlan), config.concurrency(), func(i int) {
+		matchedRule := toPlan[i]
+		LoggerFrom(ctx).Info("Processing rule", "ruleId", matchedRule.ID, "index", i+1, "total", len(toPlan), "name", matchedRule.Name,
+			humanKey, _fmt.Sprintf("Processing rule %d/%d: %s", i+1, len(toPlan), matchedRule.Name))
+		batchResult.Results[i] = planStandardRuleTags(ctx, api, matchedRule, config, output)
+	})
+	rulesToWrite := 0
+	for _, result := range batchResult.Results {
+		if result.Changed {
+			rulesToWrite++
+		}
+	}
+
+	// Refuse to write before touching any rule if the change set is too large or unconfirmed
+	if !config.DryRun {
+		totalRules := matchResult.TotalResultRules
+		if totalRules == 0 {
+			totalRules = batchResult.TotalRules
+		}
+		if err := CheckWriteSafety(config.Safety, rulesToWrite, totalRules); err != nil {
+			return batchResult, err
+		}
+	}
+
+	// Apply the planned tags
+	var countMu _sync.Mutex
+	forEachConcurrently(len(batchResult.Results), config.concurrency(), func(i int) {
+		result := &batchResult.Results[i]
+		ruleLogger := LoggerFrom(ctx).With("ruleId", result.RuleID)
 
-		// Tag the rule
-		result := TagSingleStandardRule(ctx, api, matchedRule, config)
-		batchResult.Results = append(batchResult.Results, result)
+		if result.Error == "" {
+			applyStandardRuleTags(ctx, api, result, config)
+		}
 
+		countMu.Lock()
 		if result.Success {
 			batchResult.SuccessfulTags++
+		} else {
+			batchResult.FailedTags++
+		}
+		countMu.Unlock()
+
+		if result.Success {
 			if config.DryRun {
-				_fmt.Printf("  ✅ Would add tags: %v\n", matchedRule.Tags)
+				ruleLogger.Info("Would add tags", "tags", result.NewTags, humanKey, _fmt.Sprintf("  ✅ Would add tags: %v", result.NewTags))
+			} else if !result.Changed {
+				ruleLogger.Info("Already tagged", "tags", result.NewTags, humanKey, _fmt.Sprintf("  ✅ Already tagged with: %v", result.NewTags))
 			} else {
-				_fmt.Printf("  ✅ Successfully tagged with: %v\n", matchedRule.Tags)
+				ruleLogger.Info("Successfully tagged", "tags", result.NewTags, humanKey, _fmt.Sprintf("  ✅ Successfully tagged with: %v", result.NewTags))
+				output.emit(Event{
+					Type:     EventTagApplied,
+					Stage:    StageTagging,
+					RuleID:   result.RuleID,
+					RuleName: result.RuleName,
+					OldTags:  result.OldTags,
+					Tags:     result.NewTags,
+				})
 			}
 		} else {
-			batchResult.FailedTags++
-			_fmt.Printf("  ❌ Failed to tag: %s\n", result.Error)
+			ruleLogger.Error("Failed to tag", "error", result.Error, humanKey, _fmt.Sprintf("  ❌ Failed to tag: %s", result.Error))
+			output.emit(Event{
+				Type:     EventTagFailed,
+				Stage:    StageTagging,
+				RuleID:   result.RuleID,
+				RuleName: result.RuleName,
+				Error:    result.Error,
+			})
 		}
-	}
+	})
 
 	return batchResult, nil
 }
 
+// concurrency returns MaxConcurrency, or 1 for a config that leaves it unset
+func (c TaggingConfig) concurrency() int {
+	if c.MaxConcurrency < 1 {
+		return 1
+	}
+	return c.MaxConcurrency
+}
+
+// forEachConcurrently calls fn for every index below n, running at most limit calls at once
+func forEachConcurrently(n int, limit int, fn func(i int)) {
+	semaphore := make(chan struct{}, limit)
+	var wg _sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 // ProcessRuleTagging processes the complete rule tagging workflow
-func ProcessRuleTagging(ctx _context.Context, api *datadogV2.SecurityMonitoringApi, matchResult *MatchResult, config TaggingConfig) (*BatchTaggingResult, error) {
-	_fmt.Println("Starting rule tagging process...")
+func ProcessRuleTagging(ctx _context.Context, api RuleStore, matchResult *MatchResult, config TaggingConfig, output OutputConfig) (_ *BatchTaggingResult, err error) {
+	finishStage := output.startStage(StageTagging)
+	defer func() { finishStage(err) }()
+
+	LoggerFrom(ctx).Info("Starting rule tagging process...")
 
 	// Perform tagging
-	batchResult, err := TagRulesFromMatchResult(ctx, api, matchResult, config)
+	batchResult, err := tagRulesFromMatchResult(ctx, api, matchResult, config, output)
 	if err != nil {
-		return nil, _fmt.Errorf("failed to tag rules: %v", err)
+		// Keep the rejected plan so it can be reviewed before retrying
+		if batchResult != nil {
+			if _, saveErr := output.SaveResult(batchResult, StageTagging); saveErr != nil {
+				LoggerFrom(ctx).Warn("failed to save tagging plan", "error", saveErr)
+			}
+		}
+		return nil, _fmt.Errorf("failed to tag rules: %w", err)
 	}
 
 	// Save result
-	if _, err := SaveResultToFile(batchResult, "TaggingResult", "output", FormatSimplifiedResultAny); err != nil {
-		_fmt.Printf("Warning: failed to save tagging result: %v\n", err)
+	if _, err := output.SaveResult(batchResult, StageTagging); err != nil {
+		LoggerFrom(ctx).Warn("failed to save tagging result", "error", err)
+	}
+
+	// The cache still holds the tags the written rules had; a fresh cache would serve them on the next run
+	if !config.DryRun && config.RuleCacheFile != "" {
+		var written []string
+		for _, result := range batchResult.Results {
+			if result.Success {
+				written = append(written, result.RuleID)
+			}
+		}
+		if len(written) > 0 {
+			if err := InvalidateRuleCache(ctx, config.RuleCacheFile, output.Org, written, output); err != nil {
+				LoggerFrom(ctx).Warn("failed to invalidate rule cache", "error", err)
+			}
+		}
 	}
 
 	// Display summary
-	_fmt.Println(FormatTaggingSummary(batchResult, config))
+	LoggerFrom(ctx).Info("Rule tagging summary",
+		"dryRun", config.DryRun,
+		"totalRules", batchResult.TotalRules,
+		"successfulTags", batchResult.SuccessfulTags,
+		"failedTags", batchResult.FailedTags,
+		"skippedRules", len(batchResult.SkippedRules),
+		humanKey, FormatTaggingSummary(batchResult, config))
 
 	return batchResult, nil
 }
@@ -0,0 +1,103 @@
+package extV2
+
+import (
+	_bufio "bufio"
+	_errors "errors"
+	_fmt "fmt"
+	_io "io"
+	_strings "strings"
+)
+
+// ErrWritesNotConfirmed is returned when a live run was neither confirmed by flag nor interactively
+var ErrWritesNotConfirmed = _errors.New("live tagging requires CONFIRM_WRITES=true or an interactive confirmation")
+
+// ErrSafetyLimit is wrapped by the error returned when a change set exceeds MAX_CHANGES or MAX_CHANGES_PERCENT,
+// or when the interactive confirmation did not match
+var ErrSafetyLimit = _errors.New("aborted by safety guard")
+
+// WriteConfirmation describes the changes a live run is about to make
+type WriteConfirmation struct {
+	Site         string
+	Org          string
+	RulesToWrite int // Rules whose tags will change
+	TotalRules   int // Rules the change count is measured against
+}
+
+// Target returns the name the user has to type to confirm: the org if known, otherwise the site
+func (w WriteConfirmation) Target() string {
+	if w.Org != "" {
+		return w.Org
+	}
+	return w.Site
+}
+
+// Confirmer decides whether a live run may write; returning false aborts the run
+type Confirmer func(request WriteConfirmation) (bool, error)
+
+// SafetyConfig holds the guards applied before any rule is updated
+type SafetyConfig struct {
+	ConfirmWrites     bool      // Explicit opt-in for live writes without prompting
+	Confirm           Confirmer `json:"-"` // Optional interactive confirmation used when ConfirmWrites is false
+	MaxChanges        int       // Abort when more rules would change (0 means no limit)
+	MaxChangesPercent float64   // Abort when a larger share of rules would change (0 means no limit)
+	Site              string    // Datadog site shown in confirmations
+	Org               string    // Org name shown in confirmations
+}
+
+// NewInteractiveConfirmer returns a Confirmer that asks the user to type the target org or site
+func NewInteractiveConfirmer(in _io.Reader, out _io.Writer) Confirmer {
+	reader := _bufio.NewReader(in)
+	return func(request WriteConfirmation) (bool, error) {
+		_fmt.Fprintf(out, "\nAbout to update tags on %d of %d rules", request.RulesToWrite, request.TotalRules)
+		if request.Org != "" {
+			_fmt.Fprintf(out, " in org %q", request.Org)
+		}
+		_fmt.Fprintf(out, " on site %s.\n", request.Site)
+		_fmt.Fprintf(out, "Type %q to continue: ", request.Target())
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && !(_errors.Is(err, _io.EOF) && answer != "") {
+			return false, _fmt.Errorf("failed to read confirmation: %w", err)
+		}
+		return _strings.TrimSpace(answer) == request.Target(), nil
+	}
+}
+
+// CheckWriteSafety applies the max-changes guards and the confirmation requirement to a planned change set
+func CheckWriteSafety(config SafetyConfig, rulesToWrite int, totalRules int) error {
+	if rulesToWrite == 0 {
+		return nil
+	}
+
+	if config.MaxChanges > 0 && rulesToWrite > config.MaxChanges {
+		return _fmt.Errorf("%w: %d rules would change, more than MAX_CHANGES=%d", ErrSafetyLimit, rulesToWrite, config.MaxChanges)
+	}
+	if config.MaxChangesPercent > 0 && totalRules > 0 {
+		percent := float64(rulesToWrite) / float64(totalRules) * 100
+		if percent > config.MaxChangesPercent {
+			return _fmt.Errorf("%w: %.2f%% of rules (%d/%d) would change, more than MAX_CHANGES_PERCENT=%.2f",
+				ErrSafetyLimit, percent, rulesToWrite, totalRules, config.MaxChangesPercent)
+		}
+	}
+
+	if config.ConfirmWrites {
+		return nil
+	}
+	if config.Confirm == nil {
+		return ErrWritesNotConfirmed
+	}
+
+	confirmed, err := config.Confirm(WriteConfirmation{
+		Site:         config.Site,
+		Org:          config.Org,
+		RulesToWrite: rulesToWrite,
+		TotalRules:   totalRules,
+	})
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return _fmt.Errorf("%w: confirmation did not match", ErrSafetyLimit)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package extV2
+
+import (
+	_bytes "bytes"
+	_errors "errors"
+	_fmt "fmt"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestCheckWriteSafety(t *_testing.T) {
+	confirmWith := func(answer bool, err error) Confirmer {
+		return func(WriteConfirmation) (bool, error) { return answer, err }
+	}
+	errPrompt := _errors.New("no terminal")
+
+	tests := []struct {
+		name         string
+		config       SafetyConfig
+		rulesToWrite int
+		totalRules   int
+		wantErr      error
+	}{
+		{name: "nothing to write needs no confirmation", rulesToWrite: 0, totalRules: 10},
+		{name: "unconfirmed", rulesToWrite: 1, totalRules: 10, wantErr: ErrWritesNotConfirmed},
+		{name: "confirmed by flag", config: SafetyConfig{ConfirmWrites: true}, rulesToWrite: 1, totalRules: 10},
+		{name: "confirmed interactively", config: SafetyConfig{Confirm: confirmWith(true, nil)}, rulesToWrite: 1, totalRules: 10},
+		{name: "declined interactively", config: SafetyConfig{Confirm: confirmWith(false, nil)}, rulesToWrite: 1, totalRules: 10, wantErr: ErrSafetyLimit},
+		{name: "prompt failed", config: SafetyConfig{Confirm: confirmWith(true, errPrompt)}, rulesToWrite: 1, totalRules: 10, wantErr: errPrompt},
+		{name: "at MAX_CHANGES", config: SafetyConfig{ConfirmWrites: true, MaxChanges: 3}, rulesToWrite: 3, totalRules: 10},
+		{name: "over MAX_CHANGES", config: SafetyConfig{ConfirmWrites: true, MaxChanges: 3}, rulesToWrite: 4, totalRules: 10, wantErr: ErrSafetyLimit},
+		{name: "at MAX_CHANGES_PERCENT", config: SafetyConfig{ConfirmWrites: true, MaxChangesPercent: 50}, rulesToWrite: 5, totalRules: 10},
+		{name: "over MAX_CHANGES_PERCENT", config: SafetyConfig{ConfirmWrites: true, MaxChangesPercent: 50}, rulesToWrite: 6, totalRules: 10, wantErr: ErrSafetyLimit},
+		{name: "percent without a total", config: SafetyConfig{ConfirmWrites: true, MaxChangesPercent: 50}, rulesToWrite: 6},
+		{name: "limits apply before the confirmation", config: SafetyConfig{Confirm: confirmWith(true, nil), MaxChanges: 1}, rulesToWrite: 2, totalRules: 10, wantErr: ErrSafetyLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			err := CheckWriteSafety(tt.config, tt.rulesToWrite, tt.totalRules)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !_errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckWriteSafety() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInteractiveConfirmer(t *_testing.T) {
+	tests := []struct {
+		name    string
+		request WriteConfirmation
+		input   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "org typed", request: WriteConfirmation{Site: "datadoghq.eu", Org: "prod", RulesToWrite: 2, TotalRules: 9}, input: "prod\n", want: true},
+		{name: "site typed when the org is unknown", request: WriteConfirmation{Site: "datadoghq.eu"}, input: " datadoghq.eu \n", want: true},
+		{name: "site typed instead of the org", request: WriteConfirmation{Site: "datadoghq.eu", Org: "prod"}, input: "datadoghq.eu\n"},
+		{name: "yes is not enough", request: WriteConfirmation{Site: "datadoghq.eu", Org: "prod"}, input: "yes\n"},
+		{name: "answer without a newline", request: WriteConfirmation{Org: "prod"}, input: "prod", want: true},
+		{name: "no answer", request: WriteConfirmation{Org: "prod"}, input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			var out _bytes.Buffer
+			got, err := NewInteractiveConfirmer(_strings.NewReader(tt.input), &out)(tt.request)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("confirmer = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+			if !_strings.Contains(out.String(), _fmt.Sprintf("Type %q", tt.request.Target())) {
+				t.Errorf("prompt does not name the target:\n%s", out.String())
+			}
+		})
+	}
+}
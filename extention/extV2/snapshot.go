@@ -0,0 +1,136 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_fmt "fmt"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_strings "strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// LoadListingSnapshot reads a listing result written by ProcessRuleListing (a *_ListRulesResult.json file).
+// When filename is a directory, the most recently modified listing result in it is read.
+func LoadListingSnapshot(ctx _context.Context, filename string) (*PaginatedResult, string, error) {
+	info, err := _os.Stat(filename)
+	if err != nil {
+		return nil, "", _fmt.Errorf("failed to read listing snapshot %s: %w", filename, err)
+	}
+	if info.IsDir() {
+		if filename, err = latestListingSnapshot(filename); err != nil {
+			return nil, "", err
+		}
+	}
+
+	data, err := _os.ReadFile(filename)
+	if err != nil {
+		return nil, "", _fmt.Errorf("failed to read listing snapshot %s: %w", filename, err)
+	}
+	result := &PaginatedResult{}
+	if err := _encodingjson.Unmarshal(data, result); err != nil {
+		return nil, "", NewInputFormatError("LoadListingSnapshot", _fmt.Errorf("failed to parse listing snapshot %s: %w", filename, err))
+	}
+	if result.Rules == nil {
+		return nil, "", NewInputFormatError("LoadListingSnapshot", _fmt.Errorf("listing snapshot %s has no rules array", filename))
+	}
+
+	tagged := false
+	for i, rule := range result.Rules {
+		if rule.ID == "" {
+			return nil, "", NewInputFormatError("LoadListingSnapshot", _fmt.Errorf("listing snapshot %s: rule #%d has no id", filename, i+1))
+		}
+		tagged = tagged || len(rule.Tags) > 0
+	}
+	if !tagged && len(result.Rules) > 0 {
+		// Listings written before tags were recorded look like snapshots of untagged rules
+		LoggerFrom(ctx).Warn("Listing snapshot has no tags; plans assume every rule is untagged", "file", filename)
+	}
+
+	LoggerFrom(ctx).Info("Loaded listing snapshot", "file", filename, "rules", len(result.Rules))
+	return result, filename, nil
+}
+
+// latestListingSnapshot returns the most recently modified JSON listing result in dir
+func latestListingSnapshot(dir string) (string, error) {
+	entries, err := _os.ReadDir(dir)
+	if err != nil {
+		return "", _fmt.Errorf("failed to read snapshot directory %s: %w", dir, err)
+	}
+
+	latest := ""
+	var latestTime int64
+	for _, entry := range entries {
+		if entry.IsDir() || !_strings.Contains(entry.Name(), StageListing) || _pathfilepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if modified := info.ModTime().UnixNano(); latest == "" || modified > latestTime {
+			latest, latestTime = _pathfilepath.Join(dir, entry.Name()), modified
+		}
+	}
+	if latest == "" {
+		return "", _fmt.Errorf("no %s JSON file found in %s", StageListing, dir)
+	}
+	return latest, nil
+}
+
+// RuleFromSimplified builds a standard rule response carrying the fields of a listed rule
+func RuleFromSimplified(rule SimplifiedRule) datadogV2.SecurityMonitoringRuleResponse {
+	standard := datadogV2.NewSecurityMonitoringStandardRuleResponse()
+	standard.SetId(rule.ID)
+	standard.SetName(rule.Name)
+	standard.SetIsDefault(rule.IsDefault)
+	standard.SetTags(append([]string{}, rule.Tags...))
+	standard.SetType(datadogV2.SECURITYMONITORINGRULETYPEREAD_LOG_DETECTION)
+	version := rule.Version
+	if version == 0 {
+		version = 1
+	}
+	standard.SetVersion(version)
+	if rule.IsEnabled != nil {
+		standard.SetIsEnabled(*rule.IsEnabled)
+	}
+	return datadogV2.SecurityMonitoringStandardRuleResponseAsSecurityMonitoringRuleResponse(standard)
+}
+
+// NewListingRuleStore returns a read-only store serving the rules of a listing result
+func NewListingRuleStore(result *PaginatedResult) (*SnapshotRuleStore, error) {
+	rules := make([]datadogV2.SecurityMonitoringRuleResponse, len(result.Rules))
+	for i, rule := range result.Rules {
+		rules[i] = RuleFromSimplified(rule)
+	}
+	return NewSnapshotRuleStore(rules...)
+}
+
+// RunOffline matches the input against config.SnapshotFile and plans the tag changes without
+// credentials or network access. Planning is always a dry run; the plan is saved like a tagging result.
+func RunOffline(ctx _context.Context, config *Config) (*BatchTaggingResult, error) {
+	ctx = config.withCallContext(ctx)
+	listResult, snapshotFile, err := LoadListingSnapshot(ctx, config.SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewListingRuleStore(listResult)
+	if err != nil {
+		return nil, err
+	}
+
+	matchResult, err := ProcessRuleMatching(ctx, config.InputRuleFilename, listResult, config.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	tagging := config.Tagging
+	tagging.DryRun = true
+	plan, err := ProcessRuleTagging(ctx, store, matchResult, tagging, config.Output)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFrom(ctx).Info("Planned tag changes offline", "snapshot", snapshotFile, "matches", matchResult.TotalMatches, "planned", plan.SuccessfulTags)
+	return plan, nil
+}
@@ -0,0 +1,248 @@
+package extV2
+
+import (
+	_context "context"
+	_fmt "fmt"
+	_sort "sort"
+	_strings "strings"
+)
+
+// StageSnapshotDiff names a diff of two listing snapshots in output files and the run manifest
+const StageSnapshotDiff = "SnapshotDiffResult"
+
+// Ways a rule of the new snapshot was matched to the old one
+const (
+	MatchedByID   = "id"
+	MatchedByName = "name" // The ID changed, as when Datadog recreates a default rule
+)
+
+// RuleRename is a rule whose name changed between two snapshots
+type RuleRename struct {
+	ID        string `json:"id"`
+	IsDefault bool   `json:"isDefault"`
+	OldName   string `json:"oldName"`
+	NewName   string `json:"newName"`
+}
+
+// RuleSnapshotChange lists what changed in a rule present in both snapshots.
+// Fields a snapshot did not record are not compared.
+type RuleSnapshotChange struct {
+	ID             string   `json:"id"`
+	OldID          string   `json:"oldId,omitempty"` // Set when the rule was matched by name
+	Name           string   `json:"name"`
+	IsDefault      bool     `json:"isDefault"`
+	MatchedBy      string   `json:"matchedBy"`
+	OldEnabled     *bool    `json:"oldEnabled,omitempty"` // Set with NewEnabled when the enabled state changed
+	NewEnabled     *bool    `json:"newEnabled,omitempty"`
+	AddedTags      []string `json:"addedTags,omitempty"`
+	RemovedTags    []string `json:"removedTags,omitempty"`
+	QueriesChanged bool     `json:"queriesChanged,omitempty"`
+	CasesChanged   bool     `json:"casesChanged,omitempty"`
+	OldVersion     int64    `json:"oldVersion,omitempty"` // Set with NewVersion when the version was bumped
+	NewVersion     int64    `json:"newVersion,omitempty"`
+}
+
+// Summary describes the change in a few words, such as "enabled, tags, version 3 -> 4"
+func (c RuleSnapshotChange) Summary() string {
+	var parts []string
+	if c.OldID != "" {
+		parts = append(parts, _fmt.Sprintf("id %s -> %s", c.OldID, c.ID))
+	}
+	if c.NewEnabled != nil {
+		if *c.NewEnabled {
+			parts = append(parts, "enabled")
+		} else {
+			parts = append(parts, "disabled")
+		}
+	}
+	if len(c.AddedTags) > 0 || len(c.RemovedTags) > 0 {
+		parts = append(parts, "tags")
+	}
+	if c.QueriesChanged {
+		parts = append(parts, "queries")
+	}
+	if c.CasesChanged {
+		parts = append(parts, "cases")
+	}
+	if c.NewVersion != 0 {
+		parts = append(parts, _fmt.Sprintf("version %d -> %d", c.OldVersion, c.NewVersion))
+	}
+	return _strings.Join(parts, ", ")
+}
+
+// SnapshotDiff is the difference between two listing snapshots, sorted by rule name
+type SnapshotDiff struct {
+	OldSnapshot string               `json:"oldSnapshot"`
+	NewSnapshot string               `json:"newSnapshot"`
+	OldRules    int                  `json:"oldRules"`
+	NewRules    int                  `json:"newRules"`
+	Added       []SimplifiedRule     `json:"added"`
+	Removed     []SimplifiedRule     `json:"removed"`
+	Renamed     []RuleRename         `json:"renamed"`
+	Changed     []RuleSnapshotChange `json:"changed"`
+}
+
+// DiffSnapshots matches the rules of two listings by ID, then the rules left over by name and
+// isDefault, and reports added, removed, renamed and changed rules
+func DiffSnapshots(oldResult *PaginatedResult, newResult *PaginatedResult) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		OldRules: len(oldResult.Rules),
+		NewRules: len(newResult.Rules),
+		Added:    []SimplifiedRule{},
+		Removed:  []SimplifiedRule{},
+		Renamed:  []RuleRename{},
+		Changed:  []RuleSnapshotChange{},
+	}
+
+	oldByID := make(map[string]SimplifiedRule, len(oldResult.Rules))
+	for _, rule := range oldResult.Rules {
+		oldByID[rule.ID] = rule
+	}
+
+	matchedOld := make(map[string]bool)
+	var unmatchedNew []SimplifiedRule
+	for _, newRule := range newResult.Rules {
+		oldRule, ok := oldByID[newRule.ID]
+		if !ok {
+			unmatchedNew = append(unmatchedNew, newRule)
+			continue
+		}
+		matchedOld[oldRule.ID] = true
+		if oldRule.Name != newRule.Name {
+			diff.Renamed = append(diff.Renamed, RuleRename{ID: newRule.ID, IsDefault: newRule.IsDefault, OldName: oldRule.Name, NewName: newRule.Name})
+		}
+		diff.addChange(oldRule, newRule, MatchedByID)
+	}
+
+	// Fall back to name and isDefault for rules whose ID changed
+	oldByKey := make(map[string]SimplifiedRule)
+	for _, rule := range oldResult.Rules {
+		if !matchedOld[rule.ID] {
+			oldByKey[RuleMatchKey(rule.Name, rule.IsDefault)] = rule
+		}
+	}
+	for _, newRule := range unmatchedNew {
+		key := RuleMatchKey(newRule.Name, newRule.IsDefault)
+		oldRule, ok := oldByKey[key]
+		if !ok {
+			diff.Added = append(diff.Added, newRule)
+			continue
+		}
+		delete(oldByKey, key)
+		matchedOld[oldRule.ID] = true
+		diff.addChange(oldRule, newRule, MatchedByName)
+	}
+
+	for _, rule := range oldResult.Rules {
+		if !matchedOld[rule.ID] {
+			diff.Removed = append(diff.Removed, rule)
+		}
+	}
+
+	sortRules := func(rules []SimplifiedRule) {
+		_sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	}
+	sortRules(diff.Added)
+	sortRules(diff.Removed)
+	_sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].NewName < diff.Renamed[j].NewName })
+	_sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Name < diff.Changed[j].Name })
+	return diff
+}
+
+// addChange records what differs between two matched rules, if anything
+func (d *SnapshotDiff) addChange(oldRule SimplifiedRule, newRule SimplifiedRule, matchedBy string) {
+	change := RuleSnapshotChange{
+		ID:        newRule.ID,
+		Name:      newRule.Name,
+		IsDefault: newRule.IsDefault,
+		MatchedBy: matchedBy,
+	}
+	changed := false
+
+	if matchedBy == MatchedByName {
+		change.OldID = oldRule.ID
+		changed = true
+	}
+	if oldRule.IsEnabled != nil && newRule.IsEnabled != nil && *oldRule.IsEnabled != *newRule.IsEnabled {
+		change.OldEnabled, change.NewEnabled = oldRule.IsEnabled, newRule.IsEnabled
+		changed = true
+	}
+	if tags := diffTags(oldRule.Tags, newRule.Tags); len(tags.Added) > 0 || len(tags.Removed) > 0 {
+		change.AddedTags, change.RemovedTags = tags.Added, tags.Removed
+		changed = true
+	}
+	if oldRule.QueriesHash != "" && newRule.QueriesHash != "" && oldRule.QueriesHash != newRule.QueriesHash {
+		change.QueriesChanged = true
+		changed = true
+	}
+	if oldRule.CasesHash != "" && newRule.CasesHash != "" && oldRule.CasesHash != newRule.CasesHash {
+		change.CasesChanged = true
+		changed = true
+	}
+	if oldRule.Version != 0 && newRule.Version != 0 && oldRule.Version != newRule.Version {
+		change.OldVersion, change.NewVersion = oldRule.Version, newRule.Version
+		changed = true
+	}
+
+	if changed {
+		d.Changed = append(d.Changed, change)
+	}
+}
+
+// FormatSnapshotDiffSummary formats the diff counts for display
+func FormatSnapshotDiffSummary(diff *SnapshotDiff) string {
+	return _fmt.Sprintf("\n=== Snapshot Diff ===\n"+
+		"Old Snapshot: %s (%d rules)\n"+
+		"New Snapshot: %s (%d rules)\n"+
+		"Added: %d\n"+
+		"Removed: %d\n"+
+		"Renamed: %d\n"+
+		"Changed: %d\n",
+		diff.OldSnapshot, diff.OldRules,
+		diff.NewSnapshot, diff.NewRules,
+		len(diff.Added),
+		len(diff.Removed),
+		len(diff.Renamed),
+		len(diff.Changed))
+}
+
+// DiffListingSnapshots diffs two listing snapshots (files or directories, as read by LoadListingSnapshot)
+// and saves the diff as JSON and Markdown, plus any other configured output format
+func DiffListingSnapshots(ctx _context.Context, oldFile string, newFile string, output OutputConfig) (_ *SnapshotDiff, err error) {
+	finishStage := output.startStage(StageSnapshotDiff)
+	defer func() { finishStage(err) }()
+
+	oldResult, oldFile, err := LoadListingSnapshot(ctx, oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newResult, newFile, err := LoadListingSnapshot(ctx, newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := DiffSnapshots(oldResult, newResult)
+	diff.OldSnapshot, diff.NewSnapshot = oldFile, newFile
+
+	output = output.withDefaults()
+	for _, format := range []OutputFormat{OutputFormatJSON, OutputFormatMarkdown} {
+		found := false
+		for _, configured := range output.Formats {
+			found = found || configured == format
+		}
+		if !found {
+			output.Formats = append(append([]OutputFormat{}, output.Formats...), format)
+		}
+	}
+	if _, err := output.SaveResult(diff, StageSnapshotDiff); err != nil {
+		return diff, _fmt.Errorf("failed to save snapshot diff: %w", err)
+	}
+
+	LoggerFrom(ctx).Info("Snapshot diff summary",
+		"added", len(diff.Added),
+		"removed", len(diff.Removed),
+		"renamed", len(diff.Renamed),
+		"changed", len(diff.Changed),
+		humanKey, FormatSnapshotDiffSummary(diff))
+	return diff, nil
+}
@@ -0,0 +1,142 @@
+package extV2
+
+import (
+	_context "context"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_strings "strings"
+	_testing "testing"
+)
+
+func TestDiffSnapshots(t *_testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name        string
+		old         []SimplifiedRule
+		new         []SimplifiedRule
+		wantAdded   []string // Rule IDs
+		wantRemoved []string
+		wantRenamed []RuleRename
+		wantChanged []string // Change summaries
+	}{
+		{
+			name: "unchanged",
+			old:  []SimplifiedRule{{ID: "a", Name: "Rule A", Tags: []string{"x:1"}, Version: 2}},
+			new:  []SimplifiedRule{{ID: "a", Name: "Rule A", Tags: []string{"x:1"}, Version: 2}},
+		},
+		{
+			name:        "added and removed",
+			old:         []SimplifiedRule{{ID: "a", Name: "Rule A"}},
+			new:         []SimplifiedRule{{ID: "b", Name: "Rule B"}},
+			wantAdded:   []string{"b"},
+			wantRemoved: []string{"a"},
+		},
+		{
+			name:        "renamed keeps its ID",
+			old:         []SimplifiedRule{{ID: "a", Name: "Rule A", IsDefault: true}},
+			new:         []SimplifiedRule{{ID: "a", Name: "Rule A (v2)", IsDefault: true}},
+			wantRenamed: []RuleRename{{ID: "a", IsDefault: true, OldName: "Rule A", NewName: "Rule A (v2)"}},
+		},
+		{
+			name:        "recreated rule matched by name",
+			old:         []SimplifiedRule{{ID: "a", Name: "Rule A", IsDefault: true}},
+			new:         []SimplifiedRule{{ID: "a2", Name: "Rule A", IsDefault: true}},
+			wantChanged: []string{"id a -> a2"},
+		},
+		{
+			name:        "same name but not default is a different rule",
+			old:         []SimplifiedRule{{ID: "a", Name: "Rule A", IsDefault: true}},
+			new:         []SimplifiedRule{{ID: "a2", Name: "Rule A"}},
+			wantAdded:   []string{"a2"},
+			wantRemoved: []string{"a"},
+		},
+		{
+			name:        "every field changed",
+			old:         []SimplifiedRule{{ID: "a", Name: "Rule A", IsEnabled: &enabled, Tags: []string{"x:1"}, QueriesHash: "q1", CasesHash: "c1", Version: 3}},
+			new:         []SimplifiedRule{{ID: "a", Name: "Rule A", IsEnabled: &disabled, Tags: []string{"y:2"}, QueriesHash: "q2", CasesHash: "c2", Version: 4}},
+			wantChanged: []string{"disabled, tags, queries, cases, version 3 -> 4"},
+		},
+		{
+			name: "fields a snapshot did not record are not compared",
+			old:  []SimplifiedRule{{ID: "a", Name: "Rule A"}},
+			new:  []SimplifiedRule{{ID: "a", Name: "Rule A", IsEnabled: &enabled, QueriesHash: "q1", Version: 2}},
+		},
+	}
+
+	ids := func(rules []SimplifiedRule) []string {
+		result := []string{}
+		for _, rule := range rules {
+			result = append(result, rule.ID)
+		}
+		return result
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			diff := DiffSnapshots(&PaginatedResult{Rules: tt.old}, &PaginatedResult{Rules: tt.new})
+
+			want := func(values []string) []string {
+				if values == nil {
+					return []string{}
+				}
+				return values
+			}
+			if got := ids(diff.Added); !_reflect.DeepEqual(got, want(tt.wantAdded)) {
+				t.Errorf("Added = %v, want %v", got, tt.wantAdded)
+			}
+			if got := ids(diff.Removed); !_reflect.DeepEqual(got, want(tt.wantRemoved)) {
+				t.Errorf("Removed = %v, want %v", got, tt.wantRemoved)
+			}
+			if tt.wantRenamed == nil {
+				tt.wantRenamed = []RuleRename{}
+			}
+			if !_reflect.DeepEqual(diff.Renamed, tt.wantRenamed) {
+				t.Errorf("Renamed = %+v, want %+v", diff.Renamed, tt.wantRenamed)
+			}
+			changes := []string{}
+			for _, change := range diff.Changed {
+				changes = append(changes, change.Summary())
+			}
+			if !_reflect.DeepEqual(changes, want(tt.wantChanged)) {
+				t.Errorf("Changed = %q, want %q", changes, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestDiffListingSnapshots(t *_testing.T) {
+	dir := t.TempDir()
+	oldFile := writeListingSnapshot(t, dir, "old_ListRulesResult.json", &PaginatedResult{Rules: []SimplifiedRule{{ID: "a", Name: "Rule A", Tags: []string{"x:1"}}}})
+	newFile := writeListingSnapshot(t, dir, "new_ListRulesResult.json", &PaginatedResult{Rules: []SimplifiedRule{{ID: "a", Name: "Rule A", Tags: []string{"x:2"}}}})
+	outputDir := _pathfilepath.Join(dir, "diffs")
+
+	diff, err := DiffListingSnapshots(_context.Background(), oldFile, newFile, OutputConfig{Dir: outputDir})
+	if err != nil {
+		t.Fatalf("DiffListingSnapshots() error = %v", err)
+	}
+	if diff.OldSnapshot != oldFile || diff.NewSnapshot != newFile || len(diff.Changed) != 1 {
+		t.Errorf("DiffListingSnapshots() = %+v", diff)
+	}
+
+	// JSON and Markdown are always written
+	entries, err := _os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var extensions []string
+	for _, entry := range entries {
+		if !_strings.Contains(entry.Name(), StageSnapshotDiff) {
+			t.Errorf("unexpected output file %s", entry.Name())
+		}
+		extensions = append(extensions, _pathfilepath.Ext(entry.Name()))
+	}
+	if !_reflect.DeepEqual(extensions, []string{".json", ".md"}) {
+		t.Errorf("wrote %v, want JSON and Markdown", extensions)
+	}
+
+	if _, err := DiffListingSnapshots(_context.Background(), oldFile, _pathfilepath.Join(dir, "missing.json"), OutputConfig{NoFiles: true}); err == nil {
+		t.Error("DiffListingSnapshots() succeeded without the new snapshot")
+	}
+}
@@ -0,0 +1,120 @@
+package extV2
+
+import (
+	_context "context"
+	_encodingjson "encoding/json"
+	_errors "errors"
+	_os "os"
+	_pathfilepath "path/filepath"
+	_reflect "reflect"
+	_testing "testing"
+	_time "time"
+)
+
+// writeListingSnapshot writes result as a listing snapshot and returns its filename
+func writeListingSnapshot(t *_testing.T, dir string, name string, result *PaginatedResult) string {
+	t.Helper()
+	data, err := _encodingjson.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := _pathfilepath.Join(dir, name)
+	writeFile(t, filename, string(data))
+	return filename
+}
+
+func TestLoadListingSnapshot(t *_testing.T) {
+	ctx := _context.Background()
+	dir := t.TempDir()
+	older := writeListingSnapshot(t, dir, "2024-01-01_ListRulesResult.json", &PaginatedResult{Rules: []SimplifiedRule{{ID: "old", Name: "Old"}}})
+	newer := writeListingSnapshot(t, dir, "2024-02-01_ListRulesResult.json", &PaginatedResult{Rules: []SimplifiedRule{{ID: "new", Name: "New", Tags: []string{"team:a"}}}})
+	writeFile(t, _pathfilepath.Join(dir, "2024-03-01_TaggingResult.json"), "{}")
+	at := _time.Now()
+	if err := _os.Chtimes(older, at.Add(-_time.Hour), at.Add(-_time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := _os.Chtimes(newer, at, at); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, _pathfilepath.Join(dir, "no-rules.json"), `{"totalRules": 0}`)
+	writeFile(t, _pathfilepath.Join(dir, "no-id.json"), `{"rules": [{"name": "Rule A"}]}`)
+	emptyDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		filename string
+		wantFile string
+		wantID   string
+		wantErr  error
+	}{
+		{name: "file", filename: older, wantFile: older, wantID: "old"},
+		{name: "directory picks the latest listing", filename: dir, wantFile: newer, wantID: "new"},
+		{name: "no rules array", filename: _pathfilepath.Join(dir, "no-rules.json"), wantErr: ErrInputFormat},
+		{name: "rule without an ID", filename: _pathfilepath.Join(dir, "no-id.json"), wantErr: ErrInputFormat},
+		{name: "directory without listings", filename: emptyDir},
+		{name: "missing file", filename: _pathfilepath.Join(dir, "missing.json")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *_testing.T) {
+			result, filename, err := LoadListingSnapshot(ctx, tt.filename)
+			if tt.wantFile == "" {
+				if err == nil || (tt.wantErr != nil && !_errors.Is(err, tt.wantErr)) {
+					t.Errorf("LoadListingSnapshot() error = %v, want an error (%v)", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadListingSnapshot() error = %v", err)
+			}
+			if filename != tt.wantFile || len(result.Rules) != 1 || result.Rules[0].ID != tt.wantID {
+				t.Errorf("LoadListingSnapshot() = %+v from %s, want %s from %s", result.Rules, filename, tt.wantID, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestRunOffline(t *_testing.T) {
+	dir := t.TempDir()
+	snapshot := writeListingSnapshot(t, dir, "ListRulesResult.json", &PaginatedResult{Rules: []SimplifiedRule{
+		{ID: "abc-123", Name: "Rule A", IsDefault: true, Tags: []string{"source:okta"}},
+		{ID: "def-456", Name: "Rule B", IsDefault: true, Tags: []string{"team:b"}},
+		{ID: "ghi-789", Name: "Rule C"},
+	}})
+	input := _pathfilepath.Join(dir, "input.json")
+	writeFile(t, input, `{"rules": [
+		{"name": "Rule A", "isDefault": true, "tags": ["team:a"]},
+		{"name": "Rule C", "isDefault": false, "tags": ["team:c"]},
+		{"name": "Rule D", "isDefault": true, "tags": ["team:d"]}
+	]}`)
+
+	// No keys, no site and no confirmation: offline planning needs none of them
+	config := &Config{
+		SnapshotFile:      snapshot,
+		InputRuleFilename: input,
+		Tagging:           TaggingConfig{MaxConcurrency: 2},
+		Output:            OutputConfig{NoFiles: true},
+	}
+	plan, err := RunOffline(_context.Background(), config)
+	if err != nil {
+		t.Fatalf("RunOffline() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"abc-123": {"source:okta", "team:a"},
+		"ghi-789": {"team:c"},
+	}
+	if plan.SuccessfulTags != len(want) || len(plan.Results) != len(want) {
+		t.Fatalf("RunOffline() planned %d changes: %+v, want %d", plan.SuccessfulTags, plan.Results, len(want))
+	}
+	for _, result := range plan.Results {
+		if !result.Success || !_reflect.DeepEqual(result.NewTags, want[result.RuleID]) {
+			t.Errorf("plan for %s = %+v, want new tags %v", result.RuleID, result, want[result.RuleID])
+		}
+	}
+
+	config.SnapshotFile = _pathfilepath.Join(dir, "missing.json")
+	if _, err := RunOffline(_context.Background(), config); err == nil {
+		t.Error("RunOffline() succeeded without a snapshot")
+	}
+}
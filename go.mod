@@ -2,7 +2,10 @@ module github.com/kkumtree/dd-security-rule-extension-go/v2
 
 go 1.22
 
-require github.com/DataDog/datadog-api-client-go/v2 v2.44.0
+require (
+	github.com/DataDog/datadog-api-client-go/v2 v2.44.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/DataDog/zstd v1.5.2 // indirect